@@ -0,0 +1,330 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A single recorded activity interval. EndTime is nil while the activity is ongoing.
+type ActivityRecord struct {
+	ID        int64      `json:"id"`
+	StartTime time.Time  `json:"startTime"`
+	EndTime   *time.Time `json:"endTime"`
+	Name      string     `json:"activityName"` // the domain for browser activities, otherwise the app
+	App       string     `json:"app"`
+	Title     string     `json:"windowTitle"`
+	URL       string     `json:"url"`
+	DeviceID  string     `json:"deviceId"`
+	Hostname  string     `json:"hostname"`
+	OriginID  int64      `json:"originId,omitempty"`  // the activity's id on the device that recorded it, if synced from elsewhere
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"` // when the activity was last written
+	Source    string     `json:"source,omitempty"`    // "manual" for entries added by hand, empty if tracked
+	File      string     `json:"file,omitempty"`      // the file open in the editor, as reported by its plugin
+	Language  string     `json:"language,omitempty"`  // the language of the file open in the editor
+	Category  string     `json:"category,omitempty"`  // given when added by hand; otherwise categorized by name
+	Projects  []string   `json:"projects,omitempty"`  // stored in the projects tables, sorted by name
+	Tags      []string   `json:"tags,omitempty"`      // stored in the tags tables, sorted by name
+
+	incognito bool // from a private browsing window, as far as an import can tell; never stored
+}
+
+// The activity columns after id, in the order of ActivityRecord's fields
+var activityFields = []string{"start_time", "end_time", "activity_name", "app_name", "window_title", "url",
+	"device_id", "hostname", "origin_id", "updated_at", "source", "file_path", "language", "category"}
+
+var activityColumns = "id, " + strings.Join(activityFields, ", ")
+
+// Get the values of activityFields for storage
+func (r ActivityRecord) fieldValues() []any {
+	var endTime, updatedAt any
+	if r.EndTime != nil {
+		endTime = dbTime(*r.EndTime)
+	}
+	if r.UpdatedAt != nil {
+		updatedAt = dbTime(*r.UpdatedAt)
+	}
+	return []any{dbTime(r.StartTime), endTime, r.Name, r.App, r.Title, r.URL,
+		r.DeviceID, r.Hostname, r.OriginID, updatedAt, r.Source, r.File, r.Language, r.Category}
+}
+
+// Get the id that identifies the activity across devices, together with its device id
+func (r ActivityRecord) originID() int64 {
+	if r.OriginID != 0 {
+		return r.OriginID
+	}
+	return r.ID
+}
+
+// The monitor marks its ongoing activity as updated this often, so that ongoing
+// activities of monitors that stopped without ending them, e.g. on another device
+// that went offline, aren't counted forever
+const (
+	openActivityHeartbeat = time.Minute
+	openActivityTimeout   = 5 * time.Minute
+)
+
+// Get the end of the activity, using the given time for ongoing activities. Ongoing
+// activities that weren't updated within openActivityTimeout are taken to end then.
+func (r ActivityRecord) endOr(now time.Time) time.Time {
+	if r.EndTime != nil {
+		return *r.EndTime
+	}
+	if stale := r.lastSeen().Add(openActivityTimeout); stale.Before(now) {
+		return stale
+	}
+	return now
+}
+
+// Get when the activity was last known to be going on
+func (r ActivityRecord) lastSeen() time.Time {
+	if r.UpdatedAt != nil && r.UpdatedAt.After(r.StartTime) {
+		return *r.UpdatedAt
+	}
+	return r.StartTime
+}
+
+// Get the activities that overlap the given time range, ordered by start time
+func getActivities(db *DB, since, until time.Time) ([]ActivityRecord, error) {
+	records, err := getStoredActivities(db, since, until)
+	if err != nil {
+		return nil, err
+	}
+	return records, db.decryptActivities(records)
+}
+
+// Get the activities that overlap the given time range, with titles and URLs as
+// stored, which may be encrypted. For queries that don't need them, like summaries.
+func getStoredActivities(db *DB, since, until time.Time) ([]ActivityRecord, error) {
+	return db.queryStoredActivities(db, since, until)
+}
+
+// Get the activities that overlap the given time range as stored, e.g. in a transaction
+func (db *DB) queryStoredActivities(q querier, since, until time.Time) ([]ActivityRecord, error) {
+	rows, err := q.Query(db.rebind(`
+		SELECT `+activityColumns+`
+		FROM activities
+		WHERE start_time < ? AND (end_time > ? OR end_time IS NULL)
+		ORDER BY start_time, id
+	`), dbTime(until), dbTime(since))
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	records, err := scanActivities(rows)
+	if err != nil {
+		return nil, err
+	}
+	return records, db.loadLabels(q, records)
+}
+
+// Get the most recent activity that hasn't ended, if any, leaving out ones that are
+// no longer updated
+func getCurrentActivity(db *DB) (*ActivityRecord, error) {
+	staleBefore := dbTime(time.Now().Add(-openActivityTimeout))
+	rows, err := db.Query(db.rebind(`
+		SELECT `+activityColumns+`
+		FROM activities
+		WHERE end_time IS NULL AND (start_time >= ? OR updated_at >= ?)
+		ORDER BY start_time DESC, id DESC
+		LIMIT 1
+	`), staleBefore, staleBefore)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	records, err := scanActivities(rows)
+	if err == nil {
+		err = db.decryptActivities(records)
+	}
+	if err == nil {
+		err = db.loadLabels(db, records)
+	}
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return &records[0], nil
+}
+
+func scanActivities(rows *sql.Rows) ([]ActivityRecord, error) {
+	var records []ActivityRecord
+	for rows.Next() {
+		var record ActivityRecord
+		var startTime time.Time
+		var endTime, updatedAt sql.NullTime
+		if err := rows.Scan(&record.ID, &startTime, &endTime, &record.Name, &record.App, &record.Title, &record.URL,
+			&record.DeviceID, &record.Hostname, &record.OriginID, &updatedAt, &record.Source,
+			&record.File, &record.Language, &record.Category); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		record.StartTime = localTime(startTime)
+		if endTime.Valid {
+			t := localTime(endTime.Time)
+			record.EndTime = &t
+		}
+		if updatedAt.Valid {
+			t := localTime(updatedAt.Time)
+			record.UpdatedAt = &t
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rows: %v", err)
+	}
+	return records, nil
+}
+
+type importStats struct {
+	Inserted int // activities inserted as they were
+	Trimmed  int // activities inserted only where they don't overlap recorded activities
+	Skipped  int // activities that were already recorded
+	Open     int // activities that hadn't ended, which aren't imported
+}
+
+// Insert activities in a single transaction, keeping their ids where they're free.
+// Parts of activities that overlap already recorded activities of the same device
+// are dropped, so importing the same data twice, or data that overlaps what the
+// monitor recorded, doesn't count time twice. Overlaps with other devices are kept,
+// like those from sync, and only counted once by summaries. Activities that hadn't ended, e.g. the one being
+// tracked when the export was made, are left out: nothing would ever end them here.
+func importActivities(db *DB, records []ActivityRecord) (importStats, error) {
+	var stats importStats
+	now := time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return stats, fmt.Errorf("error starting import: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, record := range records {
+		if record.EndTime == nil {
+			stats.Open++
+			continue
+		}
+		if record.ID != 0 {
+			existing, err := db.queryActivityByID(tx, record.ID)
+			if err != nil {
+				return stats, err
+			}
+			if existing != nil {
+				if sameActivity(*existing, record) {
+					stats.Skipped++
+					continue
+				}
+				// the id is taken by a different activity
+				record.ID = 0
+			}
+		}
+
+		overlapping, err := db.queryStoredActivities(tx, record.StartTime, *record.EndTime)
+		if err != nil {
+			return stats, err
+		}
+		deviceID := record.DeviceID
+		if deviceID == "" {
+			device, err := getDevice()
+			if err != nil {
+				return stats, err
+			}
+			deviceID = device.ID
+		}
+		overlapping = slices.DeleteFunc(overlapping, func(other ActivityRecord) bool { return other.DeviceID != deviceID })
+		pieces := subtractActivities(record, overlapping, now)
+		if len(pieces) == 0 {
+			stats.Skipped++
+			continue
+		}
+		if len(pieces) > 1 || !sameActivity(pieces[0], record) {
+			stats.Trimmed++
+			for i := range pieces {
+				pieces[i].ID = 0
+			}
+		} else {
+			stats.Inserted++
+		}
+
+		for _, piece := range pieces {
+			if _, err := db.insertActivity(tx, piece); err != nil {
+				return stats, fmt.Errorf("error inserting activity: %v", err)
+			}
+		}
+	}
+
+	if err := db.resetIDSequence(tx, "activities"); err != nil {
+		return stats, fmt.Errorf("error resetting id sequence: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return stats, fmt.Errorf("error committing import: %v", err)
+	}
+	return stats, nil
+}
+
+func getActivityByID(db *DB, id int64) (*ActivityRecord, error) {
+	return db.queryActivityByID(db, id)
+}
+
+func (db *DB) queryActivityByID(q querier, id int64) (*ActivityRecord, error) {
+	rows, err := q.Query(db.rebind(`SELECT `+activityColumns+` FROM activities WHERE id = ?`), id)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	records, err := scanActivities(rows)
+	if err == nil {
+		err = db.decryptActivities(records)
+	}
+	if err == nil {
+		err = db.loadLabels(q, records)
+	}
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return &records[0], nil
+}
+
+// Get the parts of an activity that aren't covered by the given activities, which
+// must be ordered by start time. Ongoing activities are taken to end now.
+func subtractActivities(record ActivityRecord, others []ActivityRecord, now time.Time) []ActivityRecord {
+	var pieces []ActivityRecord
+	start, end := record.StartTime, record.endOr(now)
+	for _, other := range others {
+		otherStart, otherEnd := other.StartTime, other.endOr(now)
+		if !otherEnd.After(start) || !otherStart.Before(end) {
+			continue
+		}
+		if otherStart.After(start) {
+			piece := record
+			pieceEnd := otherStart
+			piece.StartTime, piece.EndTime = start, &pieceEnd
+			pieces = append(pieces, piece)
+		}
+		if otherEnd.After(start) {
+			start = otherEnd
+		}
+	}
+
+	if start.Equal(record.StartTime) {
+		return []ActivityRecord{record}
+	}
+	if start.Before(end) {
+		piece := record
+		piece.StartTime = start
+		pieces = append(pieces, piece)
+	}
+	return pieces
+}
+
+func sameActivity(a, b ActivityRecord) bool {
+	sameEnd := (a.EndTime == nil && b.EndTime == nil) ||
+		(a.EndTime != nil && b.EndTime != nil && a.EndTime.Equal(*b.EndTime))
+	return a.StartTime.Equal(b.StartTime) && sameEnd &&
+		a.Name == b.Name && a.App == b.App && a.Title == b.Title && a.URL == b.URL &&
+		a.DeviceID == b.DeviceID && a.Hostname == b.Hostname && a.Source == b.Source &&
+		a.File == b.File && a.Language == b.Language && a.Category == b.Category && sameLabels(a, b)
+}
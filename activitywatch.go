@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	awWindowBucketType = "currentwindow"
+	awWebBucketType    = "web.tab.current"
+	awAFKBucketType    = "afkstatus"
+)
+
+// The format of ActivityWatch's bucket export (GET /api/0/export)
+type awExport struct {
+	Buckets map[string]*awBucket `json:"buckets"`
+}
+
+type awBucket struct {
+	ID       string    `json:"id"`
+	Created  time.Time `json:"created"`
+	Type     string    `json:"type"`
+	Client   string    `json:"client"`
+	Hostname string    `json:"hostname"`
+	Events   []awEvent `json:"events"`
+}
+
+type awEvent struct {
+	Timestamp time.Time      `json:"timestamp"`
+	Duration  float64        `json:"duration"` // seconds
+	Data      map[string]any `json:"data"`
+	hostname  string
+}
+
+func (e awEvent) end() time.Time {
+	return e.Timestamp.Add(time.Duration(e.Duration * float64(time.Second)))
+}
+
+func (e awEvent) str(key string) string {
+	value, _ := e.Data[key].(string)
+	return value
+}
+
+func (e awEvent) flag(key string) bool {
+	value, _ := e.Data[key].(bool)
+	return value
+}
+
+// Write activities as an aw-watcher-window bucket per host, plus an aw-watcher-web
+// bucket per browser for activities with a URL
+func writeActivityWatch(w io.Writer, records []ActivityRecord, now time.Time) error {
+	localHostname, err := os.Hostname()
+	if err != nil {
+		localHostname = "unknown"
+	}
+
+	export := awExport{Buckets: make(map[string]*awBucket)}
+	bucket := func(id, bucketType, client, hostname string) *awBucket {
+		if _, ok := export.Buckets[id]; !ok {
+			export.Buckets[id] = &awBucket{
+				ID:       id,
+				Created:  now.UTC(),
+				Type:     bucketType,
+				Client:   client,
+				Hostname: hostname,
+				Events:   []awEvent{},
+			}
+		}
+		return export.Buckets[id]
+	}
+
+	for _, record := range records {
+		hostname := record.Hostname
+		if hostname == "" {
+			hostname = localHostname
+		}
+		app := record.App
+		if app == "" {
+			app = record.Name
+		}
+
+		windowBucket := bucket("aw-watcher-window_"+hostname, awWindowBucketType, "aw-watcher-window", hostname)
+		event := awEvent{
+			Timestamp: record.StartTime.UTC(),
+			Duration:  record.endOr(now).Sub(record.StartTime).Seconds(),
+			Data:      map[string]any{"app": app, "title": record.Title},
+		}
+		windowBucket.Events = append(windowBucket.Events, event)
+
+		if record.URL == "" {
+			continue
+		}
+		webID := "aw-watcher-web-" + strings.ToLower(strings.ReplaceAll(app, " ", "-")) + "_" + hostname
+		webBucket := bucket(webID, awWebBucketType, "aw-client-web", hostname)
+		webBucket.Events = append(webBucket.Events, awEvent{
+			Timestamp: event.Timestamp,
+			Duration:  event.Duration,
+			Data:      map[string]any{"url": record.URL, "title": record.Title, "audible": false, "incognito": false},
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
+
+// Read an ActivityWatch export into activities, recorded as coming from a device
+// per hostname. Window events for browsers are split by the web events from the same
+// host that overlap them, so browsing is recorded by domain like the monitor does;
+// web events are used as-is for hosts without window events. Time the host's AFK
+// watcher recorded as away is left out.
+func readActivityWatch(r io.Reader) ([]ActivityRecord, error) {
+	var export awExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("error parsing ActivityWatch export: %v", err)
+	}
+
+	windowEvents := make(map[string][]awEvent)
+	webEvents := make(map[string][]awEvent)
+	afkEvents := make(map[string][]awEvent)
+	for _, id := range sortedKeys(export.Buckets) {
+		bucket := export.Buckets[id]
+		hostname := bucket.Hostname
+		if hostname == "" {
+			hostname = "unknown"
+		}
+		for i := range bucket.Events {
+			bucket.Events[i].hostname = hostname
+		}
+
+		switch bucket.Type {
+		case awWindowBucketType:
+			windowEvents[hostname] = append(windowEvents[hostname], bucket.Events...)
+		case awWebBucketType:
+			webEvents[hostname] = append(webEvents[hostname], bucket.Events...)
+		case awAFKBucketType:
+			for _, event := range bucket.Events {
+				if event.str("status") == "afk" {
+					afkEvents[hostname] = append(afkEvents[hostname], event)
+				}
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "Skipping bucket %s of unsupported type %s\n", id, bucket.Type)
+		}
+	}
+
+	hostnames := sortedKeys(windowEvents)
+	for _, hostname := range sortedKeys(webEvents) {
+		if _, ok := windowEvents[hostname]; !ok {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+
+	var records []ActivityRecord
+	for _, hostname := range hostnames {
+		records = append(records, subtractAFK(mergeAWEvents(windowEvents[hostname], webEvents[hostname]), afkEvents[hostname])...)
+	}
+	return records, nil
+}
+
+// Merge the window and web events of a single host into activities
+func mergeAWEvents(windowEvents, webEvents []awEvent) []ActivityRecord {
+	sortEvents := func(events []awEvent) {
+		sort.Slice(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+	}
+	sortEvents(windowEvents)
+	sortEvents(webEvents)
+
+	var records []ActivityRecord
+	if len(windowEvents) == 0 {
+		for _, event := range webEvents {
+			records = appendAWRecord(records, event, event.Timestamp, event.end(), "", event.str("title"), event.str("url"), event.flag("incognito"))
+		}
+		return records
+	}
+
+	nextWeb := 0
+	for _, event := range windowEvents {
+		app, title := event.str("app"), event.str("title")
+		start, end := event.Timestamp, event.end()
+		if !isBrowser(app) {
+			records = appendAWRecord(records, event, start, end, app, title, "", false)
+			continue
+		}
+
+		// fill the window event with the web events that overlap it
+		for nextWeb < len(webEvents) && !webEvents[nextWeb].end().After(start) {
+			nextWeb++
+		}
+		for _, web := range webEvents[nextWeb:] {
+			webStart, webEnd := web.Timestamp, web.end()
+			if !webStart.Before(end) {
+				break
+			}
+			if !webEnd.After(start) {
+				continue
+			}
+			if webStart.After(start) {
+				records = appendAWRecord(records, event, start, webStart, app, title, "", false)
+				start = webStart
+			}
+			if webEnd.After(end) {
+				webEnd = end
+			}
+			records = appendAWRecord(records, event, start, webEnd, app, web.str("title"), web.str("url"), web.flag("incognito"))
+			start = webEnd
+		}
+		records = appendAWRecord(records, event, start, end, app, title, "", false)
+	}
+
+	return records
+}
+
+// Remove the time a host was away from its activities, which must be ordered by start time
+func subtractAFK(records []ActivityRecord, afkEvents []awEvent) []ActivityRecord {
+	if len(afkEvents) == 0 {
+		return records
+	}
+	sort.Slice(afkEvents, func(i, j int) bool { return afkEvents[i].Timestamp.Before(afkEvents[j].Timestamp) })
+	away := make([]ActivityRecord, len(afkEvents))
+	for i, event := range afkEvents {
+		// activities are stored with second precision
+		end := event.end().Local().Truncate(time.Second)
+		away[i] = ActivityRecord{StartTime: event.Timestamp.Local().Truncate(time.Second), EndTime: &end}
+	}
+
+	var present []ActivityRecord
+	next := 0
+	for _, record := range records {
+		// AFK time that ends before this activity also ends before the later ones
+		for next < len(away) && !away[next].EndTime.After(record.StartTime) {
+			next++
+		}
+		last := next
+		for last < len(away) && away[last].StartTime.Before(*record.EndTime) {
+			last++
+		}
+		present = append(present, subtractActivities(record, away[next:last], *record.EndTime)...)
+	}
+	return present
+}
+
+func appendAWRecord(records []ActivityRecord, event awEvent, start, end time.Time, app, title, url string, incognito bool) []ActivityRecord {
+	// activities are stored with second precision
+	start, end = start.Local().Truncate(time.Second), end.Local().Truncate(time.Second)
+	if !end.After(start) {
+		return records
+	}
+
+	name := app
+	if domain := getDomain(url); domain != "" {
+		name = domain
+	}
+	if name == "" {
+		return records
+	}
+	return append(records, ActivityRecord{
+		StartTime: start,
+		EndTime:   &end,
+		Name:      name,
+		App:       app,
+		Title:     title,
+		URL:       url,
+		DeviceID:  "activitywatch:" + event.hostname,
+		Hostname:  event.hostname,
+		incognito: incognito,
+	})
+}
+
+func isBrowser(app string) bool {
+	if _, ok := browserUrlScripts[app]; ok {
+		return true
+	}
+	app = strings.ToLower(app)
+	for _, browser := range []string{"chrome", "chromium", "firefox", "safari", "edge", "brave", "opera", "vivaldi"} {
+		if strings.Contains(app, browser) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Private browsing events keep neither title nor URL when imported
+func TestActivityWatchIncognito(t *testing.T) {
+	records, err := readActivityWatch(strings.NewReader(`{"buckets": {
+		"aw-watcher-web-firefox": {"type": "web.tab.current", "hostname": "laptop", "events": [
+			{"timestamp": "2024-11-05T09:00:00Z", "duration": 60, "data": {"url": "https://github.com/pulls", "title": "Pulls", "incognito": false}},
+			{"timestamp": "2024-11-05T09:01:00Z", "duration": 60, "data": {"url": "https://example.com/secret", "title": "Secret", "incognito": true}}
+		]}
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := newTestPrivacyFilter(t, PrivacyConfig{StoreTitles: true})
+	var got []string
+	for _, record := range records {
+		record = filter.applyToRecord(record)
+		got = append(got, record.Name+"|"+record.Title+"|"+record.URL)
+	}
+	if want := "github.com|Pulls|https://github.com/pulls, Web browser||"; strings.Join(got, ", ") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, ", "), want)
+	}
+}
+
+func awTestEvent(at string, seconds float64, data map[string]any) awEvent {
+	timestamp, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		panic(err)
+	}
+	return awEvent{Timestamp: timestamp, Duration: seconds, Data: data, hostname: "laptop"}
+}
+
+// Format activities as start-end name|title|url in UTC, for comparing
+func formatAWRecords(records []ActivityRecord) string {
+	var lines []string
+	for _, record := range records {
+		lines = append(lines, fmt.Sprintf("%s-%s %s|%s|%s", record.StartTime.UTC().Format("15:04:05"),
+			record.EndTime.UTC().Format("15:04:05"), record.Name, record.Title, record.URL))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestMergeAWEvents(t *testing.T) {
+	window := func(at string, seconds float64, app, title string) awEvent {
+		return awTestEvent(at, seconds, map[string]any{"app": app, "title": title})
+	}
+	web := func(at string, seconds float64, url, title string) awEvent {
+		return awTestEvent(at, seconds, map[string]any{"url": url, "title": title})
+	}
+	for _, tt := range []struct {
+		name        string
+		window, web []awEvent
+		want        string
+	}{
+		{
+			"browser window split by the web events in it",
+			[]awEvent{window("2024-11-05T09:00:00Z", 600, "Firefox", "Mozilla Firefox")},
+			[]awEvent{
+				web("2024-11-05T09:02:00Z", 120, "https://github.com/pulls", "Pulls"),
+				web("2024-11-05T09:05:00Z", 600, "https://example.com/", "Example"),
+			},
+			"09:00:00-09:02:00 Firefox|Mozilla Firefox|\n" +
+				"09:02:00-09:04:00 github.com|Pulls|https://github.com/pulls\n" +
+				"09:04:00-09:05:00 Firefox|Mozilla Firefox|\n" +
+				"09:05:00-09:10:00 example.com|Example|https://example.com/",
+		},
+		{
+			"web events only fill browser windows",
+			[]awEvent{
+				window("2024-11-05T09:00:00Z", 60, "Code", "main.go"),
+				window("2024-11-05T09:01:00Z", 60, "Google Chrome", "Chrome"),
+			},
+			[]awEvent{web("2024-11-05T08:59:00Z", 180, "https://github.com/", "GitHub")},
+			"09:00:00-09:01:00 Code|main.go|\n" +
+				"09:01:00-09:02:00 github.com|GitHub|https://github.com/",
+		},
+		{
+			"unsorted events",
+			[]awEvent{
+				window("2024-11-05T09:01:00Z", 60, "Safari", "Safari"),
+				window("2024-11-05T09:00:00Z", 60, "Safari", "Safari"),
+			},
+			[]awEvent{
+				web("2024-11-05T09:01:30Z", 30, "https://b.example/", "B"),
+				web("2024-11-05T09:00:00Z", 30, "https://a.example/", "A"),
+			},
+			"09:00:00-09:00:30 a.example|A|https://a.example/\n" +
+				"09:00:30-09:01:00 Safari|Safari|\n" +
+				"09:01:00-09:01:30 Safari|Safari|\n" +
+				"09:01:30-09:02:00 b.example|B|https://b.example/",
+		},
+		{
+			"web events without window events",
+			nil,
+			[]awEvent{web("2024-11-05T09:00:00Z", 60, "https://github.com/", "GitHub")},
+			"09:00:00-09:01:00 github.com|GitHub|https://github.com/",
+		},
+		{
+			"sub-second events are dropped",
+			[]awEvent{window("2024-11-05T09:00:00Z", 0.5, "Code", "main.go")},
+			nil,
+			"",
+		},
+	} {
+		if got := formatAWRecords(mergeAWEvents(tt.window, tt.web)); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSubtractAFK(t *testing.T) {
+	at := func(minutes int) time.Time {
+		return time.Date(2024, 11, 5, 9, minutes, 0, 0, time.UTC).Local()
+	}
+	record := func(start, end int, name string) ActivityRecord {
+		endTime := at(end)
+		return ActivityRecord{StartTime: at(start), EndTime: &endTime, Name: name}
+	}
+	afk := func(start, minutes int) awEvent {
+		return awTestEvent(at(start).UTC().Format(time.RFC3339), float64(minutes*60), map[string]any{"status": "afk"})
+	}
+	for _, tt := range []struct {
+		name    string
+		records []ActivityRecord
+		afk     []awEvent
+		want    string
+	}{
+		{"no afk time", []ActivityRecord{record(0, 10, "Code")}, nil, "09:00:00-09:10:00 Code||"},
+		{
+			"afk in the middle of an activity",
+			[]ActivityRecord{record(0, 10, "Code")},
+			[]awEvent{afk(3, 2)},
+			"09:00:00-09:03:00 Code||\n09:05:00-09:10:00 Code||",
+		},
+		{
+			"afk across activities, unsorted",
+			[]ActivityRecord{record(0, 10, "Code"), record(10, 20, "Slack"), record(20, 30, "Mail")},
+			[]awEvent{afk(25, 10), afk(5, 10)},
+			"09:00:00-09:05:00 Code||\n09:15:00-09:20:00 Slack||\n09:20:00-09:25:00 Mail||",
+		},
+		{
+			"activity entirely afk",
+			[]ActivityRecord{record(0, 10, "Code"), record(10, 20, "Slack")},
+			[]awEvent{afk(0, 10)},
+			"09:10:00-09:20:00 Slack||",
+		},
+	} {
+		if got := formatAWRecords(subtractAFK(tt.records, tt.afk)); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Activities are written as window events per host, and those with a URL also as
+// web events per browser
+func TestWriteActivityWatch(t *testing.T) {
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.UTC).Local()
+	end := start.Add(time.Minute)
+	now := start.Add(time.Hour)
+	var buf strings.Builder
+	err := writeActivityWatch(&buf, []ActivityRecord{
+		{StartTime: start, EndTime: &end, Name: "Code", App: "Code", Title: "main.go", Hostname: "laptop"},
+		{StartTime: end, EndTime: &now, Name: "github.com", App: "Google Chrome", Title: "Pulls",
+			URL: "https://github.com/pulls", Hostname: "laptop"},
+		{StartTime: start, UpdatedAt: &now, Name: "Design review", Hostname: "desktop"},
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var export awExport
+	if err := json.Unmarshal([]byte(buf.String()), &export); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, id := range sortedKeys(export.Buckets) {
+		bucket := export.Buckets[id]
+		for _, event := range bucket.Events {
+			got = append(got, fmt.Sprintf("%s %s %s %s %.0f %v", id, bucket.Type, bucket.Hostname,
+				event.Timestamp.Format(time.RFC3339), event.Duration, event.Data))
+		}
+	}
+	want := []string{
+		"aw-watcher-web-google-chrome_laptop web.tab.current laptop 2024-11-05T09:01:00Z 3540 map[audible:false incognito:false title:Pulls url:https://github.com/pulls]",
+		"aw-watcher-window_desktop currentwindow desktop 2024-11-05T09:00:00Z 3600 map[app:Design review title:]",
+		"aw-watcher-window_laptop currentwindow laptop 2024-11-05T09:00:00Z 60 map[app:Code title:main.go]",
+		"aw-watcher-window_laptop currentwindow laptop 2024-11-05T09:01:00Z 3540 map[app:Google Chrome title:Pulls]",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// Hosts with only web events are read in order
+func TestReadActivityWatchIsDeterministic(t *testing.T) {
+	var buckets []string
+	for _, host := range []string{"e", "d", "c", "b", "a"} {
+		buckets = append(buckets, fmt.Sprintf(`"aw-watcher-web-firefox_%s": {"type": "web.tab.current", "hostname": "%s", "events": [
+			{"timestamp": "2024-11-05T09:00:00Z", "duration": 60, "data": {"url": "https://%s.example/", "title": "%s"}}
+		]}`, host, host, host, host))
+	}
+	export := `{"buckets": {` + strings.Join(buckets, ",") + `}}`
+	for i := 0; i < 10; i++ {
+		records, err := readActivityWatch(strings.NewReader(export))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hosts []string
+		for _, record := range records {
+			hosts = append(hosts, record.Hostname)
+		}
+		if got := strings.Join(hosts, ","); got != "a,b,c,d,e" {
+			t.Fatalf("got hosts in order %s", got)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+//go:embed web/*
+var webFiles embed.FS
+
+type apiServer struct {
+	db  *DB
+	cfg *Config
+}
+
+type apiSummary struct {
+	Since         time.Time  `json:"since"`
+	Until         time.Time  `json:"until"`
+	GroupBy       string     `json:"groupBy"`
+	TotalSeconds  float64    `json:"totalSeconds"`
+	PeriodSeconds float64    `json:"periodSeconds"`
+	Items         []apiTotal `json:"items"`
+}
+
+type apiTotal struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+func serveCmd(c *cli.Context) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	db, err := getDb()
+	if err != nil {
+		return fmt.Errorf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	server := &apiServer{db: db, cfg: cfg}
+	addr := c.String("addr")
+	fmt.Printf("Serving activitymon dashboard and API on http://%s\n", addr)
+	return http.ListenAndServe(addr, server.routes())
+}
+
+func (s *apiServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/activities", s.handleActivities)
+	mux.HandleFunc("GET /api/summary", s.handleSummary)
+	mux.HandleFunc("GET /api/current", s.handleCurrent)
+
+	// the dashboard is a single page built on the API
+	web, _ := fs.Sub(webFiles, "web")
+	mux.Handle("GET /", http.FileServer(http.FS(web)))
+	return mux
+}
+
+func (s *apiServer) handleActivities(w http.ResponseWriter, r *http.Request) {
+	since, until, err := parseTimeRange(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	records, err := getActivities(s.db, since, until)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if records == nil {
+		records = []ActivityRecord{}
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+func (s *apiServer) handleSummary(w http.ResponseWriter, r *http.Request) {
+	since, until, err := parseTimeRange(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = "activity"
+	}
+	data, err := getGroupedSummaryData(s.db, SummaryOptions{
+		Start:      since,
+		End:        until,
+		GroupBy:    groupBy,
+		Categories: s.cfg.Categories,
+		DeviceID:   r.URL.Query().Get("device"),
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	summary := apiSummary{
+		Since:         since,
+		Until:         until,
+		GroupBy:       groupBy,
+		TotalSeconds:  data.TotalDuration.Seconds(),
+		PeriodSeconds: data.TimePeriod.Seconds(),
+		Items:         []apiTotal{},
+	}
+	for _, activity := range data.Activities {
+		summary.Items = append(summary.Items, apiTotal{Name: activity.Name, Seconds: activity.Duration.Seconds()})
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+func (s *apiServer) handleCurrent(w http.ResponseWriter, r *http.Request) {
+	current, err := getCurrentActivity(s.db)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// null when nothing is being tracked
+	writeJSON(w, http.StatusOK, current)
+}
+
+// Parse the since and until query parameters, defaulting to the last 24 hours
+func parseTimeRange(r *http.Request) (time.Time, time.Time, error) {
+	now := time.Now()
+	since, until := now.Add(-24*time.Hour), now
+
+	var err error
+	if value := r.URL.Query().Get("since"); value != "" {
+		if since, err = parseTimeParam(value, now); err != nil {
+			return since, until, fmt.Errorf("invalid since: %v", err)
+		}
+	}
+	if value := r.URL.Query().Get("until"); value != "" {
+		if until, err = parseTimeParam(value, now); err != nil {
+			return since, until, fmt.Errorf("invalid until: %v", err)
+		}
+	}
+	if !since.Before(until) {
+		return since, until, fmt.Errorf("since must be before until")
+	}
+	return since, until, nil
+}
+
+// Parse a time given as RFC 3339, a local date and time, a local date, or a
+// duration before now (e.g. "4h")
+func parseTimeParam(value string, now time.Time) (time.Time, error) {
+	// the database holds local time, so times with another offset are converted
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Local(), nil
+	}
+	for _, layout := range []string{dbTimeFormat, "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	// a time of day is today
+	if t, err := time.ParseInLocation("15:04", value, time.Local); err == nil {
+		now = now.Local()
+		return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, time.Local), nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", value)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Get a summary from the API, as the dashboard does
+func getAPISummary(t *testing.T, server *apiServer, params url.Values) apiSummary {
+	t.Helper()
+	response := httptest.NewRecorder()
+	server.routes().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/summary?"+params.Encode(), nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", response.Code, response.Body.String())
+	}
+	var summary apiSummary
+	if err := json.Unmarshal(response.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	return summary
+}
+
+// The dashboard's top windows are the time per window title
+func TestAPISummaryByTitle(t *testing.T) {
+	db := openTestDb(t)
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	for i, record := range []ActivityRecord{
+		{Name: "Code", App: "Code", Title: "main.go — activitymon"},
+		{Name: "Code", App: "Code", Title: "api.go — activitymon"},
+		{Name: "github.com", App: "Firefox", Title: "Pulls"},
+		{Name: "Code", App: "Code", Title: "main.go — activitymon"},
+		{Name: "Slack", App: "Slack"},
+	} {
+		record.StartTime = start.Add(time.Duration(i) * 10 * time.Minute)
+		end := record.StartTime.Add(10 * time.Minute)
+		record.EndTime = &end
+		if _, err := db.insertActivity(db, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := &apiServer{db: db, cfg: &Config{}}
+
+	summary := getAPISummary(t, server, url.Values{"since": {"2024-11-05"}, "until": {"2024-11-06"}, "group_by": {"title"}})
+	var got []string
+	for _, item := range summary.Items {
+		got = append(got, item.Name+" "+(time.Duration(item.Seconds)*time.Second).String())
+	}
+	want := "main.go — activitymon 20m0s, (no title) 10m0s, Pulls 10m0s, api.go — activitymon 10m0s"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, ", "), want)
+	}
+	if summary.GroupBy != "title" || summary.TotalSeconds != 3000 {
+		t.Errorf("got %+v", summary)
+	}
+}
+
+// Only the given device's time is counted when the summary is for one device
+func TestAPISummaryByDevice(t *testing.T) {
+	db := openTestDb(t)
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	for i, record := range []ActivityRecord{
+		{Name: "Code", DeviceID: "laptop-id", Hostname: "laptop"},
+		{Name: "Slack", DeviceID: "desktop-id", Hostname: "desktop"},
+		{Name: "Code", DeviceID: "desktop-id", Hostname: "desktop"},
+	} {
+		record.StartTime = start.Add(time.Duration(i) * 20 * time.Minute)
+		end := record.StartTime.Add(20 * time.Minute)
+		record.EndTime = &end
+		if _, err := db.insertActivity(db, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := &apiServer{db: db, cfg: &Config{}}
+
+	for _, tt := range []struct {
+		device string
+		want   string
+	}{
+		{"", "Code 40m0s, Slack 20m0s"},
+		{"desktop-id", "Code 20m0s, Slack 20m0s"},
+		{"laptop-id", "Code 20m0s"},
+		{"unknown", ""},
+	} {
+		summary := getAPISummary(t, server, url.Values{"since": {"2024-11-05"}, "until": {"2024-11-06"}, "device": {tt.device}})
+		var got []string
+		for _, item := range summary.Items {
+			got = append(got, item.Name+" "+(time.Duration(item.Seconds)*time.Second).String())
+		}
+		if strings.Join(got, ", ") != tt.want {
+			t.Errorf("device %q: got %q, want %q", tt.device, strings.Join(got, ", "), tt.want)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/url"
 	"os/exec"
+	"strconv"
 	"strings"
 )
 
@@ -20,12 +21,13 @@ var browserUrlScripts = map[string]string{
 	"Safari":        "tell application \"Safari\" to set currentURL to URL of current tab of window 1",
 }
 
-// Get the name of the frontmost application and the title of the frontmost window
-func getAppAndWindow() (string, string, error) {
+// Get the name and process id of the frontmost application, and the title of the
+// frontmost window
+func getAppAndWindow() (string, string, int, error) {
 	// Check if display is asleep, screen saver is running, or the screen is locked
 	sleepStr, err := runAppleScriptTemplate("sleep")
 	if err != nil {
-		return "", "", err
+		return "", "", 0, err
 	}
 	var systemStatus struct {
 		IsScreenSaverRunning bool `json:"isScreenSaverRunning"`
@@ -33,35 +35,35 @@ func getAppAndWindow() (string, string, error) {
 		IsAsleep             bool `json:"isAsleep"`
 	}
 	if err := json.Unmarshal([]byte(sleepStr), &systemStatus); err != nil {
-		return "", "", err
+		return "", "", 0, err
 	}
 	if systemStatus.IsScreenSaverRunning || systemStatus.IsLocked || systemStatus.IsAsleep {
-		return "", "", nil
+		return "", "", 0, nil
 	}
 
-	// Get currently active application name
-	appName, err := runAppleScript(`tell application "System Events" to get name of first process whose frontmost is true`)
+	// Get currently active application name and process id, which comes last since
+	// the name may contain commas
+	frontmost, err := runAppleScript(`tell application "System Events" to get {name, unix id} of first process whose frontmost is true`)
 	if err != nil {
-		return "", "", err
+		return "", "", 0, err
 	}
-
-	// Get title of the frontmost window
-	windowTitle, err := runAppleScriptTemplate("window", appName)
+	separator := strings.LastIndex(frontmost, ", ")
+	if separator < 0 {
+		return "", "", 0, fmt.Errorf("unexpected frontmost process %q", frontmost)
+	}
+	appName := frontmost[:separator]
+	pid, err := strconv.Atoi(frontmost[separator+2:])
 	if err != nil {
-		return "", "", err
+		return "", "", 0, fmt.Errorf("unexpected process id %q", frontmost[separator+2:])
 	}
 
-	return appName, windowTitle, nil
-}
-
-// Get the domain of the active tab in the browser
-func getBrowserDomain(browser string) (string, error) {
-	url, err := getBrowserUrl(browser)
+	// Get title of the frontmost window
+	windowTitle, err := runAppleScriptTemplate("window", appName)
 	if err != nil {
-		return "", err
+		return "", "", 0, err
 	}
 
-	return getDomain(url), nil
+	return appName, windowTitle, pid, nil
 }
 
 // Get the URL of the active tab in the browser
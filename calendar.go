@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// How often the monitor checks the configured calendar files for changes
+const calendarWatchInterval = time.Minute
+
+// How often the monitor re-imports calendar files that haven't changed, so recurring
+// events keep being expanded a year ahead
+const calendarRefreshInterval = 24 * time.Hour
+
+// Summary group for time outside calendar events
+const noMeeting = "(no meeting)"
+
+// Apps and sites used for calls, to compare against the time scheduled for meetings
+var callApps = []string{
+	"zoom.us", "Zoom", "Microsoft Teams", "Webex", "Cisco Webex Meetings", "FaceTime", "Around", "Tuple",
+	"meet.google.com", "teams.microsoft.com", "teams.live.com", "webex.com", "whereby.com", "meet.jit.si",
+}
+
+// An occurrence of a calendar event
+type calendarEvent struct {
+	ID        int64     `json:"id"`
+	Source    string    `json:"source"` // the file it was imported from
+	UID       string    `json:"uid"`
+	Summary   string    `json:"summary"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+}
+
+func (db *DB) setupCalendar() error {
+	idColumn := "INTEGER PRIMARY KEY AUTOINCREMENT"
+	if db.dbType == "postgres" {
+		idColumn = "SERIAL PRIMARY KEY"
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS calendar_events (
+			id ` + idColumn + `,
+			source TEXT NOT NULL,
+			uid TEXT NOT NULL,
+			summary TEXT NOT NULL,
+			start_time TIMESTAMP NOT NULL,
+			end_time TIMESTAMP NOT NULL
+		)
+	`); err != nil {
+		return err
+	}
+	_, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_calendar_events_start_time ON calendar_events(start_time)`)
+	return err
+}
+
+// Expand events into their occurrences up to the horizon, keeping the occurrences of
+// recurring events from from on. All-day and cancelled events are left out, since
+// they don't take up time.
+func expandEvents(events []icsEvent, from, horizon time.Time) ([]calendarEvent, []string) {
+	type occurrenceKey struct {
+		uid   string
+		start int64
+	}
+	changed := make(map[occurrenceKey]icsEvent)
+	recurring := make(map[string]bool)
+	for _, event := range events {
+		if !event.RecurrenceID.IsZero() {
+			changed[occurrenceKey{event.UID, event.RecurrenceID.Unix()}] = event
+		} else if event.RRule != "" {
+			recurring[event.UID] = true
+		}
+	}
+
+	var occurrences []calendarEvent
+	var warnings []string
+	add := func(event icsEvent, start, end time.Time) {
+		if event.AllDay || event.Status == "CANCELLED" || !end.After(start) {
+			return
+		}
+		occurrences = append(occurrences, calendarEvent{UID: event.UID, Summary: event.Summary, StartTime: start, EndTime: end})
+	}
+
+	for _, event := range events {
+		if !event.RecurrenceID.IsZero() {
+			// changed occurrences of recurring events are added in their place
+			if !recurring[event.UID] {
+				add(event, event.Start, event.End)
+			}
+			continue
+		}
+		starts := []time.Time{event.Start}
+		if event.RRule != "" {
+			rule, err := parseRecurrenceRule(event.RRule, event.Start.Location())
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("skipped event %q: %v", event.Summary, err))
+				continue
+			}
+			var truncated bool
+			if starts, truncated = rule.occurrences(event.Start, from, horizon); truncated {
+				warnings = append(warnings, fmt.Sprintf("event %q has more than %d occurrences from %s on; only the first were imported",
+					event.Summary, maxEventOccurrences, from.Format("2006-01-02")))
+			}
+		}
+		duration := event.End.Sub(event.Start)
+		for _, start := range starts {
+			if slices.ContainsFunc(event.ExDates, start.Equal) {
+				continue
+			}
+			if change, ok := changed[occurrenceKey{event.UID, start.Unix()}]; ok {
+				add(change, change.Start, change.End)
+				continue
+			}
+			add(event, start, start.Add(duration))
+		}
+	}
+	slices.SortFunc(occurrences, func(a, b calendarEvent) int { return a.StartTime.Compare(b.StartTime) })
+	return occurrences, warnings
+}
+
+// Read a calendar file and replace the events imported from it before
+func importCalendar(db *DB, path string, now time.Time) (int, []string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil, fmt.Errorf("unable to open calendar: %v", err)
+	}
+	defer f.Close()
+
+	events, warnings, err := parseICS(f)
+	if err != nil {
+		return 0, warnings, err
+	}
+	occurrences, expandWarnings := expandEvents(events, now.Add(-calendarHistory), now.Add(calendarHorizon))
+	warnings = append(warnings, expandWarnings...)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, warnings, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(db.rebind(`DELETE FROM calendar_events WHERE source = ?`), path); err != nil {
+		return 0, warnings, fmt.Errorf("error deleting old events: %v", err)
+	}
+	for _, event := range occurrences {
+		if _, err := tx.Exec(db.rebind(`
+			INSERT INTO calendar_events (source, uid, summary, start_time, end_time)
+			VALUES (?, ?, ?, ?, ?)
+		`), path, event.UID, event.Summary, dbTime(event.StartTime), dbTime(event.EndTime)); err != nil {
+			return 0, warnings, fmt.Errorf("error inserting event: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, warnings, fmt.Errorf("error committing events: %v", err)
+	}
+	return len(occurrences), warnings, nil
+}
+
+// Get the event occurrences that overlap the given time range, ordered by start time
+func getCalendarEvents(db *DB, since, until time.Time) ([]calendarEvent, error) {
+	rows, err := db.Query(db.rebind(`
+		SELECT id, source, uid, summary, start_time, end_time
+		FROM calendar_events
+		WHERE start_time < ? AND end_time > ?
+		ORDER BY start_time, id
+	`), dbTime(until), dbTime(since))
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	var events []calendarEvent
+	for rows.Next() {
+		var event calendarEvent
+		if err := rows.Scan(&event.ID, &event.Source, &event.UID, &event.Summary, &event.StartTime, &event.EndTime); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		event.StartTime, event.EndTime = localTime(event.StartTime), localTime(event.EndTime)
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rows: %v", err)
+	}
+	return events, nil
+}
+
+// Get the meeting at t, the one that started last if several overlap, and the next
+// time that changes. Events must be ordered by start time.
+func meetingAt(events []calendarEvent, t time.Time) (string, time.Time) {
+	meeting := noMeeting
+	var next time.Time
+	for _, event := range events {
+		if event.StartTime.After(t) {
+			if next.IsZero() || event.StartTime.Before(next) {
+				next = event.StartTime
+			}
+			break
+		}
+		if event.EndTime.After(t) {
+			meeting = event.Summary
+			next = event.EndTime
+		}
+	}
+	return meeting, next
+}
+
+// Scheduled time of a meeting, and the time on calls during it
+type meetingTime struct {
+	Event     calendarEvent
+	Scheduled time.Duration
+	OnCall    time.Duration
+}
+
+// Compare the meetings in the range against the time spent in call apps during them
+func meetingTimes(events []calendarEvent, records []ActivityRecord, apps []string, since, until, now time.Time) []meetingTime {
+	isCall := func(record ActivityRecord) bool {
+		return slices.ContainsFunc(apps, func(app string) bool {
+			return activityMatches(app, record.Name) || strings.EqualFold(app, record.App)
+		})
+	}
+	records = mergeOverlappingActivities(records, now)
+
+	var meetings []meetingTime
+	for _, event := range events {
+		start, end := event.StartTime, event.EndTime
+		if start.Before(since) {
+			start = since
+		}
+		if end.After(until) {
+			end = until
+		}
+		if !end.After(start) {
+			continue
+		}
+		meeting := meetingTime{Event: event, Scheduled: end.Sub(start)}
+		for _, record := range records {
+			callStart, callEnd := record.StartTime, record.endOr(now)
+			if !isCall(record) || !callEnd.After(start) || !callStart.Before(end) {
+				continue
+			}
+			if callStart.Before(start) {
+				callStart = start
+			}
+			if callEnd.After(end) {
+				callEnd = end
+			}
+			meeting.OnCall += callEnd.Sub(callStart)
+		}
+		meetings = append(meetings, meeting)
+	}
+	return meetings
+}
+
+func formatMeetings(meetings []meetingTime) string {
+	if len(meetings) == 0 {
+		return ""
+	}
+	var buf strings.Builder
+	var scheduled, onCall time.Duration
+	buf.WriteString("\n[cyan]📅 Meetings (scheduled / on a call):[white]\n")
+	for _, meeting := range meetings {
+		color := "green"
+		if meeting.OnCall < meeting.Scheduled/2 {
+			color = "yellow"
+		}
+		buf.WriteString(fmt.Sprintf("[lightblue]%s %-24s[white] %s / [%s]%s[white]\n",
+			meeting.Event.StartTime.Format("01/02 15:04"), truncateString(meeting.Event.Summary, 24),
+			formatTime(meeting.Scheduled), color, formatTime(meeting.OnCall)))
+		scheduled += meeting.Scheduled
+		onCall += meeting.OnCall
+	}
+	buf.WriteString(fmt.Sprintf("[yellow]%-36s[white] %s / %s\n", "Total", formatTime(scheduled), formatTime(onCall)))
+	return buf.String()
+}
+
+// Re-imports the configured calendar files when they change, and once a day
+type calendarWatcher struct {
+	files      []string
+	modTimes   map[string]time.Time
+	importedAt map[string]time.Time
+}
+
+func newCalendarWatcher(cfg CalendarConfig) *calendarWatcher {
+	w := &calendarWatcher{modTimes: make(map[string]time.Time), importedAt: make(map[string]time.Time)}
+	home, _ := os.UserHomeDir()
+	for _, file := range cfg.Files {
+		if strings.HasPrefix(file, "~/") && home != "" {
+			file = filepath.Join(home, file[2:])
+		}
+		w.files = append(w.files, file)
+	}
+	return w
+}
+
+// Import the files that changed since the last check, or were last imported a day
+// ago, and describe what happened. A file that can't be read is only reported the
+// first time.
+func (w *calendarWatcher) check(db *DB, now time.Time) []string {
+	var messages []string
+	for _, file := range w.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			if last, ok := w.modTimes[file]; !ok || !last.IsZero() {
+				messages = append(messages, fmt.Sprintf("[red]Error reading calendar %s: %v[white]", file, err))
+			}
+			w.modTimes[file] = time.Time{}
+			continue
+		}
+		if last, ok := w.modTimes[file]; ok && last.Equal(info.ModTime()) && now.Sub(w.importedAt[file]) < calendarRefreshInterval {
+			continue
+		}
+		w.modTimes[file], w.importedAt[file] = info.ModTime(), now
+
+		count, warnings, err := importCalendar(db, file, now)
+		if err != nil {
+			messages = append(messages, fmt.Sprintf("[red]Error importing calendar %s: %v[white]", file, err))
+			continue
+		}
+		for _, warning := range warnings {
+			messages = append(messages, fmt.Sprintf("[yellow]%s: %s[white]", filepath.Base(file), warning))
+		}
+		messages = append(messages, fmt.Sprintf("[yellow]Imported %d events from %s[white]", count, filepath.Base(file)))
+	}
+	return messages
+}
+
+func calendarCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "calendar",
+		Usage: "Import calendar events to compare meetings against tracked time",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "import",
+				Usage:     "Import the events of an .ics file, replacing those imported from it before",
+				ArgsUsage: "<file.ics>",
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return fmt.Errorf("expected one calendar file")
+					}
+					db, err := getDb()
+					if err != nil {
+						return fmt.Errorf("error connecting to database: %v", err)
+					}
+					defer db.Close()
+
+					count, warnings, err := importCalendar(db, c.Args().First(), time.Now())
+					for _, warning := range warnings {
+						fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+					}
+					if err != nil {
+						return err
+					}
+					fmt.Printf("Imported %d events\n", count)
+					return nil
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "List imported events, a week from today by default",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "since", Usage: "List events after this time (default: today)"},
+					&cli.StringFlag{Name: "until", Usage: "List events before this time (default: a week after since)"},
+				},
+				Action: func(c *cli.Context) error {
+					now := time.Now()
+					since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+					var err error
+					if value := c.String("since"); value != "" {
+						if since, err = parseTimeParam(value, now); err != nil {
+							return fmt.Errorf("invalid since: %v", err)
+						}
+					}
+					until := since.AddDate(0, 0, 7)
+					if value := c.String("until"); value != "" {
+						if until, err = parseTimeParam(value, now); err != nil {
+							return fmt.Errorf("invalid until: %v", err)
+						}
+					}
+					db, err := getDb()
+					if err != nil {
+						return fmt.Errorf("error connecting to database: %v", err)
+					}
+					defer db.Close()
+
+					events, err := getCalendarEvents(db, since, until)
+					if err != nil {
+						return err
+					}
+					for _, event := range events {
+						fmt.Printf("%s–%s  %s\n", event.StartTime.Format("2006-01-02 15:04"), event.EndTime.Format("15:04"), event.Summary)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
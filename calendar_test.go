@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Long-running recurring events are expanded around the import, not from their start
+func TestExpandEventsFromLowerBound(t *testing.T) {
+	from := time.Date(2024, 11, 4, 0, 0, 0, 0, time.Local)
+	daily := func(start time.Time, rrule string) icsEvent {
+		return icsEvent{UID: rrule, Summary: "Standup", Start: start, End: start.Add(15 * time.Minute), RRule: rrule}
+	}
+
+	for _, test := range []struct {
+		name      string
+		event     icsEvent
+		horizon   time.Time
+		want      int
+		truncated bool
+	}{
+		{"started years ago", daily(time.Date(2005, 1, 3, 9, 0, 0, 0, time.Local), "FREQ=DAILY"), from.AddDate(0, 0, 7), 7, false},
+		{"counted from the start", daily(from.AddDate(0, 0, -5).Add(9*time.Hour), "FREQ=DAILY;COUNT=8"), from.AddDate(0, 0, 7), 3, false},
+		{"too many", daily(from.Add(9*time.Hour), "FREQ=DAILY"), from.AddDate(0, 0, maxEventOccurrences+10), maxEventOccurrences, true},
+	} {
+		occurrences, warnings := expandEvents([]icsEvent{test.event}, from, test.horizon)
+		if len(occurrences) != test.want || (len(occurrences) > 0 && occurrences[0].StartTime.Before(from)) {
+			t.Errorf("%s: got %d occurrences, want %d from %v", test.name, len(occurrences), test.want, from)
+		}
+		if truncated := len(warnings) == 1 && strings.Contains(warnings[0], "occurrences"); truncated != test.truncated || (!test.truncated && len(warnings) > 0) {
+			t.Errorf("%s: got warnings %q", test.name, warnings)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+const UncategorizedCategory = "Uncategorized"
+
+// Get the category of an activity, based on the configured category patterns.
+// Patterns match an activity name exactly, or a domain and any of its subdomains.
+// Categories are checked in alphabetical order so the result is deterministic.
+func categorize(categories map[string][]string, activityName string) string {
+	names := make([]string, 0, len(categories))
+	for category := range categories {
+		names = append(names, category)
+	}
+	sort.Strings(names)
+
+	for _, category := range names {
+		for _, pattern := range categories[category] {
+			if activityMatches(pattern, activityName) {
+				return category
+			}
+		}
+	}
+	return UncategorizedCategory
+}
+
+// Get the category of an activity: the one it was given, or else the one its name
+// is in
+func activityCategory(categories map[string][]string, record ActivityRecord) string {
+	if record.Category != "" {
+		return record.Category
+	}
+	return categorize(categories, record.Name)
+}
+
+func activityMatches(pattern, activityName string) bool {
+	if pattern == "" || activityName == "" {
+		return false
+	}
+	pattern = strings.ToLower(pattern)
+	activityName = strings.ToLower(activityName)
+	return activityName == pattern || strings.HasSuffix(activityName, "."+pattern)
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Words that run the command after them
+var commandPrefixes = []string{"sudo", "doas", "env", "time", "nohup", "exec", "command", "builtin", "nice", "caffeinate"}
+
+// The columns of a stored command, in the order scanShellCommands reads them
+const commandColumns = "id, command_name, cwd, shell, pid, start_time, end_time, exit_code, app_name, device_id, hostname, origin_id"
+
+// A command run in a shell, reported by the shell hook. Only the command's name is
+// kept, since its arguments may contain secrets.
+type shellCommand struct {
+	ID        int64     `json:"id,omitempty"`
+	Name      string    `json:"name"`
+	Cwd       string    `json:"cwd"`
+	Shell     string    `json:"shell"`
+	PID       int       `json:"pid"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	ExitCode  int       `json:"exitCode"`
+	App       string    `json:"app,omitempty"` // the app that was frontmost when the command started
+	DeviceID  string    `json:"deviceId,omitempty"`
+	Hostname  string    `json:"hostname,omitempty"`
+	OriginID  int64     `json:"originId,omitempty"` // the id on the device that recorded it, if that's another database
+}
+
+// Get the id of the command on the device that recorded it
+func (c shellCommand) originID() int64 {
+	if c.OriginID != 0 {
+		return c.OriginID
+	}
+	return c.ID
+}
+
+func (db *DB) setupCommands() error {
+	idColumn := "INTEGER PRIMARY KEY AUTOINCREMENT"
+	if db.dbType == "postgres" {
+		idColumn = "SERIAL PRIMARY KEY"
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS commands (
+			id ` + idColumn + `,
+			command_name TEXT NOT NULL,
+			cwd TEXT NOT NULL,
+			shell TEXT NOT NULL,
+			pid INTEGER NOT NULL,
+			start_time TIMESTAMP NOT NULL,
+			end_time TIMESTAMP NOT NULL,
+			exit_code INTEGER NOT NULL,
+			app_name TEXT NOT NULL,
+			device_id TEXT NOT NULL,
+			hostname TEXT NOT NULL
+		)
+	`); err != nil {
+		return err
+	}
+	for _, column := range [][2]string{
+		{"origin_id", "BIGINT NOT NULL DEFAULT 0"},
+		{"stored_at", "TIMESTAMP"},
+	} {
+		if err := db.addColumnIfMissing("commands", column[0], column[1]); err != nil {
+			return err
+		}
+	}
+	_, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_commands_start_time ON commands(start_time)`)
+	return err
+}
+
+// Store a command reported on this device, with the app of this device's activity
+// when it started. Its working directory goes through the privacy rules like a
+// window title of that app.
+func (db *DB) insertShellCommand(command shellCommand, privacy *privacyFilter) error {
+	device, err := getDevice()
+	if err != nil {
+		return err
+	}
+	command.DeviceID, command.Hostname = device.ID, device.Hostname
+
+	err = db.QueryRow(db.rebind(`
+		SELECT app_name FROM activities
+		WHERE device_id = ? AND start_time <= ? AND (end_time > ? OR end_time IS NULL)
+		ORDER BY start_time DESC
+		LIMIT 1
+	`), device.ID, dbTime(command.StartTime), dbTime(command.StartTime)).Scan(&command.App)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error querying database: %v", err)
+	}
+	return db.storeShellCommand(db, privacy.applyToCommand(command))
+}
+
+// Insert a command as it is, apart from encrypting its working directory
+func (db *DB) storeShellCommand(q querier, command shellCommand) error {
+	if err := db.encryptCommand(&command); err != nil {
+		return err
+	}
+	_, err := q.Exec(db.rebind(`
+		INSERT INTO commands (command_name, cwd, shell, pid, start_time, end_time, exit_code, app_name, device_id, hostname, origin_id, stored_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`), command.Name, command.Cwd, command.Shell, command.PID, dbTime(command.StartTime), dbTime(command.EndTime),
+		command.ExitCode, command.App, command.DeviceID, command.Hostname, command.OriginID, dbTime(time.Now()))
+	if err != nil {
+		return fmt.Errorf("error inserting command: %v", err)
+	}
+	return nil
+}
+
+// Get the commands that overlap the given time range, ordered by start time
+func getShellCommands(db *DB, since, until time.Time) ([]shellCommand, error) {
+	rows, err := db.Query(db.rebind(`
+		SELECT `+commandColumns+`
+		FROM commands
+		WHERE start_time < ? AND end_time > ?
+		ORDER BY start_time, id
+	`), dbTime(until), dbTime(since))
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	commands, err := scanShellCommands(rows)
+	if err != nil {
+		return nil, err
+	}
+	return commands, db.decryptCommands(commands)
+}
+
+// Read commands as they're stored, with their working directories still encrypted
+func scanShellCommands(rows *sql.Rows) ([]shellCommand, error) {
+	var commands []shellCommand
+	for rows.Next() {
+		var command shellCommand
+		if err := rows.Scan(&command.ID, &command.Name, &command.Cwd, &command.Shell, &command.PID, &command.StartTime,
+			&command.EndTime, &command.ExitCode, &command.App, &command.DeviceID, &command.Hostname, &command.OriginID); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		command.StartTime, command.EndTime = localTime(command.StartTime), localTime(command.EndTime)
+		commands = append(commands, command)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rows: %v", err)
+	}
+	return commands, nil
+}
+
+// Prepare a command's working directory for storage, like activity titles
+func (db *DB) encryptCommand(command *shellCommand) error {
+	c, err := db.writeCipher()
+	if err == nil {
+		command.Cwd, err = storedValue(c, "cwd", command.Cwd)
+	}
+	return err
+}
+
+func (db *DB) decryptCommands(commands []shellCommand) error {
+	for i := range commands {
+		var err error
+		if commands[i].Cwd, err = db.loadedValue("cwd", commands[i].Cwd); err != nil {
+			return fmt.Errorf("command %d: %v", commands[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// Delete commands that ended before the cutoff, which are only of use alongside
+// the raw activities they ran during. Only the given device's commands are deleted,
+// or every device's if it's empty.
+func compactCommands(db *DB, cutoff time.Time, deviceID string) (int64, error) {
+	if cutoff.IsZero() {
+		return 0, nil
+	}
+	query, args := `DELETE FROM commands WHERE end_time < ?`, []any{dbTime(cutoff)}
+	if deviceID != "" {
+		query += ` AND device_id = ?`
+		args = append(args, deviceID)
+	}
+	result, err := db.Exec(db.rebind(query), args...)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting old commands: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// Copy all commands into another database in a single transaction, replacing the
+// destination's, with their ids
+func copyCommands(from, to *DB) error {
+	rows, err := from.Query(`SELECT ` + commandColumns + ` FROM commands ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("error reading commands: %v", err)
+	}
+	commands, err := scanShellCommands(rows)
+	rows.Close()
+	if err == nil {
+		err = from.decryptCommands(commands)
+	}
+	if err != nil {
+		return err
+	}
+
+	tx, err := to.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM commands`); err != nil {
+		return fmt.Errorf("error clearing destination commands: %v", err)
+	}
+	now := dbTime(time.Now())
+	for _, command := range commands {
+		if err := to.encryptCommand(&command); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(to.rebind(fmt.Sprintf("INSERT INTO commands (%s, stored_at) VALUES (%s)",
+			commandColumns, placeholders(13))), command.ID, command.Name, command.Cwd, command.Shell, command.PID, dbTime(command.StartTime),
+			dbTime(command.EndTime), command.ExitCode, command.App, command.DeviceID, command.Hostname, command.OriginID, now); err != nil {
+			return fmt.Errorf("error copying command %d: %v", command.ID, err)
+		}
+	}
+	if err := to.resetIDSequence(tx, "commands"); err != nil {
+		return fmt.Errorf("error resetting id sequence: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Copied %d shell commands\n", len(commands))
+	return nil
+}
+
+// Get the name of the program a command line runs, skipping variable assignments
+// and wrappers like sudo
+func commandName(line string) string {
+	for _, word := range strings.Fields(line) {
+		if strings.Contains(word, "=") && !strings.HasPrefix(word, "=") || strings.HasPrefix(word, "-") ||
+			slices.Contains(commandPrefixes, word) {
+			continue
+		}
+		return filepath.Base(strings.Trim(word, `"'`))
+	}
+	return ""
+}
+
+// Parse a Unix time with an optional fraction, as shells give it. The fraction's
+// separator depends on the locale.
+func parseEpoch(value string) (time.Time, error) {
+	seconds, err := strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), nil
+}
+
+// Split the time an activity spent in each command, counting the command that
+// started last when several were running, e.g. in different terminal tabs. Commands
+// must be ordered by start time.
+func commandTimes(record ActivityRecord, commands []shellCommand, start, end time.Time, times map[string]time.Duration) {
+	var running []shellCommand
+	boundaries := []time.Time{start, end}
+	for _, command := range commands {
+		if command.DeviceID != record.DeviceID || command.App != record.App ||
+			!command.StartTime.Before(end) || !command.EndTime.After(start) {
+			continue
+		}
+		running = append(running, command)
+		for _, t := range []time.Time{command.StartTime, command.EndTime} {
+			if t.After(start) && t.Before(end) {
+				boundaries = append(boundaries, t)
+			}
+		}
+	}
+	if len(running) == 0 {
+		return
+	}
+	slices.SortFunc(boundaries, func(a, b time.Time) int { return a.Compare(b) })
+
+	for i := 0; i+1 < len(boundaries); i++ {
+		segmentStart, segmentEnd := boundaries[i], boundaries[i+1]
+		for j := len(running) - 1; j >= 0; j-- {
+			if !running[j].StartTime.After(segmentStart) && !running[j].EndTime.Before(segmentEnd) {
+				times[running[j].Name] += segmentEnd.Sub(segmentStart)
+				break
+			}
+		}
+	}
+}
+
+func shellHookCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "shell-hook",
+		Usage:     "Print a shell hook that reports commands to the monitor, e.g. eval \"$(activitymon shell-hook zsh)\"",
+		ArgsUsage: "zsh|bash|fish",
+		Action: func(c *cli.Context) error {
+			executable, err := os.Executable()
+			if err != nil {
+				return fmt.Errorf("unable to find the activitymon executable: %v", err)
+			}
+			hook, ok := shellHooks[c.Args().First()]
+			if !ok {
+				return fmt.Errorf("unsupported shell: %q (expected zsh, bash or fish)", c.Args().First())
+			}
+			fmt.Print(strings.ReplaceAll(hook, "ACTIVITYMON", strconv.Quote(executable)))
+			return nil
+		},
+	}
+}
+
+func shellEventCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "shell-event",
+		Usage:     "Report a finished shell command to the monitor (used by the shell hooks)",
+		ArgsUsage: "<command line>",
+		Hidden:    true,
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "shell", Required: true},
+			&cli.IntFlag{Name: "pid", Required: true},
+			&cli.StringFlag{Name: "cwd", Required: true},
+			&cli.StringFlag{Name: "start", Usage: "Unix time the command started", Required: true},
+			&cli.StringFlag{Name: "end", Usage: "Unix time the command ended", Required: true},
+			&cli.IntFlag{Name: "exit-code"},
+		},
+		Action: func(c *cli.Context) error {
+			command := shellCommand{
+				Name:     commandName(strings.Join(c.Args().Slice(), " ")),
+				Cwd:      c.String("cwd"),
+				Shell:    c.String("shell"),
+				PID:      c.Int("pid"),
+				ExitCode: c.Int("exit-code"),
+			}
+			if command.Name == "" {
+				return nil
+			}
+			var err error
+			if command.StartTime, err = parseEpoch(c.String("start")); err != nil {
+				return fmt.Errorf("invalid start: %v", err)
+			}
+			if command.EndTime, err = parseEpoch(c.String("end")); err != nil {
+				return fmt.Errorf("invalid end: %v", err)
+			}
+			_, err = sendControlRequest(controlRequest{Command: "shell-command", ShellCommand: &command})
+			return err
+		},
+	}
+}
+
+// Hooks that report each command when it finishes. ACTIVITYMON is replaced with the
+// path of the executable, and reports are sent in the background, so a monitor that
+// isn't running doesn't slow down the shell.
+var shellHooks = map[string]string{
+	"zsh": `zmodload zsh/datetime
+_activitymon_preexec() {
+  _activitymon_start=$EPOCHREALTIME
+  _activitymon_cwd=$PWD
+  _activitymon_command=$1
+}
+_activitymon_precmd() {
+  local exit_code=$?
+  [[ -z $_activitymon_start ]] && return
+  ACTIVITYMON shell-event --shell zsh --pid $$ --cwd "$_activitymon_cwd" --start $_activitymon_start \
+    --end $EPOCHREALTIME --exit-code $exit_code -- "$_activitymon_command" &>/dev/null &!
+  unset _activitymon_start
+}
+autoload -Uz add-zsh-hook
+add-zsh-hook preexec _activitymon_preexec
+add-zsh-hook precmd _activitymon_precmd
+`,
+	"bash": `_activitymon_now() {
+  echo "${EPOCHREALTIME:-$(date +%s)}"
+}
+_activitymon_preexec() {
+  [[ -z $_activitymon_ready || -n $COMP_LINE || $BASH_COMMAND == _activitymon_* ]] && return
+  unset _activitymon_ready
+  _activitymon_start=$(_activitymon_now)
+  _activitymon_cwd=$PWD
+  _activitymon_command=$BASH_COMMAND
+}
+_activitymon_precmd() {
+  local exit_code=$?
+  if [[ -n $_activitymon_start ]]; then
+    (ACTIVITYMON shell-event --shell bash --pid $$ --cwd "$_activitymon_cwd" --start "$_activitymon_start" \
+      --end "$(_activitymon_now)" --exit-code $exit_code -- "$_activitymon_command" >/dev/null 2>&1 &)
+  fi
+  unset _activitymon_start
+}
+# keep a DEBUG trap that was set before, running it first
+_activitymon_trap=$(trap -p DEBUG)
+if [[ $_activitymon_trap != *_activitymon_preexec* ]]; then
+  _activitymon_trap=${_activitymon_trap#"trap -- "}
+  eval "_activitymon_debug_trap=${_activitymon_trap%" DEBUG"}"
+fi
+unset _activitymon_trap
+trap 'eval "$_activitymon_debug_trap"; _activitymon_preexec' DEBUG
+PROMPT_COMMAND="_activitymon_precmd${PROMPT_COMMAND:+; $PROMPT_COMMAND}; _activitymon_ready=1"
+`,
+	"fish": `function _activitymon_postexec --on-event fish_postexec
+  set -l exit_code $status
+  test -z "$argv[1]"; and return
+  set -l end (date +%s)
+  set -l start (math "$end - $CMD_DURATION / 1000")
+  ACTIVITYMON shell-event --shell fish --pid $fish_pid --cwd "$PWD" --start $start --end $end \
+    --exit-code $exit_code -- "$argv[1]" >/dev/null 2>&1 &
+  disown 2>/dev/null
+end
+`,
+}
@@ -9,13 +9,109 @@ import (
 	"github.com/urfave/cli/v2"
 )
 
+// Window titles and URLs are encrypted when a key store is set
+type EncryptionConfig struct {
+	KeyStore string `json:"keyStore,omitempty"` // "keyring" (macOS keychain or Secret Service) or "file"
+	KeyFile  string `json:"keyFile,omitempty"`  // defaults to database.key in the config directory
+}
+
 type DatabaseConfig struct {
-	Type            string `json:"type"` // "sqlite" or "postgres"
-	PostgresConnStr string `json:"postgresConnStr"`
+	Type            string           `json:"type"` // "sqlite" or "postgres"
+	PostgresConnStr string           `json:"postgresConnStr"`
+	SqlitePath      string           `json:"sqlitePath,omitempty"` // defaults to tracker.db in the config directory
+	Encryption      EncryptionConfig `json:"encryption"`
+}
+
+type NotifierConfig struct {
+	Type    string   `json:"type"`              // "auto", "notify-send", "osascript", "command" or "webhook"
+	Command []string `json:"command,omitempty"` // used by the "command" notifier
+	URL     string   `json:"url,omitempty"`     // used by the "webhook" notifier
+}
+
+type NotificationRule struct {
+	Name            string `json:"name"`
+	Kind            string `json:"kind"`               // "continuous" or "no-break"
+	Activity        string `json:"activity,omitempty"` // activity name or domain to match ("continuous" only)
+	Category        string `json:"category,omitempty"` // category to match ("continuous" only)
+	Minutes         int    `json:"minutes"`
+	CooldownMinutes int    `json:"cooldownMinutes,omitempty"`
+}
+
+type NotificationsConfig struct {
+	Notifier     NotifierConfig     `json:"notifier"`
+	BreakMinutes int                `json:"breakMinutes,omitempty"` // minimum time away that counts as a break
+	Rules        []NotificationRule `json:"rules,omitempty"`
+}
+
+// A hook runs on activity transitions: "activity.start", "activity.end", "away"
+// (when the screen is locked, asleep or the screen saver is running) and "idle"
+// (when there's been no input for Config.IdleMinutes)
+type HookConfig struct {
+	Events  []string `json:"events,omitempty"`  // events to run for; all events if empty
+	URL     string   `json:"url,omitempty"`     // receives the event as a JSON POST
+	Command []string `json:"command,omitempty"` // receives the event as JSON on stdin
+}
+
+// A redaction rule applies to activities in an app and/or on a domain and its
+// subdomains, or to all activities if neither is set
+type RedactionRule struct {
+	App         string  `json:"app,omitempty"`
+	Domain      string  `json:"domain,omitempty"`
+	Field       string  `json:"field,omitempty"`       // "title", "url", or both if empty
+	Action      string  `json:"action"`                // "drop", "hash" or "redact"
+	Pattern     string  `json:"pattern,omitempty"`     // regular expression to replace ("redact" only)
+	Replacement *string `json:"replacement,omitempty"` // defaults to "[redacted]"; may use $1 etc.
+}
+
+type PrivacyConfig struct {
+	Rules         []RedactionRule `json:"rules,omitempty"`
+	StoreTitles   bool            `json:"storeTitles,omitempty"`   // store window titles and URLs, not just the app or domain
+	KeepIncognito bool            `json:"keepIncognito,omitempty"` // store titles and URLs of private browsing windows
+}
+
+// A labeling rule gives the activities it matches a project and/or tags as they're
+// recorded. All conditions that are set must match.
+type LabelRule struct {
+	Activity string   `json:"activity,omitempty"` // activity name or domain, as in categories
+	App      string   `json:"app,omitempty"`
+	Title    string   `json:"title,omitempty"` // regular expression matching the window title
+	Project  string   `json:"project,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+// Activities in terminals and editors get the name of the git repository they're in
+// as their project, unless a label rule gives them one
+type GitProjectsConfig struct {
+	Disabled bool     `json:"disabled,omitempty"`
+	Apps     []string `json:"apps,omitempty"` // more terminals and editors to detect repositories in
+}
+
+type CalendarConfig struct {
+	Files    []string `json:"files,omitempty"`    // .ics files the monitor imports, and re-imports when they change
+	CallApps []string `json:"callApps,omitempty"` // more apps and sites used for calls
+}
+
+type TimesheetConfig struct {
+	IncrementMinutes int    `json:"incrementMinutes,omitempty"` // hours are rounded to this many minutes; defaults to 15
+	Rounding         string `json:"rounding,omitempty"`         // "nearest" (default), "up" or "down"
+}
+
+type RetentionConfig struct {
+	RawDays int `json:"rawDays,omitempty"` // days to keep raw activities before rolling them up into hourly totals; 0 keeps them forever
 }
 
 type Config struct {
-	Database DatabaseConfig `json:"database"`
+	Database      DatabaseConfig      `json:"database"`
+	Categories    map[string][]string `json:"categories,omitempty"` // category name -> activity names or domains
+	Notifications NotificationsConfig `json:"notifications"`
+	Hooks         []HookConfig        `json:"hooks,omitempty"`
+	IdleMinutes   int                 `json:"idleMinutes,omitempty"` // minutes without keyboard or mouse input before the current activity ends; 0 never ends it
+	Retention     RetentionConfig     `json:"retention"`
+	Privacy       PrivacyConfig       `json:"privacy"`
+	Labels        []LabelRule         `json:"labels,omitempty"` // applied in order; the first project set wins
+	Timesheet     TimesheetConfig     `json:"timesheet"`
+	GitProjects   GitProjectsConfig   `json:"gitProjects"`
+	Calendar      CalendarConfig      `json:"calendar"`
 }
 
 func getConfigDir() (string, error) {
@@ -50,6 +146,14 @@ func loadConfig() (*Config, error) {
 		Database: DatabaseConfig{
 			Type: "sqlite",
 		},
+		Notifications: NotificationsConfig{
+			Notifier:     NotifierConfig{Type: "auto"},
+			BreakMinutes: 5,
+		},
+		Timesheet: TimesheetConfig{
+			IncrementMinutes: 15,
+			Rounding:         "nearest",
+		},
 	}
 
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
@@ -85,7 +189,7 @@ func saveConfig(config *Config) error {
 func configCmd() *cli.Command {
 	return &cli.Command{
 		Name:  "config",
-		Usage: "Configure database settings",
+		Usage: "Configure activitymon settings",
 		Subcommands: []*cli.Command{
 			{
 				Name:  "use-sqlite",
@@ -116,7 +220,59 @@ func configCmd() *cli.Command {
 					}
 					cfg.Database.Type = "postgres"
 					cfg.Database.PostgresConnStr = c.String("connection-string")
-					return saveConfig(cfg)
+					if err := saveConfig(cfg); err != nil {
+						return err
+					}
+					fmt.Println("Now using PostgreSQL. To bring over your existing activities, run:")
+					fmt.Println("  activitymon db copy --from sqlite --to postgres")
+					return nil
+				},
+			},
+			{
+				Name:  "test-notification",
+				Usage: "Send a test notification through the configured notifier",
+				Action: func(c *cli.Context) error {
+					cfg, err := loadConfig()
+					if err != nil {
+						return err
+					}
+					notifier, err := newNotifier(cfg.Notifications.Notifier)
+					if err != nil {
+						return err
+					}
+					return notifier.Notify("activitymon", "This is a test notification")
+				},
+			},
+			{
+				Name:  "test-privacy",
+				Usage: "Show what would be stored for a window after applying the privacy rules",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "app", Usage: "Application name", Required: true},
+					&cli.StringFlag{Name: "title", Usage: "Window title"},
+					&cli.StringFlag{Name: "url", Usage: "URL of the active tab"},
+					&cli.BoolFlag{Name: "incognito", Usage: "Treat the window as a private browsing window"},
+				},
+				Action: func(c *cli.Context) error {
+					cfg, err := loadConfig()
+					if err != nil {
+						return err
+					}
+					privacy, err := newPrivacyFilter(cfg.Privacy)
+					if err != nil {
+						return err
+					}
+					app := c.String("app")
+					incognito := c.Bool("incognito") || (isBrowser(app) && hasIncognitoTitle(c.String("title")))
+					title, url := privacy.apply(app, c.String("title"), c.String("url"), incognito)
+					activityName := app
+					if domain := getDomain(url); domain != "" {
+						activityName = domain
+					}
+					if !cfg.Privacy.StoreTitles {
+						title, url = "(not stored)", "(not stored)"
+					}
+					fmt.Printf("Activity:  %s\nTitle:     %s\nURL:       %s\nIncognito: %v\n", activityName, title, url, incognito)
+					return nil
 				},
 			},
 		},
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+type controlRequest struct {
+	Command      string        `json:"command"`                // "status", "pause", "resume", "reload-config", "shell-command" or "editor"
+	Duration     string        `json:"duration,omitempty"`     // optional pause duration, e.g. "30m"
+	ShellCommand *shellCommand `json:"shellCommand,omitempty"` // a finished command, from the shell hook
+	Editor       *editorReport `json:"editor,omitempty"`       // the file being edited, from an editor plugin
+}
+
+type controlResponse struct {
+	OK     bool           `json:"ok"`
+	Error  string         `json:"error,omitempty"`
+	Status *monitorStatus `json:"status,omitempty"`
+}
+
+type monitorStatus struct {
+	Activity      string          `json:"activity"`
+	Since         time.Time       `json:"since"`
+	Paused        bool            `json:"paused"`
+	PausedUntil   *time.Time      `json:"pausedUntil,omitempty"`
+	SessionStart  time.Time       `json:"sessionStart"`
+	SessionTotals []activityTotal `json:"sessionTotals"`
+}
+
+type activityTotal struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+// A control request waiting to be handled by the monitor loop
+type controlCall struct {
+	request controlRequest
+	reply   chan controlResponse
+}
+
+// Unix socket paths are limited to 104 bytes on macOS, including the terminating NUL
+const maxSocketPathLength = 103
+
+// Get the control socket of the monitor for the configured database. There's one
+// monitor per database, so the socket is next to its instance lock, or in the config
+// directory if that path would be too long for a socket.
+func getSocketPath(cfg *Config) (string, error) {
+	lockPath, err := getLockPath(cfg)
+	if err != nil {
+		return "", err
+	}
+	socketPath := strings.TrimSuffix(lockPath, ".lock") + ".sock"
+	if len(socketPath) <= maxSocketPathLength {
+		return socketPath, nil
+	}
+	configDir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(socketPath))
+	return filepath.Join(configDir, fmt.Sprintf("activitymon-%x.sock", sum[:8])), nil
+}
+
+// Listen on the control socket and pass requests on to the monitor loop
+func startControlServer(ctx context.Context, socketPath string, calls chan<- controlCall) error {
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return fmt.Errorf("another monitor is already listening on %s", socketPath)
+	}
+	// the socket file is left behind if a monitor doesn't shut down cleanly
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing stale control socket: %v", err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("error listening on control socket: %v", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleControlConn(ctx, conn, calls)
+		}
+	}()
+
+	return nil
+}
+
+func handleControlConn(ctx context.Context, conn net.Conn, calls chan<- controlCall) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	var response controlResponse
+	var request controlRequest
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return
+	}
+	if err := json.Unmarshal(line, &request); err != nil {
+		response = controlResponse{Error: fmt.Sprintf("invalid request: %v", err)}
+	} else {
+		call := controlCall{request: request, reply: make(chan controlResponse, 1)}
+		select {
+		case calls <- call:
+			response = <-call.reply
+		case <-ctx.Done():
+			response = controlResponse{Error: "monitor is shutting down"}
+		}
+	}
+
+	json.NewEncoder(conn).Encode(response)
+}
+
+// Send a request to the monitor running for the configured database
+func sendControlRequest(request controlRequest) (*controlResponse, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	socketPath, err := getSocketPath(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("unix", socketPath, 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the monitor (is it running?): %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+
+	var response controlResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error reading response: %v", err)
+	}
+	if !response.OK {
+		return nil, errors.New(response.Error)
+	}
+	return &response, nil
+}
+
+func statusCmd(c *cli.Context) error {
+	response, err := sendControlRequest(controlRequest{Command: "status"})
+	if err != nil {
+		return err
+	}
+
+	status := response.Status
+	switch {
+	case status.Paused && status.PausedUntil != nil:
+		fmt.Printf("Paused until %s\n", status.PausedUntil.Format("15:04:05"))
+	case status.Paused:
+		fmt.Println("Paused")
+	case status.Activity == "":
+		fmt.Println("No current activity")
+	default:
+		fmt.Printf("Current activity: %s (since %s, %s)\n",
+			status.Activity, status.Since.Format("15:04:05"), formatTime(time.Since(status.Since)))
+	}
+
+	fmt.Printf("Session started: %s\n", status.SessionStart.Format("2006-01-02 15:04:05"))
+	for _, total := range status.SessionTotals {
+		fmt.Printf("  %-30s %s\n", truncateString(total.Name, 30), formatTime(time.Duration(total.Seconds*float64(time.Second))))
+	}
+	return nil
+}
+
+func pauseCmd(c *cli.Context) error {
+	request := controlRequest{Command: "pause", Duration: c.Args().First()}
+	if request.Duration != "" {
+		if _, err := time.ParseDuration(request.Duration); err != nil {
+			return fmt.Errorf("invalid duration: %v", err)
+		}
+	}
+	if _, err := sendControlRequest(request); err != nil {
+		return err
+	}
+	if request.Duration != "" {
+		fmt.Printf("Tracking paused for %s\n", request.Duration)
+	} else {
+		fmt.Println("Tracking paused")
+	}
+	return nil
+}
+
+func resumeCmd(c *cli.Context) error {
+	if _, err := sendControlRequest(controlRequest{Command: "resume"}); err != nil {
+		return err
+	}
+	fmt.Println("Tracking resumed")
+	return nil
+}
+
+func reloadConfigCmd(c *cli.Context) error {
+	if _, err := sendControlRequest(controlRequest{Command: "reload-config"}); err != nil {
+		return err
+	}
+	fmt.Println("Configuration reloaded")
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The socket is per database, like the instance lock
+func TestGetSocketPath(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	configDir, err := getConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	long := filepath.Join(dir, strings.Repeat("d", 100), "tracker.db")
+
+	paths := make(map[string]bool)
+	for _, tt := range []struct {
+		db   DatabaseConfig
+		want string
+	}{
+		{DatabaseConfig{Type: "sqlite"}, filepath.Join(configDir, "tracker.db.sock")},
+		{DatabaseConfig{Type: "sqlite", SqlitePath: filepath.Join(dir, "work.db")}, filepath.Join(dir, "work.db.sock")},
+		{DatabaseConfig{Type: "postgres", PostgresConnStr: "postgres://localhost/a"}, ""},
+		{DatabaseConfig{Type: "postgres", PostgresConnStr: "postgres://localhost/b"}, ""},
+		{DatabaseConfig{Type: "sqlite", SqlitePath: long}, ""},
+	} {
+		got, err := getSocketPath(&Config{Database: tt.db})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.want != "" && got != tt.want {
+			t.Errorf("%+v: got %s, want %s", tt.db, got, tt.want)
+		}
+		if tt.want == "" && (filepath.Dir(got) != configDir || !strings.HasSuffix(got, ".sock")) {
+			t.Errorf("%+v: got %s, want a socket in the config directory", tt.db, got)
+		}
+		if len(got) > maxSocketPathLength {
+			t.Errorf("%+v: %s is too long for a socket", tt.db, got)
+		}
+		if paths[got] {
+			t.Errorf("%+v: %s is shared with another database", tt.db, got)
+		}
+		paths[got] = true
+	}
+}
+
+// Requests are passed to the monitor loop one per connection, and answered as JSON
+func TestControlServer(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "test.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := make(chan controlCall)
+	if err := startControlServer(ctx, socketPath, calls); err != nil {
+		t.Fatal(err)
+	}
+	if err := startControlServer(ctx, socketPath, make(chan controlCall)); err == nil {
+		t.Error("a second server started on the same socket")
+	}
+	go func() {
+		for call := range calls {
+			if call.request.Command == "pause" {
+				call.reply <- controlResponse{Error: "paused for " + call.request.Duration}
+				continue
+			}
+			call.reply <- controlResponse{OK: true, Status: &monitorStatus{Activity: call.request.Command}}
+		}
+	}()
+
+	send := func(line string) controlResponse {
+		t.Helper()
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		conn.(*net.UnixConn).CloseWrite()
+		var response controlResponse
+		if err := json.NewDecoder(conn).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	if response := send(`{"command": "status"}` + "\n"); !response.OK || response.Status == nil || response.Status.Activity != "status" {
+		t.Errorf("got %+v, want the loop's answer", response)
+	}
+	// a request without a newline is read to the end of the connection
+	if response := send(`{"command": "pause", "duration": "5m"}`); response.OK || response.Error != "paused for 5m" {
+		t.Errorf("got %+v, want the loop's error", response)
+	}
+	if response := send("not json\n"); response.OK || !strings.Contains(response.Error, "invalid request") {
+		t.Errorf("got %+v, want an invalid request", response)
+	}
+
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		t.Error("the socket still accepts connections after shutdown")
+	}
+}
+
+// Collects log entries, for tests
+type testDisplay struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (d *testDisplay) Start() error { return nil }
+func (d *testDisplay) Stop()        {}
+func (d *testDisplay) AddLogEntry(entry string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries = append(d.entries, entry)
+}
+func (d *testDisplay) UpdateStats(stats string) {}
+
+// The status, pause and resume commands control the running monitor
+func TestMonitorControlCommands(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Notifications.Notifier = NotifierConfig{Type: "command", Command: []string{"true"}}
+	db, err := getDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	socketPath, err := getSocketPath(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- monitor(ctx, db, cfg, &testDisplay{}, newMonitorMetrics()) }()
+	defer func() {
+		cancel()
+		if err := <-errChan; err != nil {
+			t.Error(err)
+		}
+	}()
+	for i := 0; ; i++ {
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			break
+		}
+		if i == 100 {
+			t.Fatal("the monitor didn't start listening")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	status := func() *monitorStatus {
+		t.Helper()
+		response, err := sendControlRequest(controlRequest{Command: "status"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Status
+	}
+	if got := status(); got.Paused || got.SessionStart.IsZero() {
+		t.Errorf("got %+v, want a running session", got)
+	}
+
+	if _, err := sendControlRequest(controlRequest{Command: "pause", Duration: "30m"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := status(); !got.Paused || got.PausedUntil == nil || time.Until(*got.PausedUntil) < 29*time.Minute {
+		t.Errorf("got %+v, want paused for 30 minutes", got)
+	}
+	if _, err := sendControlRequest(controlRequest{Command: "pause"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := status(); !got.Paused || got.PausedUntil != nil {
+		t.Errorf("got %+v, want paused until resumed", got)
+	}
+	if _, err := sendControlRequest(controlRequest{Command: "pause", Duration: "soon"}); err == nil || !strings.Contains(err.Error(), "invalid duration") {
+		t.Errorf("got %v, want an invalid duration", err)
+	}
+
+	if _, err := sendControlRequest(controlRequest{Command: "resume"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := status(); got.Paused || got.PausedUntil != nil {
+		t.Errorf("got %+v, want tracking resumed", got)
+	}
+}
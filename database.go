@@ -4,15 +4,24 @@ import (
 	"database/sql"
 	"fmt"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Timestamps are stored as local time in this format
+const dbTimeFormat = "2006-01-02 15:04:05"
+
 type DB struct {
 	*sql.DB
-	dbType string
+	dbType     string
+	encryption EncryptionConfig
+	cipherMu   sync.Mutex
+	cipher     *fieldCipher // loaded when first needed
 }
 
 func getDb() (*DB, error) {
@@ -20,31 +29,46 @@ func getDb() (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	return openDb(cfg.Database)
+}
+
+// Get the path of the sqlite database, which is in the config directory by default
+func getSqlitePath(dbCfg DatabaseConfig) (string, error) {
+	if dbCfg.SqlitePath != "" {
+		return dbCfg.SqlitePath, nil
+	}
+	configDir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "tracker.db"), nil
+}
 
+func openDb(dbCfg DatabaseConfig) (*DB, error) {
 	var db *sql.DB
-	switch cfg.Database.Type {
+	switch dbCfg.Type {
 	case "sqlite":
-		configDir, err := getConfigDir()
+		dbPath, err := getSqlitePath(dbCfg)
 		if err != nil {
 			return nil, err
 		}
-		dbPath := filepath.Join(configDir, "tracker.db")
 		db, err = sql.Open("sqlite3", dbPath)
 		if err != nil {
 			return nil, fmt.Errorf("error opening sqlite database: %v", err)
 		}
 
 	case "postgres":
-		db, err = sql.Open("postgres", cfg.Database.PostgresConnStr)
+		var err error
+		db, err = sql.Open("postgres", dbCfg.PostgresConnStr)
 		if err != nil {
 			return nil, fmt.Errorf("error opening postgres database: %v", err)
 		}
 
 	default:
-		return nil, fmt.Errorf("unsupported database type: %s", cfg.Database.Type)
+		return nil, fmt.Errorf("unsupported database type: %s", dbCfg.Type)
 	}
 
-	wrappedDB := &DB{db, cfg.Database.Type}
+	wrappedDB := &DB{DB: db, dbType: dbCfg.Type, encryption: dbCfg.Encryption}
 
 	// try to create tables if they don't exist
 	if err := wrappedDB.Setup(); err != nil {
@@ -79,46 +103,284 @@ func (db *DB) Setup() error {
 		return fmt.Errorf("error creating table: %v", err)
 	}
 
+	for _, column := range [][2]string{
+		{"app_name", "TEXT NOT NULL DEFAULT ''"},
+		{"window_title", "TEXT NOT NULL DEFAULT ''"},
+		{"url", "TEXT NOT NULL DEFAULT ''"},
+		{"device_id", "TEXT NOT NULL DEFAULT ''"},
+		{"hostname", "TEXT NOT NULL DEFAULT ''"},
+		{"origin_id", "BIGINT NOT NULL DEFAULT 0"},
+		{"updated_at", "TIMESTAMP"},
+		{"stored_at", "TIMESTAMP"},
+		{"source", "TEXT NOT NULL DEFAULT ''"},
+		{"file_path", "TEXT NOT NULL DEFAULT ''"},
+		{"language", "TEXT NOT NULL DEFAULT ''"},
+		{"category", "TEXT NOT NULL DEFAULT ''"},
+	} {
+		if err := db.addColumnIfMissing("activities", column[0], column[1]); err != nil {
+			return fmt.Errorf("error adding column %s: %v", column[0], err)
+		}
+	}
+
 	if _, err := db.Exec(`
 		CREATE INDEX IF NOT EXISTS idx_activities_start_time ON activities(start_time)
 	`); err != nil {
 		return fmt.Errorf("error creating index: %v", err)
 	}
 
+	if err := db.setupRollups(); err != nil {
+		return fmt.Errorf("error creating rollups table: %v", err)
+	}
+	if err := db.setupLabels(); err != nil {
+		return fmt.Errorf("error creating label tables: %v", err)
+	}
+	if err := db.setupCommands(); err != nil {
+		return fmt.Errorf("error creating commands table: %v", err)
+	}
+	if err := db.setupDeletions(); err != nil {
+		return fmt.Errorf("error creating deletions table: %v", err)
+	}
+	if err := db.setupEdits(); err != nil {
+		return fmt.Errorf("error creating edit tables: %v", err)
+	}
+	if err := db.setupCalendar(); err != nil {
+		return fmt.Errorf("error creating calendar table: %v", err)
+	}
+
 	return nil
 }
 
+// Add a column that was introduced after the table was first created
+func (db *DB) addColumnIfMissing(table, column, definition string) error {
+	if db.dbType == "postgres" {
+		_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, column, definition))
+		return err
+	}
+
+	exists, err := db.hasColumn(table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func (db *DB) hasColumn(table, column string) (bool, error) {
+	if db.dbType == "postgres" {
+		var count int
+		err := db.QueryRow(`
+			SELECT COUNT(*) FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2
+		`, table, column).Scan(&count)
+		return count > 0, err
+	}
+
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// Format a time for storage, in local time
+func dbTime(t time.Time) string {
+	return t.Local().Format(dbTimeFormat)
+}
+
+// Both drivers return stored timestamps as UTC, but they hold local wall clock time
+func localTime(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+}
+
+// Get a comma-separated list of n ? placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// Rewrite ? placeholders as $1, $2, ... for postgres
+func (db *DB) rebind(query string) string {
+	if db.dbType != "postgres" {
+		return query
+	}
+
+	var buf strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			buf.WriteString("$" + strconv.Itoa(n))
+		} else {
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+// End this device's activities that were left unfinished, e.g. by a crash
 func (db *DB) cleanupUnfinishedActivities() error {
 	now := time.Now()
 	fiveMinutesAgo := now.Add(-5 * time.Minute)
+	device, err := getDevice()
+	if err != nil {
+		return err
+	}
 
-	_, err := db.Exec(`
+	_, err = db.Exec(db.rebind(`
 		UPDATE activities
 		SET end_time = CASE
 			WHEN start_time > ? THEN start_time
 			ELSE ?
-		END
-		WHERE end_time IS NULL
-	`, fiveMinutesAgo.Format("2006-01-02 15:04:05"), now.Format("2006-01-02 15:04:05"))
+		END,
+		updated_at = ?, stored_at = ?
+		WHERE end_time IS NULL AND (device_id = ? OR device_id = '')
+	`), fiveMinutesAgo.Format("2006-01-02 15:04:05"), now.Format("2006-01-02 15:04:05"), dbTime(now), dbTime(now), device.ID)
+
+	return err
+}
+
+// Mark the activity with the given id as still going on, if it hasn't been ended
+func (db *DB) touchCurrentActivity(id int64, now time.Time) error {
+	_, err := db.Exec(db.rebind(`
+		UPDATE activities
+		SET updated_at = ?, stored_at = ?
+		WHERE id = ? AND end_time IS NULL
+	`), dbTime(now), dbTime(now), id)
 
 	return err
 }
 
-func (db *DB) endCurrentActivity(endTime time.Time) error {
-	_, err := db.Exec(`
+// End the activity with the given id, if it hasn't been ended already
+func (db *DB) endCurrentActivity(id int64, endTime time.Time) error {
+	now := dbTime(time.Now())
+	_, err := db.Exec(db.rebind(`
 		UPDATE activities
-		SET end_time = ?
-		WHERE end_time IS NULL
-	`, endTime.Format("2006-01-02 15:04:05"))
+		SET end_time = ?, updated_at = ?, stored_at = ?
+		WHERE id = ? AND end_time IS NULL
+	`), endTime.Format("2006-01-02 15:04:05"), now, now, id)
 
 	return err
 }
 
-func (db *DB) insertActivity(startTime time.Time, activityName string) error {
-	_, err := db.Exec(`
-		INSERT INTO activities (start_time, activity_name)
-		VALUES (?, ?)
-	`, startTime.Format("2006-01-02 15:04:05"), activityName)
+// Insert a new activity and return its id. The record's id is used if it's set,
+// and activities without a device are recorded for this device. The time the row
+// was stored is kept separately from the record's own update time, for sync.
+func (db *DB) insertActivity(q querier, record ActivityRecord) (int64, error) {
+	if record.DeviceID == "" {
+		device, err := getDevice()
+		if err != nil {
+			return 0, err
+		}
+		record.DeviceID, record.Hostname = device.ID, device.Hostname
+	}
 
+	values, err := db.activityValues(record)
+	if err != nil {
+		return 0, err
+	}
+	columns := strings.Join(activityFields, ", ") + ", stored_at"
+	args := append(values, dbTime(time.Now()))
+	if record.ID != 0 {
+		// an activity that's restored is no longer deleted
+		if _, err := q.Exec(db.rebind(`DELETE FROM activity_deletions WHERE device_id = ? AND origin_id = ?`),
+			record.DeviceID, record.originID()); err != nil {
+			return 0, fmt.Errorf("error clearing deletion of activity %d: %v", record.ID, err)
+		}
+		columns = "id, " + columns
+		args = append([]any{record.ID}, args...)
+	}
+	id, err := db.insertReturningID(q, fmt.Sprintf("INSERT INTO activities (%s) VALUES (%s)", columns, placeholders(len(args))), args...)
+	if err != nil || (len(record.Projects) == 0 && len(record.Tags) == 0) {
+		return id, err
+	}
+	return id, db.saveLabels(q, id, record)
+}
+
+// Run an insert into a table with an id column, and return the new row's id
+func (db *DB) insertReturningID(q querier, query string, args ...any) (int64, error) {
+	if db.dbType == "postgres" {
+		var id int64
+		err := q.QueryRow(db.rebind(query)+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := q.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// Replace an activity's fields
+func (db *DB) updateActivity(q querier, record ActivityRecord) error {
+	values, err := db.activityValues(record)
+	if err != nil {
+		return err
+	}
+	assignments := make([]string, len(activityFields))
+	for i, field := range activityFields {
+		assignments[i] = field + " = ?"
+	}
+	_, err = q.Exec(db.rebind(fmt.Sprintf("UPDATE activities SET %s, stored_at = ? WHERE id = ?",
+		strings.Join(assignments, ", "))), append(values, dbTime(time.Now()), record.ID)...)
+	if err != nil {
+		return fmt.Errorf("error updating activity %d: %v", record.ID, err)
+	}
+	return db.saveLabels(q, record.ID, record)
+}
+
+// Delete an activity, leaving a tombstone so the deletion reaches other databases
+// when they're synced
+func (db *DB) deleteActivity(q querier, id int64) error {
+	var deviceID string
+	var originID int64
+	err := q.QueryRow(db.rebind(`SELECT device_id, origin_id FROM activities WHERE id = ?`), id).Scan(&deviceID, &originID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error querying database: %v", err)
+	}
+	if deviceID == "" {
+		device, err := getDevice()
+		if err != nil {
+			return err
+		}
+		deviceID = device.ID
+	}
+	if originID == 0 {
+		originID = id
+	}
+	now := dbTime(time.Now())
+	if err := db.saveDeletion(q, activityDeletion{DeviceID: deviceID, OriginID: originID}, now, now); err != nil {
+		return err
+	}
+
+	if _, err := q.Exec(db.rebind(`DELETE FROM activities WHERE id = ?`), id); err != nil {
+		return fmt.Errorf("error deleting activity %d: %v", id, err)
+	}
+	return db.deleteLabels(q, id)
+}
+
+// Move the postgres id sequence past ids that were inserted explicitly
+func (db *DB) resetIDSequence(q querier, table string) error {
+	if db.dbType != "postgres" {
+		return nil
+	}
+	_, err := q.Exec(fmt.Sprintf(
+		"SELECT setval(pg_get_serial_sequence('%s', 'id'), COALESCE((SELECT MAX(id) FROM %s), 0) + 1, false)",
+		table, table))
 	return err
 }
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+const copyBatchSize = 1000
+
+// A PostgreSQL connection string in keyword/value form, e.g. "host=localhost dbname=activitymon"
+var postgresKeywordPattern = regexp.MustCompile(`^[a-z_]+=`)
+
+func dbCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "db",
+		Usage: "Manage activity databases",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "copy",
+				Usage: "Copy activities from one database to another",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "from",
+						Usage:    "Database to copy from: sqlite or postgres for the configured one, a PostgreSQL connection string or a SQLite path",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "to",
+						Usage:    "Database to copy to: sqlite or postgres for the configured one, a PostgreSQL connection string or a SQLite path",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "sqlite-path",
+						Usage: "SQLite database path for --from/--to sqlite (default: the configured path)",
+					},
+					&cli.StringFlag{
+						Name:  "postgres-connection-string",
+						Usage: "PostgreSQL connection string for --from/--to postgres (default: the configured connection string)",
+					},
+					&cli.IntFlag{
+						Name:  "batch-size",
+						Usage: "Number of activities to copy per transaction",
+						Value: copyBatchSize,
+					},
+				},
+				Action: dbCopyCmd,
+			},
+			{
+				Name:  "compact",
+				Usage: "Roll up activities older than the retention period into hourly totals",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "raw-days",
+						Usage: "Days of raw activities to keep (default: retention.rawDays from the config)",
+					},
+					&cli.BoolFlag{
+						Name:  "all-devices",
+						Usage: "Roll up the activities of every device, not just this one's",
+					},
+					&cli.BoolFlag{
+						Name:  "vacuum",
+						Usage: "Reclaim the space freed in a SQLite database",
+					},
+				},
+				Action: dbCompactCmd,
+			},
+			{
+				Name:   "rekey",
+				Usage:  "Re-encrypt window titles and URLs with a new key",
+				Action: dbRekeyCmd,
+			},
+		},
+	}
+}
+
+func dbCopyCmd(c *cli.Context) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	backend := func(arg string) DatabaseConfig {
+		dbCfg := cfg.Database
+		if path := c.String("sqlite-path"); path != "" {
+			dbCfg.SqlitePath = path
+		}
+		if connStr := c.String("postgres-connection-string"); connStr != "" {
+			dbCfg.PostgresConnStr = connStr
+		}
+		return databaseArg(dbCfg, arg)
+	}
+	fromCfg, toCfg := backend(c.String("from")), backend(c.String("to"))
+
+	source, err := databaseIdentity(fromCfg)
+	if err != nil {
+		return err
+	}
+	destination, err := databaseIdentity(toCfg)
+	if err != nil {
+		return err
+	}
+	if source == destination {
+		return fmt.Errorf("--from and --to must be different databases")
+	}
+
+	from, err := openDb(fromCfg)
+	if err != nil {
+		return fmt.Errorf("error opening source database: %v", err)
+	}
+	defer from.Close()
+
+	to, err := openDb(toCfg)
+	if err != nil {
+		return fmt.Errorf("error opening destination database: %v", err)
+	}
+	defer to.Close()
+
+	return copyActivities(from, to, source, c.Int("batch-size"))
+}
+
+// Get the database a --from or --to argument refers to: a backend name for the
+// configured database of that type, a PostgreSQL connection string or a SQLite path
+func databaseArg(dbCfg DatabaseConfig, arg string) DatabaseConfig {
+	switch {
+	case arg == "sqlite" || arg == "postgres":
+		dbCfg.Type = arg
+	case strings.HasPrefix(arg, "postgres://") || strings.HasPrefix(arg, "postgresql://") || postgresKeywordPattern.MatchString(arg):
+		dbCfg.Type, dbCfg.PostgresConnStr = "postgres", arg
+	default:
+		dbCfg.Type, dbCfg.SqlitePath = "sqlite", arg
+	}
+	return dbCfg
+}
+
+// Identify a database, so copies from it can be resumed
+func databaseIdentity(dbCfg DatabaseConfig) (string, error) {
+	if dbCfg.Type == "postgres" {
+		sum := sha256.Sum256([]byte(dbCfg.PostgresConnStr))
+		return fmt.Sprintf("postgres:%x", sum[:8]), nil
+	}
+	path, err := getSqlitePath(dbCfg)
+	if err != nil {
+		return "", err
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return "", err
+	}
+	return "sqlite:" + path, nil
+}
+
+// How far a copy from a source database got: activities up to lastID have been
+// copied as they were when the copy started at copiedAt
+type copyProgress struct {
+	lastID   int64
+	copiedAt string
+}
+
+// Copy activities in batches, keeping their ids. Each batch is committed along with
+// the last id copied, so an interrupted copy picks up where it left off. Copying
+// again also brings over activities changed or deleted since the last copy.
+func copyActivities(from, to *DB, source string, batchSize int) error {
+	if _, err := to.Exec(`
+		CREATE TABLE IF NOT EXISTS copy_progress (
+			source TEXT PRIMARY KEY,
+			last_id BIGINT NOT NULL
+		)`); err != nil {
+		return fmt.Errorf("error creating copy_progress table: %v", err)
+	}
+	if err := to.addColumnIfMissing("copy_progress", "copied_at", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("error adding column copied_at: %v", err)
+	}
+
+	started := dbTime(time.Now())
+	var progress copyProgress
+	err := to.QueryRow(to.rebind(`SELECT last_id, copied_at FROM copy_progress WHERE source = ?`), source).
+		Scan(&progress.lastID, &progress.copiedAt)
+	switch {
+	case err == sql.ErrNoRows:
+		var existing int64
+		if err := to.QueryRow(`SELECT COUNT(*) FROM activities`).Scan(&existing); err != nil {
+			return fmt.Errorf("error counting destination activities: %v", err)
+		}
+		if existing > 0 {
+			return fmt.Errorf("destination already has %d activities; copy into an empty database", existing)
+		}
+	case err != nil:
+		return fmt.Errorf("error reading copy progress: %v", err)
+	default:
+		fmt.Fprintf(os.Stderr, "Resuming copy after activity %d\n", progress.lastID)
+		changed, err := copyChangedActivities(from, to, source, progress, batchSize)
+		if err != nil {
+			return err
+		}
+		if changed > 0 {
+			fmt.Fprintf(os.Stderr, "Copied %d activities changed since the last copy\n", changed)
+		}
+	}
+
+	var total int64
+	if err := from.QueryRow(`SELECT COUNT(*) FROM activities`).Scan(&total); err != nil {
+		return fmt.Errorf("error counting source activities: %v", err)
+	}
+	var copied int64
+	if err := from.QueryRow(from.rebind(`SELECT COUNT(*) FROM activities WHERE id <= ?`), progress.lastID).Scan(&copied); err != nil {
+		return fmt.Errorf("error counting source activities: %v", err)
+	}
+
+	for {
+		batch, err := readCopyBatch(from, `id > ?`, progress.lastID, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		progress = copyProgress{lastID: batch[len(batch)-1].ID, copiedAt: started}
+		if err := copyBatch(to, source, batch, progress); err != nil {
+			return err
+		}
+		copied += int64(len(batch))
+		fmt.Fprintf(os.Stderr, "Copied %d/%d activities\n", copied, total)
+	}
+
+	// Catch up with activities that changed while copying, such as the one being
+	// recorded, and with the ones deleted from the source
+	progress.copiedAt = started
+	if _, err := copyChangedActivities(from, to, source, progress, batchSize); err != nil {
+		return err
+	}
+	deleted, err := deleteMissingActivities(from, to, progress.lastID)
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		fmt.Fprintf(os.Stderr, "Deleted %d activities no longer in the source\n", deleted)
+	}
+
+	if err := to.resetIDSequence(to, "activities"); err != nil {
+		return fmt.Errorf("error resetting id sequence: %v", err)
+	}
+	if err := copyRollups(from, to); err != nil {
+		return err
+	}
+	if err := copyCommands(from, to); err != nil {
+		return err
+	}
+	return verifyCopy(from, to, progress.lastID)
+}
+
+// Read a batch of activities with their labels, in id order
+func readCopyBatch(from *DB, where string, after int64, batchSize int, args ...any) ([]ActivityRecord, error) {
+	rows, err := from.Query(from.rebind(`
+		SELECT `+activityColumns+`
+		FROM activities
+		WHERE `+where+`
+		ORDER BY id
+		LIMIT ?
+	`), append(append([]any{after}, args...), batchSize)...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading activities: %v", err)
+	}
+	batch, err := scanActivities(rows)
+	rows.Close()
+	if err == nil {
+		err = from.decryptActivities(batch)
+	}
+	if err == nil {
+		err = from.loadLabels(from, batch)
+	}
+	return batch, err
+}
+
+// Copy the already copied activities that were stored since the copy recorded in
+// progress started, or all of them for copies that didn't record when they started
+func copyChangedActivities(from, to *DB, source string, progress copyProgress, batchSize int) (int, error) {
+	changed := 0
+	for after := int64(0); ; {
+		where := `id > ? AND id <= ?`
+		args := []any{progress.lastID}
+		if progress.copiedAt != "" {
+			where += ` AND stored_at >= ?`
+			args = append(args, progress.copiedAt)
+		}
+		batch, err := readCopyBatch(from, where, after, batchSize, args...)
+		if err != nil {
+			return changed, err
+		}
+		if len(batch) == 0 {
+			return changed, nil
+		}
+		if err := copyBatch(to, source, batch, progress); err != nil {
+			return changed, err
+		}
+		after = batch[len(batch)-1].ID
+		changed += len(batch)
+	}
+}
+
+// Delete the copied activities, up to lastID, that are no longer in the source
+func deleteMissingActivities(from, to *DB, lastID int64) (int, error) {
+	ids := func(db *DB) ([]int64, error) {
+		rows, err := db.Query(db.rebind(`SELECT id FROM activities WHERE id <= ? ORDER BY id`), lastID)
+		if err != nil {
+			return nil, fmt.Errorf("error reading activity ids: %v", err)
+		}
+		defer rows.Close()
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return nil, fmt.Errorf("error scanning row: %v", err)
+			}
+			ids = append(ids, id)
+		}
+		return ids, rows.Err()
+	}
+	fromIDs, err := ids(from)
+	if err != nil {
+		return 0, err
+	}
+	toIDs, err := ids(to)
+	if err != nil {
+		return 0, err
+	}
+
+	var missing []int64
+	for i := 0; i < len(toIDs); i++ {
+		for len(fromIDs) > 0 && fromIDs[0] < toIDs[i] {
+			fromIDs = fromIDs[1:]
+		}
+		if len(fromIDs) == 0 || fromIDs[0] != toIDs[i] {
+			missing = append(missing, toIDs[i])
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+
+	tx, err := to.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+	for _, id := range missing {
+		if err := to.deleteActivity(tx, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(missing), tx.Commit()
+}
+
+// Insert or replace a batch of activities, and save the progress with them
+func copyBatch(to *DB, source string, batch []ActivityRecord, progress copyProgress) error {
+	tx, err := to.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	assignments := make([]string, len(activityFields))
+	for i, field := range activityFields {
+		assignments[i] = fmt.Sprintf("%s = excluded.%s", field, field)
+	}
+	stmt, err := tx.Prepare(to.rebind(fmt.Sprintf("INSERT INTO activities (%s) VALUES (%s) ON CONFLICT (id) DO UPDATE SET %s",
+		activityColumns, placeholders(len(activityFields)+1), strings.Join(assignments, ", "))))
+	if err != nil {
+		return fmt.Errorf("error preparing insert: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, record := range batch {
+		values, err := to.activityValues(record)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(append([]any{record.ID}, values...)...); err != nil {
+			return fmt.Errorf("error copying activity %d: %v", record.ID, err)
+		}
+		if err := to.saveLabels(tx, record.ID, record); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(to.rebind(`
+		INSERT INTO copy_progress (source, last_id, copied_at) VALUES (?, ?, ?)
+		ON CONFLICT (source) DO UPDATE SET last_id = excluded.last_id, copied_at = excluded.copied_at`),
+		source, progress.lastID, progress.copiedAt)
+	if err != nil {
+		return fmt.Errorf("error saving copy progress: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// Check that both databases have the same number of activities and the same total
+// duration, up to the last copied id
+func verifyCopy(from, to *DB, lastID int64) error {
+	fromCount, fromSeconds, err := activityTotals(from, lastID)
+	if err != nil {
+		return err
+	}
+	toCount, toSeconds, err := activityTotals(to, lastID)
+	if err != nil {
+		return err
+	}
+
+	if fromCount != toCount {
+		return fmt.Errorf("verification failed: source has %d activities, destination has %d", fromCount, toCount)
+	}
+	if math.Abs(fromSeconds-toSeconds) >= 1 {
+		return fmt.Errorf("verification failed: source has %.0fs of activity, destination has %.0fs", fromSeconds, toSeconds)
+	}
+	fmt.Printf("Copied and verified %d activities (%s)\n", toCount,
+		formatTime(secondsDuration(toSeconds)))
+	return nil
+}
+
+func activityTotals(db *DB, lastID int64) (int64, float64, error) {
+	durationSQL := "(JULIANDAY(end_time) - JULIANDAY(start_time)) * 86400"
+	if db.dbType == "postgres" {
+		durationSQL = "EXTRACT(EPOCH FROM (end_time - start_time))"
+	}
+
+	var count int64
+	var seconds sql.NullFloat64
+	err := db.QueryRow(db.rebind(`
+		SELECT COUNT(*), SUM(`+durationSQL+`)
+		FROM activities
+		WHERE id <= ?
+	`), lastID).Scan(&count, &seconds)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error totalling activities: %v", err)
+	}
+	return count, seconds.Float64, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDatabaseArg(t *testing.T) {
+	configured := DatabaseConfig{Type: "sqlite", SqlitePath: "/data/tracker.db", PostgresConnStr: "postgres://localhost/activitymon"}
+	for _, test := range []struct {
+		arg  string
+		want DatabaseConfig
+	}{
+		{"sqlite", configured},
+		{"postgres", DatabaseConfig{Type: "postgres", SqlitePath: "/data/tracker.db", PostgresConnStr: "postgres://localhost/activitymon"}},
+		{"postgresql://db.example.com/tracker", DatabaseConfig{Type: "postgres", SqlitePath: "/data/tracker.db", PostgresConnStr: "postgresql://db.example.com/tracker"}},
+		{"host=/var/run/postgresql dbname=tracker", DatabaseConfig{Type: "postgres", SqlitePath: "/data/tracker.db", PostgresConnStr: "host=/var/run/postgresql dbname=tracker"}},
+		{"backup.db", DatabaseConfig{Type: "sqlite", SqlitePath: "backup.db", PostgresConnStr: "postgres://localhost/activitymon"}},
+		{"/tmp/a=b.db", DatabaseConfig{Type: "sqlite", SqlitePath: "/tmp/a=b.db", PostgresConnStr: "postgres://localhost/activitymon"}},
+	} {
+		if got := databaseArg(configured, test.arg); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %+v, want %+v", test.arg, got, test.want)
+		}
+	}
+}
+
+// Copying again brings over activities added, changed and deleted since the last copy
+func TestCopyActivitiesAgain(t *testing.T) {
+	from, to := openTestDb(t), openTestDb(t)
+	source, err := databaseIdentity(DatabaseConfig{Type: "sqlite", SqlitePath: filepath.Join(t.TempDir(), "tracker.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	insertTestActivities(t, from)
+	if err := copyActivities(from, to, source, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := getActivities(from, time.Unix(0, 0), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// stored_at has a resolution of a second
+	time.Sleep(time.Second)
+	changed := records[0]
+	changed.Name, changed.Tags = "Renamed", []string{"renamed"}
+	if err := from.updateActivity(from, changed); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.deleteActivity(from, records[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	end := records[3].EndTime.Add(time.Hour)
+	if _, err := from.insertActivity(from, ActivityRecord{StartTime: *records[3].EndTime, EndTime: &end, Name: "Slack"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyActivities(from, to, source, 2); err != nil {
+		t.Fatal(err)
+	}
+	want, err := getActivities(from, time.Unix(0, 0), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := getActivities(to, time.Unix(0, 0), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%+v\nwant\n%+v", got, want)
+	}
+}
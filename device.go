@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The machine activities are recorded on
+type Device struct {
+	ID       string
+	Hostname string
+}
+
+var (
+	deviceOnce sync.Once
+	device     Device
+	deviceErr  error
+)
+
+// Get this machine's device, creating a stable random device id the first time
+func getDevice() (Device, error) {
+	deviceOnce.Do(func() {
+		device, deviceErr = loadDevice()
+	})
+	return device, deviceErr
+}
+
+func loadDevice() (Device, error) {
+	configDir, err := getConfigDir()
+	if err != nil {
+		return Device{}, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	idPath := filepath.Join(configDir, "device-id")
+	data, err := os.ReadFile(idPath)
+	if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		return Device{ID: strings.TrimSpace(string(data)), Hostname: hostname}, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return Device{}, fmt.Errorf("unable to read device id: %v", err)
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return Device{}, fmt.Errorf("unable to generate device id: %v", err)
+	}
+	id := hex.EncodeToString(buf)
+	if err := os.WriteFile(idPath, []byte(id+"\n"), 0644); err != nil {
+		return Device{}, fmt.Errorf("unable to save device id: %v", err)
+	}
+	return Device{ID: id, Hostname: hostname}, nil
+}
+
+// Get a readable name for a device, preferring its hostname
+func deviceName(id, hostname string) string {
+	switch {
+	case hostname != "":
+		return hostname
+	case id != "":
+		return id
+	default:
+		return "unknown device"
+	}
+}
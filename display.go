@@ -6,6 +6,15 @@ import (
 	"github.com/rivo/tview"
 )
 
+// Display shows the monitor's activity log and live statistics
+type Display interface {
+	Start() error
+	Stop()
+	AddLogEntry(entry string)
+	UpdateStats(stats string)
+}
+
+// Monitor is the interactive terminal UI
 type Monitor struct {
 	app        *tview.Application
 	headerView *tview.TextView
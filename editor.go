@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// A report from an editor plugin, sent to the control socket as an "editor" request:
+//
+//	{"command": "editor", "editor": {"event": "focus", "editor": "nvim", "pid": 4242,
+//	 "file": "/src/app/main.go", "language": "go", "project": "app"}}
+//
+// Plugins send "focus" when the editor gains focus or switches files, and "blur" when
+// it loses focus or exits.
+type editorReport struct {
+	Event    string `json:"event"`
+	Editor   string `json:"editor"`             // e.g. "nvim" or "vscode"
+	PID      int    `json:"pid,omitempty"`      // the editor's process, so reports from exited editors are dropped
+	App      string `json:"app,omitempty"`      // the app the editor runs in (default: the app being tracked)
+	File     string `json:"file,omitempty"`     // absolute path of the file being edited
+	Language string `json:"language,omitempty"` // e.g. "go", as the editor names it
+	Project  string `json:"project,omitempty"`  // overrides the git project detected for the window
+}
+
+var editorEvents = []string{"focus", "blur"}
+
+// The latest report of the editor that has focus, as seen by the monitor
+type editorTracker struct {
+	current *editorReport
+	// whether the editor runs under each app process it's been matched against
+	runsUnder map[int]bool
+}
+
+// Handle a report. Reports are matched to the frontmost app as it's polled, by the
+// app they name, or else by whether the editor runs under the app's process. Reports
+// with neither are taken to be from the app being tracked, since the editor has just
+// been focused.
+func (t *editorTracker) report(r editorReport, trackedApp string) error {
+	if !slices.Contains(editorEvents, r.Event) {
+		return fmt.Errorf("unsupported editor event: %q (expected focus or blur)", r.Event)
+	}
+	if r.Editor == "" {
+		return fmt.Errorf("missing editor name")
+	}
+
+	if r.Event == "blur" {
+		// another instance may have taken focus since
+		if t.current != nil && t.current.Editor == r.Editor && t.current.PID == r.PID {
+			t.current = nil
+		}
+		return nil
+	}
+	if r.App == "" && r.PID == 0 {
+		if trackedApp == "" {
+			return fmt.Errorf("no app is being tracked")
+		}
+		r.App = trackedApp
+	}
+	t.current, t.runsUnder = &r, nil
+	return nil
+}
+
+// Get the focused editor's report if it's running in the app, whose process id is
+// pid, or 0 if it's not known; nil otherwise
+func (t *editorTracker) get(app string, pid int) *editorReport {
+	if t.current == nil {
+		return nil
+	}
+	if t.current.PID != 0 && !processExists(t.current.PID) {
+		t.current = nil
+		return nil
+	}
+	if t.current.App != "" {
+		if t.current.App != app {
+			return nil
+		}
+		return t.current
+	}
+
+	if pid == 0 {
+		return nil
+	}
+	runsUnder, ok := t.runsUnder[pid]
+	if !ok {
+		runsUnder = runsUnderProcess(t.current.PID, pid)
+		if t.runsUnder == nil {
+			t.runsUnder = make(map[int]bool)
+		}
+		t.runsUnder[pid] = runsUnder
+	}
+	if !runsUnder {
+		return nil
+	}
+	return t.current
+}
+
+// Check whether a process is the given ancestor or one of its descendants
+func runsUnderProcess(pid, ancestor int) bool {
+	processes, err := listProcesses()
+	if err != nil {
+		return false
+	}
+	parents := make(map[int]int, len(processes))
+	for _, p := range processes {
+		parents[p.PID] = p.PPID
+	}
+	// bounded, in case the list changed while it was read
+	for range processes {
+		if pid == ancestor {
+			return true
+		}
+		if pid <= 1 {
+			return false
+		}
+		pid = parents[pid]
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// Reports without an app belong to the app the editor's process runs under
+func TestEditorTrackerMatchesByProcess(t *testing.T) {
+	other := exec.Command("sleep", "10")
+	if err := other.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer other.Process.Kill()
+
+	var tracker editorTracker
+	if err := tracker.report(editorReport{Event: "focus", Editor: "nvim", PID: os.Getpid(), File: "/src/main.go"}, "Slack"); err != nil {
+		t.Fatal(err)
+	}
+	if editor := tracker.get("Terminal", os.Getppid()); editor == nil || editor.File != "/src/main.go" {
+		t.Errorf("got %+v, want the report for the app the editor runs under", editor)
+	}
+	if editor := tracker.get("Slack", other.Process.Pid); editor != nil {
+		t.Errorf("got %+v, want no report for another app", editor)
+	}
+	if editor := tracker.get("Terminal", 0); editor != nil {
+		t.Errorf("got %+v, want no report when the app's process isn't known", editor)
+	}
+}
@@ -0,0 +1,95 @@
+-- Reports the file being edited to the activitymon monitor, which attaches it to
+-- the activity of the terminal or GUI that Neovim runs in.
+--
+--   require("activitymon").setup({ socket = "~/Library/Preferences/activitymon/tracker.db.sock" })
+--
+-- Reports go over the monitor's control socket, one JSON request per connection.
+-- Nothing is reported for buffers that aren't files, and nothing happens if the
+-- monitor isn't running.
+
+local uv = vim.uv or vim.loop
+
+local M = {}
+
+local config = {
+  -- the monitor's control socket, next to its database
+  socket = "~/Library/Preferences/activitymon/tracker.db.sock",
+  -- the app Neovim runs in, as the monitor names it; by default the frontmost app
+  -- when a report arrives, which is right unless reports are delayed
+  app = nil,
+}
+
+local last -- the last report sent, so unchanged ones are skipped
+
+local function send(report)
+  local key = vim.json.encode(report)
+  if key == last then
+    return
+  end
+  last = key
+
+  local pipe = uv.new_pipe(false)
+  local request = vim.json.encode({ command = "editor", editor = report }) .. "\n"
+  pipe:connect(vim.fn.expand(config.socket), function(err)
+    if err then
+      pipe:close()
+      return
+    end
+    pipe:write(request)
+    -- wait for the response, so the monitor has handled the report before the
+    -- next one is sent
+    pipe:read_start(function(read_err, data)
+      if read_err or not data then
+        pipe:close()
+      end
+    end)
+  end)
+end
+
+local function project_of(path)
+  local git = vim.fs.find(".git", { upward = true, path = vim.fs.dirname(path) })[1]
+  return git and vim.fs.basename(vim.fs.dirname(git)) or nil
+end
+
+local function focus()
+  local buf = vim.api.nvim_get_current_buf()
+  local path = vim.api.nvim_buf_get_name(buf)
+  if vim.bo[buf].buftype ~= "" or path == "" then
+    return
+  end
+  local language = vim.bo[buf].filetype
+  send({
+    event = "focus",
+    editor = "nvim",
+    pid = vim.fn.getpid(),
+    app = config.app,
+    file = path,
+    language = language ~= "" and language or nil,
+    project = project_of(path),
+  })
+end
+
+local function blur()
+  send({ event = "blur", editor = "nvim", pid = vim.fn.getpid() })
+end
+
+function M.setup(opts)
+  config = vim.tbl_extend("force", config, opts or {})
+
+  local group = vim.api.nvim_create_augroup("activitymon", { clear = true })
+  vim.api.nvim_create_autocmd({ "BufEnter", "FocusGained", "FileType" }, { group = group, callback = focus })
+  vim.api.nvim_create_autocmd("FocusLost", { group = group, callback = blur })
+  vim.api.nvim_create_autocmd("VimLeavePre", {
+    group = group,
+    callback = function()
+      blur()
+      -- give the report a moment to go out before exiting
+      vim.wait(100, function()
+        return false
+      end)
+    end,
+  })
+  focus()
+end
+
+return M
@@ -0,0 +1,718 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+func (db *DB) setupEdits() error {
+	idColumn := "INTEGER PRIMARY KEY AUTOINCREMENT"
+	if db.dbType == "postgres" {
+		idColumn = "SERIAL PRIMARY KEY"
+	}
+	for _, createTable := range []string{`
+		CREATE TABLE IF NOT EXISTS activity_edits (
+			id ` + idColumn + `,
+			edited_at TIMESTAMP NOT NULL,
+			command TEXT NOT NULL,
+			undone_at TIMESTAMP
+		)`, `
+		CREATE TABLE IF NOT EXISTS activity_edit_changes (
+			id ` + idColumn + `,
+			edit_id BIGINT NOT NULL,
+			activity_id BIGINT NOT NULL,
+			before_json TEXT,
+			after_json TEXT
+		)`,
+	} {
+		if _, err := db.Exec(createTable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Changes activities on behalf of a command, recording each change in the audit log
+// so the command can be undone. The edit is logged with its first change, and its
+// changes are made in a single transaction, so they're either all made or none are.
+type activityEditor struct {
+	db      *DB
+	tx      *sql.Tx
+	command string
+	now     time.Time
+	editID  int64
+}
+
+func beginEdit(db *DB, command string) (*activityEditor, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	return &activityEditor{db: db, tx: tx, command: command, now: time.Now()}, nil
+}
+
+func (e *activityEditor) commit() error {
+	if err := e.tx.Commit(); err != nil {
+		return fmt.Errorf("error committing edit: %v", err)
+	}
+	return nil
+}
+
+// Discard the changes, unless they've been committed
+func (e *activityEditor) rollback() {
+	e.tx.Rollback()
+}
+
+// Get the activities that overlap the given time range, as they are in the edit
+func (e *activityEditor) activities(since, until time.Time) ([]ActivityRecord, error) {
+	return e.db.queryStoredActivities(e.tx, since, until)
+}
+
+// Get the activities with the given ids, as they are in the edit
+func (e *activityEditor) activitiesByIDs(args []string) ([]ActivityRecord, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("expected activity ids")
+	}
+	var records []ActivityRecord
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid activity id: %s", arg)
+		}
+		record, err := e.db.queryActivityByID(e.tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			return nil, fmt.Errorf("no activity with id %d", id)
+		}
+		records = append(records, *record)
+	}
+	return records, nil
+}
+
+func (e *activityEditor) insert(record ActivityRecord) (int64, error) {
+	record.UpdatedAt = &e.now
+	id, err := e.db.insertActivity(e.tx, record)
+	if err != nil {
+		return 0, fmt.Errorf("error inserting activity: %v", err)
+	}
+	record.ID = id
+	return id, e.record(id, nil, &record)
+}
+
+func (e *activityEditor) update(before, after ActivityRecord) error {
+	after.UpdatedAt = &e.now
+	if err := e.db.updateActivity(e.tx, after); err != nil {
+		return err
+	}
+	return e.record(after.ID, &before, &after)
+}
+
+func (e *activityEditor) delete(record ActivityRecord) error {
+	if err := e.db.deleteActivity(e.tx, record.ID); err != nil {
+		return err
+	}
+	return e.record(record.ID, &record, nil)
+}
+
+// Log a change to an activity; before is nil for inserts, and after for deletes.
+// Titles and URLs are logged as they're stored, encrypted if encryption is on.
+func (e *activityEditor) record(activityID int64, before, after *ActivityRecord) error {
+	if e.editID == 0 {
+		id, err := e.db.insertReturningID(e.tx, `INSERT INTO activity_edits (edited_at, command) VALUES (?, ?)`,
+			dbTime(e.now), e.command)
+		if err != nil {
+			return fmt.Errorf("error logging edit: %v", err)
+		}
+		e.editID = id
+	}
+
+	snapshot := func(record *ActivityRecord) (any, error) {
+		if record == nil {
+			return nil, nil
+		}
+		stored := *record
+		if err := e.db.encryptActivity(&stored); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(stored)
+		return string(data), err
+	}
+	beforeJSON, err := snapshot(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := snapshot(after)
+	if err != nil {
+		return err
+	}
+	if _, err := e.tx.Exec(e.db.rebind(`
+		INSERT INTO activity_edit_changes (edit_id, activity_id, before_json, after_json) VALUES (?, ?, ?, ?)
+	`), e.editID, activityID, beforeJSON, afterJSON); err != nil {
+		return fmt.Errorf("error logging edit: %v", err)
+	}
+	return nil
+}
+
+// Set the projects and tags of an activity, leaving the rest of it alone, so an
+// activity the monitor is still tracking can be labeled
+func (e *activityEditor) label(record ActivityRecord, projects, tags []string) error {
+	labeled := record
+	labeled.Projects, labeled.Tags, labeled.UpdatedAt = projects, tags, &e.now
+	if sameLabels(record, labeled) {
+		return nil
+	}
+	if _, err := e.tx.Exec(e.db.rebind(`UPDATE activities SET updated_at = ?, stored_at = ? WHERE id = ?`),
+		dbTime(e.now), dbTime(time.Now()), record.ID); err != nil {
+		return fmt.Errorf("error updating activity %d: %v", record.ID, err)
+	}
+	if err := e.db.saveLabels(e.tx, record.ID, labeled); err != nil {
+		return err
+	}
+	return e.record(record.ID, &record, &labeled)
+}
+
+// Remove the part of an activity between start and end: delete it if it's covered,
+// trim it if it overlaps one end, or split it if it covers the whole range. When
+// splitting, the activity keeps its id for the later part, so an ongoing activity
+// stays the monitor's current one.
+func (e *activityEditor) cut(record ActivityRecord, start, end time.Time) error {
+	recordEnd := record.endOr(e.now)
+	updated := record
+	switch {
+	case !record.StartTime.Before(start) && !recordEnd.After(end):
+		return e.delete(record)
+
+	case record.StartTime.Before(start) && recordEnd.After(end):
+		before := record
+		before.ID, before.OriginID, before.EndTime = 0, 0, &start
+		if _, err := e.insert(before); err != nil {
+			return err
+		}
+		updated.StartTime = end
+
+	case record.StartTime.Before(start):
+		updated.EndTime = &start
+
+	default:
+		updated.StartTime = end
+	}
+	return e.update(record, updated)
+}
+
+type activityEdit struct {
+	ID       int64
+	EditedAt time.Time
+	Command  string
+	UndoneAt *time.Time
+	Changes  int
+}
+
+func getEdits(db *DB, limit int) ([]activityEdit, error) {
+	rows, err := db.Query(db.rebind(`
+		SELECT e.id, e.edited_at, e.command, e.undone_at, COUNT(c.id)
+		FROM activity_edits e
+		LEFT JOIN activity_edit_changes c ON c.edit_id = e.id
+		GROUP BY e.id, e.edited_at, e.command, e.undone_at
+		ORDER BY e.id DESC
+		LIMIT ?
+	`), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	var edits []activityEdit
+	for rows.Next() {
+		var edit activityEdit
+		var undoneAt sql.NullTime
+		if err := rows.Scan(&edit.ID, &edit.EditedAt, &edit.Command, &undoneAt, &edit.Changes); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		edit.EditedAt = localTime(edit.EditedAt)
+		if undoneAt.Valid {
+			t := localTime(undoneAt.Time)
+			edit.UndoneAt = &t
+		}
+		edits = append(edits, edit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rows: %v", err)
+	}
+	return edits, nil
+}
+
+type activityChange struct {
+	activityID    int64
+	before, after *ActivityRecord
+}
+
+// Undo an edit, or the latest edit that hasn't been undone if editID is 0, in a single
+// transaction. Nothing is changed if any of the edited activities has changed since.
+func undoEdit(db *DB, editID int64) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `SELECT id FROM activity_edits WHERE undone_at IS NULL ORDER BY id DESC LIMIT 1`
+	var args []any
+	if editID != 0 {
+		query = `SELECT id FROM activity_edits WHERE undone_at IS NULL AND id = ?`
+		args = append(args, editID)
+	}
+	if err := tx.QueryRow(db.rebind(query), args...).Scan(&editID); err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no edit to undo")
+	} else if err != nil {
+		return 0, fmt.Errorf("error querying database: %v", err)
+	}
+
+	changes, err := getEditChanges(db, tx, editID)
+	if err != nil {
+		return 0, err
+	}
+	for _, change := range changes {
+		current, err := db.queryActivityByID(tx, change.activityID)
+		if err != nil {
+			return 0, err
+		}
+		unchanged := (change.after == nil && current == nil) ||
+			(change.after != nil && current != nil && sameActivity(*current, *change.after))
+		if !unchanged {
+			return 0, fmt.Errorf("activity %d has changed since edit %d, so it can't be undone", change.activityID, editID)
+		}
+	}
+
+	// undo the changes in reverse
+	now := time.Now()
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		switch {
+		case change.before == nil:
+			err = db.deleteActivity(tx, change.activityID)
+		case change.after == nil:
+			change.before.UpdatedAt = &now
+			_, err = db.insertActivity(tx, *change.before)
+		default:
+			change.before.UpdatedAt = &now
+			err = db.updateActivity(tx, *change.before)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := tx.Exec(db.rebind(`UPDATE activity_edits SET undone_at = ? WHERE id = ?`), dbTime(now), editID); err != nil {
+		return 0, fmt.Errorf("error marking edit %d as undone: %v", editID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing undo: %v", err)
+	}
+	return editID, nil
+}
+
+func getEditChanges(db *DB, q querier, editID int64) ([]activityChange, error) {
+	rows, err := q.Query(db.rebind(`
+		SELECT activity_id, before_json, after_json FROM activity_edit_changes WHERE edit_id = ? ORDER BY id
+	`), editID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	var changes []activityChange
+	for rows.Next() {
+		var change activityChange
+		var beforeJSON, afterJSON sql.NullString
+		if err := rows.Scan(&change.activityID, &beforeJSON, &afterJSON); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		for _, field := range []struct {
+			data   sql.NullString
+			record **ActivityRecord
+		}{{beforeJSON, &change.before}, {afterJSON, &change.after}} {
+			if !field.data.Valid {
+				continue
+			}
+			records := make([]ActivityRecord, 1)
+			if err := json.Unmarshal([]byte(field.data.String), &records[0]); err != nil {
+				return nil, fmt.Errorf("error parsing edit %d: %v", editID, err)
+			}
+			if err := db.decryptActivities(records); err != nil {
+				return nil, err
+			}
+			*field.record = &records[0]
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rows: %v", err)
+	}
+	return changes, nil
+}
+
+func editCmd() *cli.Command {
+	withDb := func(action func(c *cli.Context, db *DB) error) cli.ActionFunc {
+		return func(c *cli.Context) error {
+			if err := checkTrailingFlags(c); err != nil {
+				return err
+			}
+			db, err := getDb()
+			if err != nil {
+				return fmt.Errorf("error connecting to database: %v", err)
+			}
+			defer db.Close()
+			return action(c, db)
+		}
+	}
+	// Make a command's changes as one edit, and print what it did once it's committed
+	withEdit := func(action func(c *cli.Context, editor *activityEditor) (string, error)) cli.ActionFunc {
+		return withDb(func(c *cli.Context, db *DB) error {
+			editor, err := beginEdit(db, strings.Join(os.Args[1:], " "))
+			if err != nil {
+				return err
+			}
+			defer editor.rollback()
+			message, err := action(c, editor)
+			if err != nil {
+				return err
+			}
+			if err := editor.commit(); err != nil {
+				return err
+			}
+			fmt.Println(message)
+			return nil
+		})
+	}
+
+	return &cli.Command{
+		Name:  "edit",
+		Usage: "Fix recorded activities; every change can be undone",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "List activities with their ids",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "since", Usage: "List activities after this time (default: today)"},
+					&cli.StringFlag{Name: "until", Usage: "List activities before this time (default: now)"},
+				},
+				Action: withDb(func(c *cli.Context, db *DB) error {
+					now := time.Now()
+					since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+					until := now
+					var err error
+					if value := c.String("since"); value != "" {
+						if since, err = parseTimeParam(value, now); err != nil {
+							return fmt.Errorf("invalid since: %v", err)
+						}
+					}
+					if value := c.String("until"); value != "" {
+						if until, err = parseTimeParam(value, now); err != nil {
+							return fmt.Errorf("invalid until: %v", err)
+						}
+					}
+					records, err := getStoredActivities(db, since, until)
+					if err != nil {
+						return err
+					}
+					fmt.Print(formatActivityList(records, now))
+					return nil
+				}),
+			},
+			{
+				Name:      "delete",
+				Usage:     "Delete activities",
+				ArgsUsage: "<id>...",
+				Action: withEdit(func(c *cli.Context, editor *activityEditor) (string, error) {
+					records, err := editor.activitiesByIDs(c.Args().Slice())
+					if err != nil {
+						return "", err
+					}
+					for _, record := range records {
+						if err := editor.delete(record); err != nil {
+							return "", err
+						}
+					}
+					return fmt.Sprintf("Deleted %d activities (edit %d)", len(records), editor.editID), nil
+				}),
+			},
+			{
+				Name:  "cut",
+				Usage: "Remove a time range from this machine's activities, e.g. time away that was tracked",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "from", Usage: "Start of the range", Required: true},
+					&cli.StringFlag{Name: "to", Usage: "End of the range", Required: true},
+				},
+				Action: withEdit(func(c *cli.Context, editor *activityEditor) (string, error) {
+					start, end, err := parseEditRange(c.String("from"), c.String("to"))
+					if err != nil {
+						return "", err
+					}
+					device, err := getDevice()
+					if err != nil {
+						return "", err
+					}
+					records, err := editor.activities(start, end)
+					if err != nil {
+						return "", err
+					}
+					for _, record := range records {
+						if record.DeviceID != device.ID && record.DeviceID != "" {
+							continue
+						}
+						if err := editor.cut(record, start, end); err != nil {
+							return "", err
+						}
+					}
+					if editor.editID == 0 {
+						return "Nothing was tracked in that range", nil
+					}
+					return fmt.Sprintf("Removed %s to %s (edit %d)", start.Format("2006-01-02 15:04"), end.Format("15:04"), editor.editID), nil
+				}),
+			},
+			{
+				Name:      "trim",
+				Usage:     "Change when an activity starts or ends",
+				ArgsUsage: "<id>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "start", Usage: "New start time"},
+					&cli.StringFlag{Name: "end", Usage: "New end time"},
+				},
+				Action: withEdit(func(c *cli.Context, editor *activityEditor) (string, error) {
+					records, err := editor.activitiesByIDs(c.Args().Slice())
+					if err != nil {
+						return "", err
+					}
+					if len(records) != 1 {
+						return "", fmt.Errorf("expected one activity id")
+					}
+					record := records[0]
+					updated := record
+					now := time.Now()
+					if value := c.String("start"); value != "" {
+						if updated.StartTime, err = parseTimeParam(value, now); err != nil {
+							return "", fmt.Errorf("invalid start: %v", err)
+						}
+					}
+					if value := c.String("end"); value != "" {
+						end, err := parseTimeParam(value, now)
+						if err != nil {
+							return "", fmt.Errorf("invalid end: %v", err)
+						}
+						updated.EndTime = &end
+					}
+					if !updated.endOr(now).After(updated.StartTime) || updated.endOr(now).After(now) {
+						return "", fmt.Errorf("the activity must end after it starts, and not in the future")
+					}
+					if err := editor.update(record, updated); err != nil {
+						return "", err
+					}
+					return fmt.Sprintf("Trimmed activity %d (edit %d)", record.ID, editor.editID), nil
+				}),
+			},
+			{
+				Name:      "split",
+				Usage:     "Split an activity in two at a time",
+				ArgsUsage: "<id>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "at", Usage: "Time to split at", Required: true},
+				},
+				Action: withEdit(func(c *cli.Context, editor *activityEditor) (string, error) {
+					records, err := editor.activitiesByIDs(c.Args().Slice())
+					if err != nil {
+						return "", err
+					}
+					if len(records) != 1 {
+						return "", fmt.Errorf("expected one activity id")
+					}
+					record := records[0]
+					now := time.Now()
+					at, err := parseTimeParam(c.String("at"), now)
+					if err != nil {
+						return "", fmt.Errorf("invalid at: %v", err)
+					}
+					if !at.After(record.StartTime) || !at.Before(record.endOr(now)) {
+						return "", fmt.Errorf("%s is not during activity %d", at.Format(dbTimeFormat), record.ID)
+					}
+
+					// the activity keeps its id for the later part, in case it's ongoing
+					before := record
+					before.ID, before.OriginID, before.EndTime = 0, 0, &at
+					id, err := editor.insert(before)
+					if err != nil {
+						return "", err
+					}
+					after := record
+					after.StartTime = at
+					if err := editor.update(record, after); err != nil {
+						return "", err
+					}
+					return fmt.Sprintf("Split activity %d into %d and %d (edit %d)", record.ID, id, record.ID, editor.editID), nil
+				}),
+			},
+			{
+				Name:      "merge",
+				Usage:     "Merge consecutive activities into the first one",
+				ArgsUsage: "<id> <id>...",
+				Action: withEdit(func(c *cli.Context, editor *activityEditor) (string, error) {
+					records, err := editor.activitiesByIDs(c.Args().Slice())
+					if err != nil {
+						return "", err
+					}
+					if len(records) < 2 {
+						return "", fmt.Errorf("expected at least two activity ids")
+					}
+					sort.Slice(records, func(i, j int) bool { return records[i].StartTime.Before(records[j].StartTime) })
+					first, last := records[0], records[len(records)-1]
+
+					// only the activities being merged may be recorded between them
+					between, err := editor.activities(first.StartTime, last.endOr(time.Now()))
+					if err != nil {
+						return "", err
+					}
+					merging := make(map[int64]bool)
+					for _, record := range records {
+						if record.DeviceID != first.DeviceID {
+							return "", fmt.Errorf("activities %d and %d were recorded on different devices", first.ID, record.ID)
+						}
+						merging[record.ID] = true
+					}
+					for _, other := range between {
+						if !merging[other.ID] && other.DeviceID == first.DeviceID {
+							return "", fmt.Errorf("activity %d is between the activities to merge", other.ID)
+						}
+					}
+
+					merged := first
+					merged.EndTime = last.EndTime
+					for _, record := range records[1:] {
+						if err := editor.delete(record); err != nil {
+							return "", err
+						}
+					}
+					if err := editor.update(first, merged); err != nil {
+						return "", err
+					}
+					return fmt.Sprintf("Merged %d activities into %d (edit %d)", len(records), first.ID, editor.editID), nil
+				}),
+			},
+			{
+				Name:      "relabel",
+				Usage:     "Rename activities",
+				ArgsUsage: "<id>...",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "name", Usage: "New activity name", Required: true},
+				},
+				Action: withEdit(func(c *cli.Context, editor *activityEditor) (string, error) {
+					records, err := editor.activitiesByIDs(c.Args().Slice())
+					if err != nil {
+						return "", err
+					}
+					for _, record := range records {
+						relabeled := record
+						relabeled.Name = c.String("name")
+						if err := editor.update(record, relabeled); err != nil {
+							return "", err
+						}
+					}
+					return fmt.Sprintf("Relabeled %d activities as %s (edit %d)", len(records), c.String("name"), editor.editID), nil
+				}),
+			},
+			{
+				Name:  "history",
+				Usage: "List recent edits",
+				Flags: []cli.Flag{
+					&cli.IntFlag{Name: "limit", Usage: "Number of edits to list", Value: 20},
+				},
+				Action: withDb(func(c *cli.Context, db *DB) error {
+					edits, err := getEdits(db, c.Int("limit"))
+					if err != nil {
+						return err
+					}
+					for _, edit := range edits {
+						status := fmt.Sprintf("%d changes", edit.Changes)
+						if edit.Changes == 1 {
+							status = "1 change"
+						}
+						if edit.UndoneAt != nil {
+							status += " (undone)"
+						}
+						fmt.Printf("%6d  %s  %-60s %s\n", edit.ID, edit.EditedAt.Format("2006-01-02 15:04:05"),
+							truncateString(edit.Command, 60), status)
+					}
+					return nil
+				}),
+			},
+			{
+				Name:      "undo",
+				Usage:     "Undo the latest edit, or the given one",
+				ArgsUsage: "[edit-id]",
+				Action: withDb(func(c *cli.Context, db *DB) error {
+					var editID int64
+					if c.NArg() > 0 {
+						var err error
+						if editID, err = strconv.ParseInt(c.Args().First(), 10, 64); err != nil {
+							return fmt.Errorf("invalid edit id: %s", c.Args().First())
+						}
+					}
+					undone, err := undoEdit(db, editID)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("Undid edit %d\n", undone)
+					return nil
+				}),
+			},
+		},
+	}
+}
+
+func parseEditRange(from, to string) (time.Time, time.Time, error) {
+	now := time.Now()
+	start, err := parseTimeParam(from, now)
+	if err != nil {
+		return start, start, fmt.Errorf("invalid from: %v", err)
+	}
+	end, err := parseTimeParam(to, now)
+	if err != nil {
+		return start, end, fmt.Errorf("invalid to: %v", err)
+	}
+	if !end.After(start) {
+		return start, end, fmt.Errorf("the range must end after it starts")
+	}
+	return start, end, nil
+}
+
+func formatActivityList(records []ActivityRecord, now time.Time) string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%6s  %-19s  %-8s  %11s  %s\n", "ID", "Start", "End", "Duration", "Activity")
+	for _, record := range records {
+		end := "ongoing"
+		if record.EndTime != nil {
+			end = record.EndTime.Format("15:04:05")
+		}
+		name := record.Name
+		if record.App != "" && record.App != record.Name {
+			name += " (" + record.App + ")"
+		}
+		if record.Source != "" {
+			name += " [" + record.Source + "]"
+		}
+		if labels := formatLabels(record); labels != "" {
+			name += " " + labels
+		}
+		fmt.Fprintf(&buf, "%6d  %s  %-8s  %11s  %s\n", record.ID, record.StartTime.Format("2006-01-02 15:04:05"),
+			end, formatTime(record.endOr(now).Sub(record.StartTime)), name)
+	}
+	return buf.String()
+}
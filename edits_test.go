@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// An edit that fails part way changes nothing
+func TestEditIsAtomic(t *testing.T) {
+	db := openTestDb(t)
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour)
+	if _, err := db.insertActivity(db, ActivityRecord{StartTime: start, EndTime: &end, Name: "Code"}); err != nil {
+		t.Fatal(err)
+	}
+	records := mustGetActivities(t, db, start, end)
+
+	editor, err := beginEdit(db, "edit merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := editor.delete(records[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := editor.activitiesByIDs([]string{"1000"}); err == nil {
+		t.Fatal("got no error for a missing activity")
+	}
+	editor.rollback()
+
+	if got := mustGetActivities(t, db, start, end); len(got) != 1 {
+		t.Errorf("got %+v, want the activity kept", got)
+	}
+	if edits, err := getEdits(db, 10); err != nil || len(edits) != 0 {
+		t.Errorf("got %+v, %v, want nothing logged", edits, err)
+	}
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// Encrypted values are stored as enc:v1:<key id>:<base64 nonce and ciphertext>
+	encryptedPrefix = "enc:v1:"
+
+	// Plaintext starting with reservedPrefix is stored behind escapedPrefix, so it's
+	// never taken for an encrypted value
+	reservedPrefix = "enc:"
+	escapedPrefix  = "enc:plain:"
+
+	keyringService = "activitymon"
+	keyringAccount = "database-key"
+)
+
+// Encrypts and decrypts the sensitive activity columns (window titles, URLs and files) with
+// AES-256-GCM. The first key encrypts; the others are only kept to decrypt values
+// written before a rekey finished.
+type fieldCipher struct {
+	keyID string
+	aead  map[string]cipher.AEAD // by key id
+}
+
+func newFieldCipher(keys [][]byte) (*fieldCipher, error) {
+	c := &fieldCipher{aead: make(map[string]cipher.AEAD)}
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %v", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %v", err)
+		}
+		id := keyID(key)
+		if i == 0 {
+			c.keyID = id
+		}
+		c.aead[id] = aead
+	}
+	return c, nil
+}
+
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// Encrypt a column's value. The column is authenticated along with it, so values
+// can't be moved between columns. Empty values are left empty.
+func (c *fieldCipher) encrypt(column, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	aead := c.aead[c.keyID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("unable to generate nonce: %v", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(column))
+	return encryptedPrefix + c.keyID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt a column's value. A value with a known key that doesn't authenticate
+// isn't something this wrote, so it's returned as it is.
+func (c *fieldCipher) decrypt(column, value string) (string, error) {
+	id, data, _ := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	aead, ok := c.aead[id]
+	if !ok {
+		return "", fmt.Errorf("%s is encrypted with an unknown key (%s)", column, id)
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(data)
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(column))
+	if err != nil {
+		return value, nil
+	}
+	return string(plaintext), nil
+}
+
+// Check whether a stored value is in the encrypted format: the prefix, a key id and
+// a nonce and ciphertext at least as long as the GCM tag
+func isEncrypted(value string) bool {
+	rest, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return false
+	}
+	id, data, ok := strings.Cut(rest, ":")
+	if _, err := hex.DecodeString(id); !ok || err != nil || len(id) != 8 {
+		return false
+	}
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	return err == nil && len(sealed) >= 12+16
+}
+
+// Get a sensitive value as it's stored: encrypted if there's a cipher, and otherwise
+// escaped if it could be taken for an encrypted value
+func storedValue(c *fieldCipher, column, value string) (string, error) {
+	if c != nil {
+		return c.encrypt(column, value)
+	}
+	if strings.HasPrefix(value, reservedPrefix) {
+		return escapedPrefix + value, nil
+	}
+	return value, nil
+}
+
+// Get a sensitive value back from how it's stored. Values that aren't in the
+// encrypted format, like titles that were stored before plaintext was escaped, are
+// plaintext.
+func (db *DB) loadedValue(column, value string) (string, error) {
+	if plaintext, ok := strings.CutPrefix(value, escapedPrefix); ok {
+		return plaintext, nil
+	}
+	if !isEncrypted(value) {
+		return value, nil
+	}
+	c, err := db.getCipher(false)
+	if err != nil {
+		return "", err
+	}
+	if c == nil {
+		return "", fmt.Errorf("%s is encrypted, but encryption is not configured", column)
+	}
+	return c.decrypt(column, value)
+}
+
+// Get the cipher for storing values, or nil if encryption is off
+func (db *DB) writeCipher() (*fieldCipher, error) {
+	if db.encryption.KeyStore == "" {
+		return nil, nil
+	}
+	return db.getCipher(true)
+}
+
+// Load the encryption keys from the configured key store. Returns a nil cipher if
+// encryption is off or there are no keys yet.
+func loadFieldCipher(cfg EncryptionConfig) (*fieldCipher, error) {
+	if cfg.KeyStore == "" {
+		return nil, nil
+	}
+	keys, err := loadKeys(cfg)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	return newFieldCipher(keys)
+}
+
+// Generate a key and save it as the only key
+func createFieldCipher(cfg EncryptionConfig) (*fieldCipher, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("unable to generate encryption key: %v", err)
+	}
+	if err := saveKeys(cfg, [][]byte{key}); err != nil {
+		return nil, err
+	}
+	return newFieldCipher([][]byte{key})
+}
+
+// Keys are stored as base64, separated by spaces, with the current key first
+func loadKeys(cfg EncryptionConfig) ([][]byte, error) {
+	var data string
+	switch cfg.KeyStore {
+	case "file":
+		path, err := getKeyFilePath(cfg)
+		if err != nil {
+			return nil, err
+		}
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read key file: %v", err)
+		}
+		data = string(content)
+	case "keyring":
+		var err error
+		if data, err = readKeyring(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key store: %s (expected keyring or file)", cfg.KeyStore)
+	}
+
+	var keys [][]byte
+	for _, field := range strings.Fields(data) {
+		key, err := base64.StdEncoding.DecodeString(field)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("invalid encryption key in %s key store", cfg.KeyStore)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func saveKeys(cfg EncryptionConfig, keys [][]byte) error {
+	encoded := make([]string, len(keys))
+	for i, key := range keys {
+		encoded[i] = base64.StdEncoding.EncodeToString(key)
+	}
+	data := strings.Join(encoded, " ")
+
+	switch cfg.KeyStore {
+	case "file":
+		path, err := getKeyFilePath(cfg)
+		if err != nil {
+			return err
+		}
+		// write and rename, so the key file is never left half written
+		tmpPath := path + ".tmp"
+		if err := os.WriteFile(tmpPath, []byte(data+"\n"), 0600); err != nil {
+			return fmt.Errorf("unable to write key file: %v", err)
+		}
+		if err := os.Rename(tmpPath, path); err != nil {
+			return fmt.Errorf("unable to write key file: %v", err)
+		}
+		return nil
+	case "keyring":
+		return writeKeyring(data)
+	default:
+		return fmt.Errorf("unsupported key store: %s (expected keyring or file)", cfg.KeyStore)
+	}
+}
+
+func getKeyFilePath(cfg EncryptionConfig) (string, error) {
+	if cfg.KeyFile != "" {
+		return cfg.KeyFile, nil
+	}
+	configDir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "database.key"), nil
+}
+
+// Read the keys from the macOS keychain, or the Secret Service on Linux. Returns an
+// empty string if there are none yet.
+func readKeyring() (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", keyringAccount, "-w")
+	} else {
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", keyringAccount)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		// both fail without output, other than saying so, when there's no key yet
+		_, exited := err.(*exec.ExitError)
+		if exited && stdout.Len() == 0 && (stderr.Len() == 0 || strings.Contains(stderr.String(), "could not be found")) {
+			return "", nil
+		}
+		return "", fmt.Errorf("unable to read encryption key from keyring: %v, stderr: %s", err, stderr.String())
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Save the keys to the keyring. They're passed on stdin, never as arguments, which
+// other users could see in the process list.
+func writeKeyring(data string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		// security only reads passwords from the terminal, so run the command in its
+		// interactive mode, which reads commands from stdin. Keys are base64, so they
+		// need no escaping inside quotes.
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w \"%s\"\n",
+			keyringService, keyringAccount, data))
+	} else {
+		cmd = exec.Command("secret-tool", "store", "--label=activitymon database key",
+			"service", keyringService, "account", keyringAccount)
+		cmd.Stdin = strings.NewReader(data)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to save encryption key to keyring: %v, output: %s", err, output)
+	}
+
+	// security -i exits successfully even when a command fails
+	saved, err := readKeyring()
+	if err != nil {
+		return err
+	}
+	if saved != data {
+		return fmt.Errorf("unable to save encryption key to keyring: the keyring doesn't have the new key")
+	}
+	return nil
+}
+
+// Replace the encryption key, re-encrypting all activities with the new one. The old
+// key is kept until every activity has been re-encrypted, so an interrupted rekey can
+// simply be run again. Activities stored before encryption was turned on are encrypted.
+func rekeyDatabase(db *DB, cfg EncryptionConfig) (int, error) {
+	if cfg.KeyStore == "" {
+		return 0, fmt.Errorf("encryption is not enabled; set database.encryption.keyStore in the config")
+	}
+	oldKeys, err := loadKeys(cfg)
+	if err != nil {
+		return 0, err
+	}
+	newKey := make([]byte, 32)
+	if _, err := rand.Read(newKey); err != nil {
+		return 0, fmt.Errorf("unable to generate encryption key: %v", err)
+	}
+	if err := saveKeys(cfg, append([][]byte{newKey}, oldKeys...)); err != nil {
+		return 0, err
+	}
+	c, err := newFieldCipher(append([][]byte{newKey}, oldKeys...))
+	if err != nil {
+		return 0, err
+	}
+	db.setCipher(c)
+
+	var rekeyed int
+	var lastID int64
+	for {
+		rows, err := db.Query(db.rebind(`
+			SELECT `+activityColumns+`
+			FROM activities
+			WHERE id > ?
+			ORDER BY id
+			LIMIT ?
+		`), lastID, copyBatchSize)
+		if err != nil {
+			return rekeyed, fmt.Errorf("error querying database: %v", err)
+		}
+		batch, err := scanActivities(rows)
+		rows.Close()
+		if err == nil {
+			err = db.decryptActivities(batch)
+		}
+		if err != nil {
+			return rekeyed, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return rekeyed, fmt.Errorf("error starting transaction: %v", err)
+		}
+		for _, record := range batch {
+			if err := db.encryptActivity(&record); err != nil {
+				tx.Rollback()
+				return rekeyed, err
+			}
+			if _, err := tx.Exec(db.rebind(`UPDATE activities SET window_title = ?, url = ?, file_path = ? WHERE id = ?`),
+				record.Title, record.URL, record.File, record.ID); err != nil {
+				tx.Rollback()
+				return rekeyed, fmt.Errorf("error re-encrypting activity %d: %v", record.ID, err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return rekeyed, fmt.Errorf("error committing re-encrypted activities: %v", err)
+		}
+		lastID = batch[len(batch)-1].ID
+		rekeyed += len(batch)
+	}
+	if err := rekeyCommands(db); err != nil {
+		return rekeyed, err
+	}
+
+	if err := saveKeys(cfg, [][]byte{newKey}); err != nil {
+		return rekeyed, err
+	}
+	return rekeyed, nil
+}
+
+// Re-encrypt the working directories of shell commands with the database's cipher
+func rekeyCommands(db *DB) error {
+	var lastID int64
+	for {
+		rows, err := db.Query(db.rebind(`
+			SELECT `+commandColumns+`
+			FROM commands
+			WHERE id > ? AND cwd <> ''
+			ORDER BY id
+			LIMIT ?
+		`), lastID, copyBatchSize)
+		if err != nil {
+			return fmt.Errorf("error querying database: %v", err)
+		}
+		batch, err := scanShellCommands(rows)
+		rows.Close()
+		if err == nil {
+			err = db.decryptCommands(batch)
+		}
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("error starting transaction: %v", err)
+		}
+		for _, command := range batch {
+			if err := db.encryptCommand(&command); err != nil {
+				tx.Rollback()
+				return err
+			}
+			if _, err := tx.Exec(db.rebind(`UPDATE commands SET cwd = ? WHERE id = ?`), command.Cwd, command.ID); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("error re-encrypting command %d: %v", command.ID, err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("error committing re-encrypted commands: %v", err)
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+}
+
+func dbRekeyCmd(c *cli.Context) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	// a running monitor would keep encrypting with the old key, which is dropped
+	// once every activity has been re-encrypted
+	lockPath, err := getLockPath(cfg)
+	if err != nil {
+		return err
+	}
+	lock, err := acquireInstanceLock(lockPath)
+	if err != nil {
+		return fmt.Errorf("%v; stop the monitor before rekeying", err)
+	}
+	defer lock.Release()
+
+	db, err := getDb()
+	if err != nil {
+		return fmt.Errorf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	rekeyed, err := rekeyDatabase(db, cfg.Database.Encryption)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Re-encrypted %d activities with a new key\n", rekeyed)
+	return nil
+}
+
+// Get the database's cipher, loading the keys the first time it's needed, so queries
+// that don't touch titles or URLs work without them. Nil if encryption is off. A key
+// is only created for writing, and never while activities are encrypted with one
+// that's missing, as they would become unreadable.
+func (db *DB) getCipher(forWriting bool) (*fieldCipher, error) {
+	db.cipherMu.Lock()
+	defer db.cipherMu.Unlock()
+	if db.cipher != nil || db.encryption.KeyStore == "" {
+		return db.cipher, nil
+	}
+
+	c, err := loadFieldCipher(db.encryption)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		var encrypted int
+		err := db.QueryRow(`
+			SELECT (SELECT COUNT(*) FROM activities WHERE window_title LIKE 'enc:v1:%' OR url LIKE 'enc:v1:%' OR file_path LIKE 'enc:v1:%')
+				+ (SELECT COUNT(*) FROM commands WHERE cwd LIKE 'enc:v1:%')
+		`).Scan(&encrypted)
+		if err != nil {
+			return nil, fmt.Errorf("error checking for encrypted activities: %v", err)
+		}
+		if encrypted > 0 || !forWriting {
+			return nil, fmt.Errorf("no encryption key found in the %s key store", db.encryption.KeyStore)
+		}
+		if c, err = createFieldCipher(db.encryption); err != nil {
+			return nil, err
+		}
+	}
+	db.cipher = c
+	return c, nil
+}
+
+func (db *DB) setCipher(c *fieldCipher) {
+	db.cipherMu.Lock()
+	defer db.cipherMu.Unlock()
+	db.cipher = c
+}
+
+// The sensitive columns of an activity, with pointers to their values
+func (r *ActivityRecord) sensitiveFields() []struct {
+	column string
+	value  *string
+} {
+	return []struct {
+		column string
+		value  *string
+	}{{"window_title", &r.Title}, {"url", &r.URL}, {"file_path", &r.File}}
+}
+
+// Prepare an activity's title, URL and file for storage: encrypted if encryption is
+// on, and escaped where they could be taken for encrypted values otherwise
+func (db *DB) encryptActivity(record *ActivityRecord) error {
+	c, err := db.writeCipher()
+	if err != nil {
+		return err
+	}
+	for _, field := range record.sensitiveFields() {
+		if *field.value, err = storedValue(c, field.column, *field.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decrypt the titles, URLs and files of activities read from the database
+func (db *DB) decryptActivities(records []ActivityRecord) error {
+	for i := range records {
+		for _, field := range records[i].sensitiveFields() {
+			var err error
+			if *field.value, err = db.loadedValue(field.column, *field.value); err != nil {
+				return fmt.Errorf("activity %d: %v", records[i].ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Get the values of activityFields for storage, encrypted if encryption is on
+func (db *DB) activityValues(record ActivityRecord) ([]any, error) {
+	if err := db.encryptActivity(&record); err != nil {
+		return nil, err
+	}
+	return record.fieldValues(), nil
+}
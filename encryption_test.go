@@ -0,0 +1,183 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openEncryptedTestDb(t *testing.T, keyFile string) *DB {
+	t.Helper()
+	db, err := openDb(DatabaseConfig{
+		Type:       "sqlite",
+		SqlitePath: filepath.Join(t.TempDir(), "tracker.db"),
+		Encryption: EncryptionConfig{KeyStore: "file", KeyFile: keyFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Read a stored activity's title as it is in the database
+func storedTitle(t *testing.T, db *DB, id int64) string {
+	t.Helper()
+	var title string
+	if err := db.QueryRow(db.rebind(`SELECT window_title FROM activities WHERE id = ?`), id).Scan(&title); err != nil {
+		t.Fatal(err)
+	}
+	return title
+}
+
+func mustGetActivity(t *testing.T, db *DB, id int64) *ActivityRecord {
+	t.Helper()
+	record, err := db.queryActivityByID(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+// Titles, URLs and files are stored encrypted and read back as they were
+func TestEncryptionRoundTrip(t *testing.T) {
+	db := openEncryptedTestDb(t, filepath.Join(t.TempDir(), "database.key"))
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour)
+	record := ActivityRecord{StartTime: start, EndTime: &end, Name: "Code", App: "Code",
+		Title: "main.go — activitymon", URL: "https://example.com/?q=ü", File: "/src/main.go"}
+	id, err := db.insertActivity(db, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored := storedTitle(t, db, id); !isEncrypted(stored) {
+		t.Errorf("stored title %q isn't encrypted", stored)
+	}
+	got := mustGetActivity(t, db, id)
+	if got.Title != record.Title || got.URL != record.URL || got.File != record.File {
+		t.Errorf("read back %q, %q, %q, want %q, %q, %q", got.Title, got.URL, got.File, record.Title, record.URL, record.File)
+	}
+}
+
+// Values encrypted with a key that isn't in the key store can't be read, while
+// values that only look encrypted are plaintext
+func TestDecryptWithWrongKey(t *testing.T) {
+	ours, err := newFieldCipher([][]byte{make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey := make([]byte, 32)
+	otherKey[0] = 1
+	theirs, err := newFieldCipher([][]byte{otherKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := theirs.encrypt("window_title", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ours.decrypt("window_title", encrypted); err == nil || !strings.Contains(err.Error(), "unknown key") {
+		t.Errorf("decrypting with the wrong key gave %v, want an unknown key error", err)
+	}
+
+	// The same key id, but a different key, so the value doesn't authenticate
+	forged := encryptedPrefix + ours.keyID + strings.TrimPrefix(encrypted, encryptedPrefix+theirs.keyID)
+	if got, err := ours.decrypt("window_title", forged); err != nil || got != forged {
+		t.Errorf("decrypting a value that doesn't authenticate gave %q, %v, want it as it is", got, err)
+	}
+
+	// Encrypted for another column
+	encrypted, err = ours.encrypt("url", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ours.decrypt("window_title", encrypted); err != nil || got != encrypted {
+		t.Errorf("decrypting another column's value gave %q, %v, want it as it is", got, err)
+	}
+}
+
+// Rekeying re-encrypts everything with a new key, and keeps the values readable
+func TestRekeyDatabase(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "database.key")
+	db := openEncryptedTestDb(t, keyFile)
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour)
+	encryptedID, err := db.insertActivity(db, ActivityRecord{StartTime: start, EndTime: &end, Name: "Code", Title: "encrypted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stored before encryption was turned on, including one that looks encrypted
+	var plainIDs []int64
+	for _, title := range []string{"plain", encryptedPrefix + "not really"} {
+		result, err := db.Exec(db.rebind(`INSERT INTO activities (start_time, activity_name, window_title) VALUES (?, ?, ?)`),
+			dbTime(start), "Code", title)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := result.LastInsertId()
+		plainIDs = append(plainIDs, id)
+	}
+	oldKeyID := strings.Split(strings.TrimPrefix(storedTitle(t, db, encryptedID), encryptedPrefix), ":")[0]
+
+	rekeyed, err := rekeyDatabase(db, db.encryption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rekeyed != 3 {
+		t.Errorf("rekeyed %d activities, want 3", rekeyed)
+	}
+	keys, err := loadKeys(db.encryption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keyID(keys[0]) == oldKeyID {
+		t.Fatalf("key store has %d keys after rekeying, want only a new one", len(keys))
+	}
+
+	for id, want := range map[int64]string{encryptedID: "encrypted", plainIDs[0]: "plain", plainIDs[1]: encryptedPrefix + "not really"} {
+		if stored := storedTitle(t, db, id); !strings.HasPrefix(stored, encryptedPrefix+keyID(keys[0])+":") {
+			t.Errorf("activity %d is stored as %q, want it encrypted with the new key", id, stored)
+		}
+		if got := mustGetActivity(t, db, id).Title; got != want {
+			t.Errorf("activity %d has title %q after rekeying, want %q", id, got, want)
+		}
+	}
+}
+
+// Plaintext that starts like an encrypted value is stored escaped without encryption
+// and read back as it was, and old unescaped rows are read as plaintext
+func TestEncryptedPrefixCollision(t *testing.T) {
+	db := openTestDb(t)
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour)
+	for _, title := range []string{
+		encryptedPrefix + "deadbeef:notbase64",
+		encryptedPrefix + "0011aabb:" + strings.Repeat("A", 40),
+		escapedPrefix + "already escaped",
+		"enc:",
+	} {
+		id, err := db.insertActivity(db, ActivityRecord{StartTime: start, EndTime: &end, Name: "Code", Title: title})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored := storedTitle(t, db, id); stored != escapedPrefix+title {
+			t.Errorf("title %q is stored as %q, want it escaped", title, stored)
+		}
+		if got := mustGetActivity(t, db, id).Title; got != title {
+			t.Errorf("title %q is read back as %q", title, got)
+		}
+	}
+
+	result, err := db.Exec(db.rebind(`INSERT INTO activities (start_time, activity_name, window_title) VALUES (?, ?, ?)`),
+		dbTime(start), "Code", encryptedPrefix+"not really")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	if got := mustGetActivity(t, db, id).Title; got != encryptedPrefix+"not really" {
+		t.Errorf("an old title that looks encrypted is read back as %q", got)
+	}
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -18,3 +21,27 @@ func truncateString(s string, maxLength int) string {
 	}
 	return s
 }
+
+// Shorten a file path for display, keeping its end, where the file name is
+func truncatePath(path string, maxLength int) string {
+	if len(path) > maxLength {
+		return "..." + path[len(path)-maxLength+3:]
+	}
+	return path
+}
+
+// Write a path under the home directory as ~/...
+func shortenHome(path string) string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return path
+	}
+	if rel, err := filepath.Rel(home, path); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		return filepath.Join("~", rel)
+	}
+	return path
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long a detected project is reused for the same window
+const gitProjectCacheTTL = 15 * time.Second
+
+// How long the app's processes aren't looked at after they couldn't be listed, so a
+// failure isn't reported on every poll
+const gitProjectRetryDelay = 5 * time.Minute
+
+// Terminals and editors whose windows are usually in a git repository
+var gitProjectApps = []string{
+	"Terminal", "iTerm2", "Alacritty", "kitty", "WezTerm", "Ghostty", "Hyper", "Warp", "Tabby",
+	"gnome-terminal", "gnome-terminal-server", "Konsole", "xterm", "Tilix", "foot",
+	"Code", "Visual Studio Code", "Cursor", "Zed", "Sublime Text", "Emacs", "MacVim", "Xcode", "Nova",
+	"IntelliJ IDEA", "GoLand", "PyCharm", "WebStorm", "CLion", "RubyMine", "PhpStorm", "Rider", "Android Studio",
+}
+
+// Absolute or home-relative paths in window titles
+var titlePathPattern = regexp.MustCompile(`(?:~|/)[^\s:"'()\[\]<>|]*`)
+
+// A running process, for finding what the frontmost app's windows are working in
+type process struct {
+	PID, PPID int
+	Start     int64 // only comparable between processes on the same machine
+}
+
+// Detects the git repository of the frontmost terminal or editor window
+type gitProjectDetector struct {
+	apps   []string
+	cached struct {
+		app, title, project string
+		pid                 int
+		at                  time.Time
+	}
+	failedAt time.Time
+}
+
+func newGitProjectDetector(cfg GitProjectsConfig) *gitProjectDetector {
+	if cfg.Disabled {
+		return nil
+	}
+	return &gitProjectDetector{apps: append(slices.Clone(gitProjectApps), cfg.Apps...)}
+}
+
+// Get the name of the git repository the window is in, or "" if it's not a terminal
+// or editor, or isn't in a repository. Paths in the window title are tried first,
+// then the working directories of the app's processes, newest first, since a
+// terminal's shell or an editor's language server runs in the repository. pid is the
+// app's process id, as given by the window collector, or 0 if it's not known.
+func (d *gitProjectDetector) detect(app, title string, pid int, now time.Time) (string, error) {
+	if d == nil || !slices.ContainsFunc(d.apps, func(a string) bool { return strings.EqualFold(a, app) }) {
+		return "", nil
+	}
+	if d.cached.app == app && d.cached.title == title && d.cached.pid == pid && now.Sub(d.cached.at) < gitProjectCacheTTL {
+		return d.cached.project, nil
+	}
+
+	var err error
+	root := gitRootFromTitle(title)
+	if root == "" && pid != 0 && now.Sub(d.failedAt) >= gitProjectRetryDelay {
+		if root, err = gitRootOfProcessTree(pid); err != nil {
+			d.failedAt = now
+		}
+	}
+
+	project := ""
+	if root != "" {
+		// not a project name otherwise
+		project = strings.ReplaceAll(filepath.Base(root), labelSeparator, "_")
+	}
+	d.cached.app, d.cached.title, d.cached.pid, d.cached.project, d.cached.at = app, title, pid, project, now
+	return project, err
+}
+
+func gitRootFromTitle(title string) string {
+	home, _ := os.UserHomeDir()
+	for _, path := range titlePathPattern.FindAllString(title, -1) {
+		if strings.HasPrefix(path, "~") {
+			if home == "" {
+				continue
+			}
+			path = home + path[1:]
+		}
+		if root := findGitRoot(path); root != "" {
+			return root
+		}
+	}
+	return ""
+}
+
+// Walk up from a path to the directory containing .git, which is a file in worktrees
+// and submodules
+func findGitRoot(path string) string {
+	path = filepath.Clean(path)
+	if info, err := os.Stat(path); err != nil {
+		return ""
+	} else if !info.IsDir() {
+		path = filepath.Dir(path)
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return ""
+		}
+		path = parent
+	}
+}
+
+// Find the git repository of the newest process under pid that's working in one
+func gitRootOfProcessTree(pid int) (string, error) {
+	processes, err := listProcesses()
+	if err != nil {
+		return "", err
+	}
+	children := make(map[int][]process)
+	var tree []process
+	for _, p := range processes {
+		children[p.PPID] = append(children[p.PPID], p)
+		if p.PID == pid {
+			tree = append(tree, p)
+		}
+	}
+	if len(tree) == 0 {
+		return "", nil // the process has exited
+	}
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i].PID]...)
+	}
+	slices.SortFunc(tree, func(a, b process) int {
+		if c := cmp.Compare(b.Start, a.Start); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.PID, a.PID)
+	})
+
+	pids := make([]int, len(tree))
+	for i, p := range tree {
+		pids[i] = p.PID
+	}
+	cwds, err := processCwds(pids)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range tree {
+		if root := findGitRoot(cwds[p.PID]); cwds[p.PID] != "" && root != "" {
+			return root, nil
+		}
+	}
+	return "", nil
+}
+
+func listProcesses() ([]process, error) {
+	if runtime.GOOS == "linux" {
+		return listProcProcesses()
+	}
+
+	// ps doesn't give start times in a sortable format, so newer processes are taken
+	// to have higher ids
+	out, err := exec.Command("ps", "-A", "-o", "pid=,ppid=").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing processes: %v", err)
+	}
+	var processes []process
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		pid, err1 := strconv.Atoi(fields[0])
+		ppid, err2 := strconv.Atoi(fields[1])
+		if err1 == nil && err2 == nil {
+			processes = append(processes, process{PID: pid, PPID: ppid})
+		}
+	}
+	return processes, nil
+}
+
+func listProcProcesses() ([]process, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("error listing processes: %v", err)
+	}
+	var processes []process
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			continue // the process has exited
+		}
+		// the command name is in parentheses and may contain spaces, so skip past it;
+		// the parent is the 4th field and the start time the 22nd
+		fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+		if len(fields) < 20 {
+			continue
+		}
+		ppid, _ := strconv.Atoi(fields[1])
+		start, _ := strconv.ParseInt(fields[19], 10, 64)
+		processes = append(processes, process{PID: pid, PPID: ppid, Start: start})
+	}
+	return processes, nil
+}
+
+// Get the working directories of processes. Processes that can't be inspected, like
+// other users', are left out.
+func processCwds(pids []int) (map[int]string, error) {
+	cwds := make(map[int]string)
+	if runtime.GOOS == "linux" {
+		for _, pid := range pids {
+			if cwd, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid)); err == nil {
+				cwds[pid] = cwd
+			}
+		}
+		return cwds, nil
+	}
+
+	args := make([]string, len(pids))
+	for i, pid := range pids {
+		args[i] = strconv.Itoa(pid)
+	}
+	// lsof exits with 1 when some processes can't be inspected, so only its output matters
+	out, _ := exec.Command("lsof", "-a", "-d", "cwd", "-Fpn", "-p", strings.Join(args, ",")).Output()
+	var pid int
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "p"):
+			pid, _ = strconv.Atoi(line[1:])
+		case strings.HasPrefix(line, "n"):
+			cwds[pid] = line[1:]
+		}
+	}
+	return cwds, scanner.Err()
+}
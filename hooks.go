@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	hookActivityStart = "activity.start"
+	hookActivityEnd   = "activity.end"
+	hookAway          = "away"
+	hookIdle          = "idle"
+
+	hookTimeout = 10 * time.Second
+)
+
+// The JSON payload sent to hooks
+type hookEvent struct {
+	Event           string     `json:"event"`
+	Time            time.Time  `json:"time"`
+	Activity        string     `json:"activity,omitempty"`
+	App             string     `json:"app,omitempty"`
+	Domain          string     `json:"domain,omitempty"`
+	Category        string     `json:"category,omitempty"`
+	StartTime       *time.Time `json:"startTime,omitempty"`
+	DurationSeconds float64    `json:"durationSeconds,omitempty"`
+}
+
+// Events waiting for a hook beyond this many are dropped
+const hookQueueSize = 100
+
+// Runs the configured hooks for activity transitions in the background. Each hook
+// has its own queue, so it gets events in order, and a slow hook doesn't hold up
+// the others.
+type hookRunner struct {
+	hooks   []HookConfig
+	queues  []chan hookEvent
+	onError func(error)
+	wg      *sync.WaitGroup // shared with the runners this one replaced
+}
+
+func newHookRunner(hooks []HookConfig, onError func(error)) *hookRunner {
+	return startHookRunner(hooks, onError, &sync.WaitGroup{})
+}
+
+func startHookRunner(hooks []HookConfig, onError func(error), wg *sync.WaitGroup) *hookRunner {
+	r := &hookRunner{hooks: hooks, onError: onError, wg: wg}
+	for _, hook := range hooks {
+		queue := make(chan hookEvent, hookQueueSize)
+		r.queues = append(r.queues, queue)
+		wg.Add(1)
+		go func(hook HookConfig) {
+			defer wg.Done()
+			for event := range queue {
+				if err := runHook(hook, event); err != nil {
+					onError(fmt.Errorf("%s hook failed: %v", event.Event, err))
+				}
+			}
+		}(hook)
+	}
+	return r
+}
+
+func (r *hookRunner) fire(event hookEvent) {
+	for i, queue := range r.queues {
+		if hook := r.hooks[i]; len(hook.Events) > 0 && !slices.Contains(hook.Events, event.Event) {
+			continue
+		}
+		select {
+		case queue <- event:
+		default:
+			r.onError(fmt.Errorf("%s hook is too far behind, dropping the event", event.Event))
+		}
+	}
+}
+
+// Replace the runner for new hooks. The old hooks still get the events queued for
+// them, and waiting on the new runner waits for those too.
+func (r *hookRunner) reload(hooks []HookConfig) *hookRunner {
+	r.close()
+	return startHookRunner(hooks, r.onError, r.wg)
+}
+
+func (r *hookRunner) close() {
+	for _, queue := range r.queues {
+		close(queue)
+	}
+	r.queues = nil
+}
+
+// Stop taking events, and wait for the queued ones to be delivered, up to the given timeout
+func (r *hookRunner) wait(timeout time.Duration) {
+	r.close()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+func runHook(hook HookConfig, event hookEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if hook.URL != "" {
+		if err := postJSON(hook.URL, payload); err != nil {
+			return err
+		}
+	}
+
+	if len(hook.Command) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+		cmd.Stdin = bytes.NewReader(payload)
+		cmd.Env = append(os.Environ(), "ACTIVITYMON_EVENT="+event.Event, "ACTIVITYMON_ACTIVITY="+event.Activity)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("command error: %v, stderr: %s", err, stderr.String())
+		}
+	}
+
+	return nil
+}
+
+// POST a JSON payload, treating any non-2xx response as an error
+func postJSON(url string, payload []byte) error {
+	client := &http.Client{Timeout: hookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error posting to %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned status %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A webhook that records the events it receives, slowly
+type testHookServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	events []hookEvent
+}
+
+func newTestHookServer(t *testing.T, delay time.Duration) *testHookServer {
+	s := &testHookServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event hookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		time.Sleep(delay)
+		s.mu.Lock()
+		s.events = append(s.events, event)
+		s.mu.Unlock()
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testHookServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for _, event := range s.events {
+		names = append(names, event.Event+" "+event.Activity)
+	}
+	return names
+}
+
+func TestHooksDeliverInOrder(t *testing.T) {
+	server := newTestHookServer(t, 10*time.Millisecond)
+	starts := newTestHookServer(t, 0)
+	hooks := newHookRunner([]HookConfig{
+		{URL: server.URL},
+		{URL: starts.URL, Events: []string{hookActivityStart}},
+	}, func(err error) { t.Error(err) })
+
+	var want []string
+	for _, activity := range []string{"Code", "Slack", "github.com", "Code"} {
+		hooks.fire(hookEvent{Event: hookActivityStart, Activity: activity})
+		hooks.fire(hookEvent{Event: hookActivityEnd, Activity: activity})
+		want = append(want, hookActivityStart+" "+activity, hookActivityEnd+" "+activity)
+	}
+	hooks.fire(hookEvent{Event: hookAway})
+	want = append(want, hookAway+" ")
+	hooks.wait(5 * time.Second)
+
+	if got := server.received(); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := starts.received(); len(got) != 4 || got[1] != hookActivityStart+" Slack" {
+		t.Errorf("got %q, want only the starts", got)
+	}
+}
+
+func TestHooksWaitForReloadedRunners(t *testing.T) {
+	old := newTestHookServer(t, 50*time.Millisecond)
+	reloaded := newTestHookServer(t, 0)
+	hooks := newHookRunner([]HookConfig{{URL: old.URL}}, func(err error) { t.Error(err) })
+	hooks.fire(hookEvent{Event: hookActivityStart, Activity: "Code"})
+	hooks.fire(hookEvent{Event: hookActivityEnd, Activity: "Code"})
+
+	hooks = hooks.reload([]HookConfig{{URL: reloaded.URL}})
+	hooks.fire(hookEvent{Event: hookActivityStart, Activity: "Slack"})
+	hooks.wait(5 * time.Second)
+
+	if got := old.received(); len(got) != 2 {
+		t.Errorf("old hook got %q, want both events before the reload", got)
+	}
+	if got := reloaded.received(); len(got) != 1 {
+		t.Errorf("reloaded hook got %q, want the event after the reload", got)
+	}
+}
+
+func TestHookErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	errs := make(chan error, 1)
+	hooks := newHookRunner([]HookConfig{{URL: server.URL}}, func(err error) { errs <- err })
+	hooks.fire(hookEvent{Event: hookAway})
+	hooks.wait(5 * time.Second)
+	select {
+	case err := <-errs:
+		if want := "away hook failed: " + server.URL + " returned status 500 Internal Server Error"; err.Error() != want {
+			t.Errorf("got %q, want %q", err, want)
+		}
+	default:
+		t.Error("no error for a failing hook")
+	}
+}
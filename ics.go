@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurring events are expanded from this long before the import up to this far
+// ahead of it, and to at most this many occurrences
+const (
+	calendarHistory      = 365 * 24 * time.Hour
+	calendarHorizon      = 365 * 24 * time.Hour
+	maxEventOccurrences  = 5000
+	maxRecurrencePeriods = 100000 // periods to look through for occurrences, for rules that rarely match
+	icsDateFormat        = "20060102"
+	icsDateTimeFormat    = "20060102T150405"
+	icsUTCDateTimeFormat = "20060102T150405Z"
+)
+
+// Windows time zone names, as Outlook and Exchange write them, for the most common zones
+var windowsTimeZones = map[string]string{
+	"UTC":                            "UTC",
+	"GMT Standard Time":              "Europe/London",
+	"W. Europe Standard Time":        "Europe/Berlin",
+	"Romance Standard Time":          "Europe/Paris",
+	"Central Europe Standard Time":   "Europe/Budapest",
+	"Central European Standard Time": "Europe/Warsaw",
+	"E. Europe Standard Time":        "Europe/Chisinau",
+	"FLE Standard Time":              "Europe/Kiev",
+	"Russian Standard Time":          "Europe/Moscow",
+	"India Standard Time":            "Asia/Kolkata",
+	"China Standard Time":            "Asia/Shanghai",
+	"Singapore Standard Time":        "Asia/Singapore",
+	"Tokyo Standard Time":            "Asia/Tokyo",
+	"AUS Eastern Standard Time":      "Australia/Sydney",
+	"New Zealand Standard Time":      "Pacific/Auckland",
+	"Eastern Standard Time":          "America/New_York",
+	"Central Standard Time":          "America/Chicago",
+	"Mountain Standard Time":         "America/Denver",
+	"US Mountain Standard Time":      "America/Phoenix",
+	"Pacific Standard Time":          "America/Los_Angeles",
+	"Alaskan Standard Time":          "America/Anchorage",
+	"Hawaiian Standard Time":         "Pacific/Honolulu",
+	"E. South America Standard Time": "America/Sao_Paulo",
+}
+
+// A property line of an iCalendar file, e.g. DTSTART;TZID=Europe/Berlin:20241105T140000
+type icsProperty struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// A VEVENT, with its times in the event's time zone
+type icsEvent struct {
+	UID          string
+	Summary      string
+	Status       string
+	Start, End   time.Time
+	Duration     time.Duration // from DURATION, for events without an end
+	AllDay       bool
+	RRule        string
+	ExDates      []time.Time
+	RecurrenceID time.Time // set on occurrences that were changed, to the start they replace
+}
+
+// Read the events of an iCalendar file. Problems with single events, like an unknown
+// time zone, are returned as warnings, and the rest of the file is still read.
+func parseICS(r io.Reader) ([]icsEvent, []string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// long lines are folded onto lines starting with a space or tab
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading calendar: %v", err)
+	}
+
+	var events []icsEvent
+	var warnings []string
+	var components []string
+	var event icsEvent
+	var eventErr error
+	for _, line := range lines {
+		property, err := parseICSProperty(line)
+		if err != nil {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+		switch property.Name {
+		case "BEGIN":
+			components = append(components, strings.ToUpper(property.Value))
+			if strings.EqualFold(property.Value, "VEVENT") {
+				event, eventErr = icsEvent{}, nil
+			}
+			continue
+		case "END":
+			if len(components) == 0 {
+				return nil, warnings, fmt.Errorf("unexpected END:%s", property.Value)
+			}
+			if components[len(components)-1] == "VEVENT" {
+				switch {
+				case eventErr != nil:
+					warnings = append(warnings, fmt.Sprintf("skipped event %q: %v", event.Summary, eventErr))
+				case event.Start.IsZero():
+					warnings = append(warnings, fmt.Sprintf("skipped event %q: no start", event.Summary))
+				default:
+					if event.End.IsZero() {
+						event.End = event.Start.Add(event.Duration)
+					}
+					events = append(events, event)
+				}
+			}
+			components = components[:len(components)-1]
+			continue
+		}
+		// properties of alarms and other components in the event don't belong to it
+		if len(components) == 0 || components[len(components)-1] != "VEVENT" || eventErr != nil {
+			continue
+		}
+
+		switch property.Name {
+		case "UID":
+			event.UID = property.Value
+		case "SUMMARY":
+			event.Summary = unescapeICSText(property.Value)
+		case "STATUS":
+			event.Status = strings.ToUpper(property.Value)
+		case "RRULE":
+			event.RRule = property.Value
+		case "DTSTART":
+			event.Start, event.AllDay, eventErr = parseICSTime(property, &warnings)
+		case "DTEND":
+			event.End, _, eventErr = parseICSTime(property, &warnings)
+		case "DURATION":
+			event.Duration, eventErr = parseICSDuration(property.Value)
+		case "RECURRENCE-ID":
+			event.RecurrenceID, _, eventErr = parseICSTime(property, &warnings)
+		case "EXDATE":
+			for _, value := range strings.Split(property.Value, ",") {
+				exdate, _, err := parseICSTime(icsProperty{Params: property.Params, Value: value}, &warnings)
+				if err != nil {
+					eventErr = err
+					break
+				}
+				event.ExDates = append(event.ExDates, exdate)
+			}
+		}
+	}
+	return events, warnings, nil
+}
+
+func parseICSProperty(line string) (icsProperty, error) {
+	// the value starts after the first colon that isn't in a quoted parameter
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return icsProperty{}, fmt.Errorf("invalid calendar line: %q", line)
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	property := icsProperty{Name: strings.ToUpper(parts[0]), Params: make(map[string]string), Value: line[colon+1:]}
+	for _, param := range parts[1:] {
+		if key, value, ok := strings.Cut(param, "="); ok {
+			property.Params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+		}
+	}
+	return property, nil
+}
+
+func unescapeICSText(value string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
+
+// Parse a date or date-time in its time zone: UTC if it ends in Z, the TZID
+// parameter's zone, or local time if it has neither
+func parseICSTime(property icsProperty, warnings *[]string) (time.Time, bool, error) {
+	value := property.Value
+	if property.Params["VALUE"] == "DATE" || len(value) == len(icsDateFormat) {
+		t, err := time.ParseInLocation(icsDateFormat, value, time.Local)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(icsUTCDateTimeFormat, value)
+		return t, false, err
+	}
+
+	loc := time.Local
+	if tzid := property.Params["TZID"]; tzid != "" {
+		var err error
+		if loc, err = loadICSLocation(tzid); err != nil {
+			loc = time.Local
+			warning := fmt.Sprintf("unknown time zone %q, using local time", tzid)
+			if !slices.Contains(*warnings, warning) {
+				*warnings = append(*warnings, warning)
+			}
+		}
+	}
+	t, err := time.ParseInLocation(icsDateTimeFormat, value, loc)
+	return t, false, err
+}
+
+func loadICSLocation(tzid string) (*time.Location, error) {
+	if name, ok := windowsTimeZones[tzid]; ok {
+		tzid = name
+	}
+	loc, err := time.LoadLocation(tzid)
+	if err == nil {
+		return loc, nil
+	}
+	// some calendars prefix IANA names, e.g. /mozilla.org/20050126_1/Europe/Berlin
+	parts := strings.Split(strings.Trim(tzid, "/"), "/")
+	for i := len(parts) - 2; i >= 0; i-- {
+		if loc, err := time.LoadLocation(strings.Join(parts[i:], "/")); err == nil {
+			return loc, nil
+		}
+	}
+	return nil, err
+}
+
+// Parse a duration such as PT1H30M, P1D or -P1W
+func parseICSDuration(value string) (time.Duration, error) {
+	sign := time.Duration(1)
+	rest := strings.TrimPrefix(value, "+")
+	if strings.HasPrefix(rest, "-") {
+		sign, rest = -1, rest[1:]
+	}
+	if !strings.HasPrefix(rest, "P") || len(rest) < 3 {
+		return 0, fmt.Errorf("invalid duration: %q", value)
+	}
+
+	var total time.Duration
+	inTime := false
+	number := ""
+	for _, c := range rest[1:] {
+		switch {
+		case c >= '0' && c <= '9':
+			number += string(c)
+			continue
+		case c == 'T':
+			inTime = true
+			continue
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %q", value)
+		}
+		number = ""
+		switch {
+		case c == 'W' && !inTime:
+			total += time.Duration(n) * 7 * 24 * time.Hour
+		case c == 'D' && !inTime:
+			total += time.Duration(n) * 24 * time.Hour
+		case c == 'H' && inTime:
+			total += time.Duration(n) * time.Hour
+		case c == 'M' && inTime:
+			total += time.Duration(n) * time.Minute
+		case c == 'S' && inTime:
+			total += time.Duration(n) * time.Second
+		default:
+			return 0, fmt.Errorf("invalid duration: %q", value)
+		}
+	}
+	if number != "" {
+		return 0, fmt.Errorf("invalid duration: %q", value)
+	}
+	return sign * total, nil
+}
+
+// A weekday in a BYDAY rule part, e.g. MO, or 2TU for the second Tuesday and -1FR
+// for the last Friday of the month
+type recurrenceDay struct {
+	N   int
+	Day time.Weekday
+}
+
+// The supported part of an RRULE
+type recurrenceRule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []recurrenceDay
+	ByMonthDay []int
+	ByMonth    []int
+	WeekStart  time.Weekday
+}
+
+var icsWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+func parseRecurrenceRule(value string, loc *time.Location) (recurrenceRule, error) {
+	rule := recurrenceRule{Interval: 1, WeekStart: time.Monday}
+	for _, part := range strings.Split(value, ";") {
+		key, value, _ := strings.Cut(part, "=")
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(value)
+			if !slices.Contains([]string{"DAILY", "WEEKLY", "MONTHLY", "YEARLY"}, rule.Freq) {
+				return rule, fmt.Errorf("unsupported recurrence frequency: %s", value)
+			}
+		case "INTERVAL":
+			if rule.Interval, err = strconv.Atoi(value); err == nil && rule.Interval < 1 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(value)
+		case "UNTIL":
+			switch {
+			case strings.HasSuffix(value, "Z"):
+				rule.Until, err = time.Parse(icsUTCDateTimeFormat, value)
+			case len(value) == len(icsDateFormat):
+				// the whole last day is included
+				if rule.Until, err = time.ParseInLocation(icsDateFormat, value, loc); err == nil {
+					rule.Until = rule.Until.AddDate(0, 0, 1).Add(-time.Second)
+				}
+			default:
+				rule.Until, err = time.ParseInLocation(icsDateTimeFormat, value, loc)
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekday, ok := icsWeekdays[strings.ToUpper(day[max(len(day)-2, 0):])]
+				if !ok {
+					return rule, fmt.Errorf("invalid recurrence day: %s", day)
+				}
+				n := 0
+				if prefix := day[:len(day)-2]; prefix != "" {
+					if n, err = strconv.Atoi(prefix); err != nil {
+						break
+					}
+				}
+				rule.ByDay = append(rule.ByDay, recurrenceDay{N: n, Day: weekday})
+			}
+		case "BYMONTHDAY":
+			rule.ByMonthDay, err = parseICSInts(value)
+		case "BYMONTH":
+			rule.ByMonth, err = parseICSInts(value)
+		case "WKST":
+			var ok bool
+			if rule.WeekStart, ok = icsWeekdays[strings.ToUpper(value)]; !ok {
+				err = fmt.Errorf("invalid weekday")
+			}
+		default:
+			return rule, fmt.Errorf("unsupported recurrence rule part: %s", key)
+		}
+		if err != nil {
+			return rule, fmt.Errorf("invalid recurrence rule %s: %v", part, err)
+		}
+	}
+	if rule.Freq == "" {
+		return rule, fmt.Errorf("recurrence rule has no frequency")
+	}
+	if rule.Freq == "YEARLY" && len(rule.ByMonth) == 0 && slices.ContainsFunc(rule.ByDay, func(d recurrenceDay) bool { return d.N != 0 }) {
+		return rule, fmt.Errorf("unsupported recurrence rule: numbered days of the year")
+	}
+	return rule, nil
+}
+
+func parseICSInts(value string) ([]int, error) {
+	var ints []int
+	for _, part := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, n)
+	}
+	return ints, nil
+}
+
+// Get the starts of a recurring event from from up to the horizon, in its time zone,
+// with the time of day of the first, and whether there were more than
+// maxEventOccurrences of them. Occurrences before from still count towards COUNT.
+func (r recurrenceRule) occurrences(start, from, horizon time.Time) ([]time.Time, bool) {
+	var starts []time.Time
+	count := 0
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	}
+	firstDay := day(start.Year(), start.Month(), start.Day())
+
+	for period := 0; period < maxRecurrencePeriods; period++ {
+		var candidates []time.Time
+		switch r.Freq {
+		case "DAILY":
+			candidates = []time.Time{firstDay.AddDate(0, 0, period*r.Interval)}
+		case "WEEKLY":
+			offset := (int(start.Weekday()) - int(r.WeekStart) + 7) % 7
+			weekStart := firstDay.AddDate(0, 0, period*r.Interval*7-offset)
+			days := r.ByDay
+			if len(days) == 0 {
+				days = []recurrenceDay{{Day: start.Weekday()}}
+			}
+			for _, d := range days {
+				candidates = append(candidates, weekStart.AddDate(0, 0, (int(d.Day)-int(r.WeekStart)+7)%7))
+			}
+		case "MONTHLY":
+			month := time.Date(start.Year(), start.Month()+time.Month(period*r.Interval), 1, 0, 0, 0, 0, start.Location())
+			candidates = r.monthDays(month.Year(), month.Month(), start, day)
+		case "YEARLY":
+			year := start.Year() + period*r.Interval
+			months := r.ByMonth
+			if len(months) == 0 {
+				if len(r.ByDay) > 0 {
+					months = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+				} else {
+					months = []int{int(start.Month())}
+				}
+			}
+			for _, month := range months {
+				candidates = append(candidates, r.monthDays(year, time.Month(month), start, day)...)
+			}
+		}
+		slices.SortFunc(candidates, func(a, b time.Time) int { return a.Compare(b) })
+
+		for _, candidate := range candidates {
+			if candidate.Before(start) || !r.matchesFilters(candidate) {
+				continue
+			}
+			if (!r.Until.IsZero() && candidate.After(r.Until)) || candidate.After(horizon) {
+				return starts, false
+			}
+			if !candidate.Before(from) {
+				if len(starts) == maxEventOccurrences {
+					return starts, true
+				}
+				starts = append(starts, candidate)
+			}
+			if count++; count == r.Count {
+				return starts, false
+			}
+		}
+	}
+	return starts, false
+}
+
+// Get the days of a month a monthly or yearly rule falls on
+func (r recurrenceRule) monthDays(year int, month time.Month, start time.Time, day func(int, time.Month, int) time.Time) []time.Time {
+	daysInMonth := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	var days []time.Time
+	switch {
+	case len(r.ByMonthDay) > 0:
+		for _, d := range r.ByMonthDay {
+			if d < 0 {
+				d = daysInMonth + d + 1
+			}
+			if d >= 1 && d <= daysInMonth {
+				days = append(days, day(year, month, d))
+			}
+		}
+	case len(r.ByDay) > 0:
+		for _, weekday := range r.ByDay {
+			var matching []time.Time
+			for d := 1; d <= daysInMonth; d++ {
+				if t := day(year, month, d); t.Weekday() == weekday.Day {
+					matching = append(matching, t)
+				}
+			}
+			switch {
+			case weekday.N > 0 && weekday.N <= len(matching):
+				days = append(days, matching[weekday.N-1])
+			case weekday.N < 0 && -weekday.N <= len(matching):
+				days = append(days, matching[len(matching)+weekday.N])
+			case weekday.N == 0:
+				days = append(days, matching...)
+			}
+		}
+	case start.Day() <= daysInMonth:
+		// months without the day are skipped
+		days = append(days, day(year, month, start.Day()))
+	}
+	return days
+}
+
+// Check the rule parts that limit which days match, where they don't already pick them
+func (r recurrenceRule) matchesFilters(t time.Time) bool {
+	if len(r.ByMonth) > 0 && !slices.Contains(r.ByMonth, int(t.Month())) {
+		return false
+	}
+	if r.Freq == "DAILY" && len(r.ByMonthDay) > 0 {
+		daysInMonth := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+		if !slices.Contains(r.ByMonthDay, t.Day()) && !slices.Contains(r.ByMonthDay, t.Day()-daysInMonth-1) {
+			return false
+		}
+	}
+	if len(r.ByDay) > 0 && (r.Freq == "DAILY" || len(r.ByMonthDay) > 0) {
+		return slices.ContainsFunc(r.ByDay, func(d recurrenceDay) bool { return d.Day == t.Weekday() })
+	}
+	return true
+}
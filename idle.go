@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var hidIdleTimeRegexp = regexp.MustCompile(`"HIDIdleTime" = (\d+)`)
+
+// Get how long it's been since the last keyboard or mouse input
+func getIdleTime() (time.Duration, error) {
+	output, err := exec.Command("ioreg", "-c", "IOHIDSystem", "-d", "4").Output()
+	if err != nil {
+		return 0, fmt.Errorf("error running ioreg: %v", err)
+	}
+	return parseHIDIdleTime(string(output))
+}
+
+// Parse the idle time, in nanoseconds, out of ioreg's IOHIDSystem entry
+func parseHIDIdleTime(output string) (time.Duration, error) {
+	match := hidIdleTimeRegexp.FindStringSubmatch(output)
+	if match == nil {
+		return 0, fmt.Errorf("no HIDIdleTime in ioreg output")
+	}
+	nanoseconds, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid HIDIdleTime %q: %v", match[1], err)
+	}
+	return time.Duration(nanoseconds), nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHIDIdleTime(t *testing.T) {
+	output := `+-o IOHIDSystem  <class IOHIDSystem, id 0x100000465, registered, matched, active, busy 0 (0 ms), retain 23>
+    {
+      "HIDIdleTime" = 312548125
+      "HIDParameters" = {"HIDClickTime"=500000000}
+    }`
+	got, err := parseHIDIdleTime(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 312548125*time.Nanosecond {
+		t.Errorf("got %v, want 312.548125ms", got)
+	}
+
+	if _, err := parseHIDIdleTime("+-o IOHIDSystem\n    {\n    }"); err == nil {
+		t.Error("parsing output without an idle time didn't fail")
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Tag for activities that shouldn't count as work, e.g. in timesheets
+const personalTag = "personal"
+
+// Separates the projects or tags of an activity where they're written as one value
+const labelSeparator = ";"
+
+// Labels are loaded in batches of this many activities
+const labelBatchSize = 500
+
+// Both *DB and *sql.Tx, so labels can be written along with activities in a transaction
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// The tables of each kind of label, and the table assigning them to activities
+var labelTables = []struct {
+	table, assignments, column string
+	field                      func(*ActivityRecord) *[]string
+}{
+	{"projects", "activity_projects", "project_id", func(r *ActivityRecord) *[]string { return &r.Projects }},
+	{"tags", "activity_tags", "tag_id", func(r *ActivityRecord) *[]string { return &r.Tags }},
+}
+
+func (db *DB) setupLabels() error {
+	idColumn := "INTEGER PRIMARY KEY AUTOINCREMENT"
+	if db.dbType == "postgres" {
+		idColumn = "SERIAL PRIMARY KEY"
+	}
+	for _, labels := range labelTables {
+		for _, createTable := range []string{`
+			CREATE TABLE IF NOT EXISTS ` + labels.table + ` (
+				id ` + idColumn + `,
+				name TEXT NOT NULL UNIQUE
+			)`, `
+			CREATE TABLE IF NOT EXISTS ` + labels.assignments + ` (
+				activity_id BIGINT NOT NULL,
+				` + labels.column + ` BIGINT NOT NULL,
+				PRIMARY KEY (activity_id, ` + labels.column + `)
+			)`,
+		} {
+			if _, err := db.Exec(createTable); err != nil {
+				return err
+			}
+		}
+	}
+	return db.migrateLabelColumns()
+}
+
+// Move labels out of the projects and tags columns that activities had before
+// the label tables, and drop the columns
+func (db *DB) migrateLabelColumns() error {
+	for _, labels := range labelTables {
+		exists, err := db.hasColumn("activities", labels.table)
+		if err != nil || !exists {
+			return err
+		}
+	}
+
+	rows, err := db.Query(`SELECT id, projects, tags FROM activities WHERE projects != '' OR tags != ''`)
+	if err != nil {
+		return err
+	}
+	var records []ActivityRecord
+	for rows.Next() {
+		var record ActivityRecord
+		var projects, tags string
+		if err := rows.Scan(&record.ID, &projects, &tags); err != nil {
+			rows.Close()
+			return err
+		}
+		record.Projects, record.Tags = splitLabels(projects), splitLabels(tags)
+		records = append(records, record)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, record := range records {
+		if err := db.saveLabels(tx, record.ID, record); err != nil {
+			return err
+		}
+	}
+	for _, labels := range labelTables {
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE activities DROP COLUMN %s", labels.table)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Fill in the projects and tags of activities
+func (db *DB) loadLabels(q querier, records []ActivityRecord) error {
+	for start := 0; start < len(records); start += labelBatchSize {
+		batch := records[start:min(start+labelBatchSize, len(records))]
+		byID := make(map[int64]*ActivityRecord, len(batch))
+		ids := make([]any, len(batch))
+		for i := range batch {
+			byID[batch[i].ID] = &batch[i]
+			ids[i] = batch[i].ID
+		}
+
+		for _, labels := range labelTables {
+			rows, err := q.Query(db.rebind(fmt.Sprintf(`
+				SELECT a.activity_id, l.name
+				FROM %s a JOIN %s l ON l.id = a.%s
+				WHERE a.activity_id IN (%s)
+				ORDER BY l.name
+			`, labels.assignments, labels.table, labels.column, placeholders(len(ids)))), ids...)
+			if err != nil {
+				return fmt.Errorf("error querying %s: %v", labels.table, err)
+			}
+			for rows.Next() {
+				var id int64
+				var name string
+				if err := rows.Scan(&id, &name); err != nil {
+					rows.Close()
+					return fmt.Errorf("error scanning row: %v", err)
+				}
+				field := labels.field(byID[id])
+				*field = append(*field, name)
+			}
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				return fmt.Errorf("error reading rows: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Replace the projects and tags of an activity with the record's
+func (db *DB) saveLabels(q querier, id int64, record ActivityRecord) error {
+	if err := db.deleteLabels(q, id); err != nil {
+		return err
+	}
+	for _, labels := range labelTables {
+		for _, name := range *labels.field(&record) {
+			if err := checkLabel(name); err != nil {
+				return fmt.Errorf("error labeling activity %d: %v", id, err)
+			}
+			labelID, err := db.labelID(q, labels.table, name)
+			if err != nil {
+				return err
+			}
+			if _, err := q.Exec(db.rebind(fmt.Sprintf(`INSERT INTO %s (activity_id, %s) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+				labels.assignments, labels.column)), id, labelID); err != nil {
+				return fmt.Errorf("error labeling activity %d: %v", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (db *DB) deleteLabels(q querier, id int64) error {
+	for _, labels := range labelTables {
+		if _, err := q.Exec(db.rebind(fmt.Sprintf(`DELETE FROM %s WHERE activity_id = ?`, labels.assignments)), id); err != nil {
+			return fmt.Errorf("error deleting labels of activity %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// Get the id of a project or tag, creating it if it's new
+func (db *DB) labelID(q querier, table, name string) (int64, error) {
+	if _, err := q.Exec(db.rebind(fmt.Sprintf(`INSERT INTO %s (name) VALUES (?) ON CONFLICT (name) DO NOTHING`, table)), name); err != nil {
+		return 0, fmt.Errorf("error adding to %s: %v", table, err)
+	}
+	var id int64
+	if err := q.QueryRow(db.rebind(fmt.Sprintf(`SELECT id FROM %s WHERE name = ?`, table)), name).Scan(&id); err != nil {
+		return 0, fmt.Errorf("error querying %s: %v", table, err)
+	}
+	return id, nil
+}
+
+// Get the names of all projects or tags
+func getLabelNames(db *DB, table string) ([]string, error) {
+	rows, err := db.Query(fmt.Sprintf(`SELECT name FROM %s ORDER BY name`, table))
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// Check that a project or tag can be written as one value with others, as in CSV exports
+func checkLabel(name string) error {
+	if strings.Contains(name, labelSeparator) {
+		return fmt.Errorf("%q: projects and tags can't contain %q", name, labelSeparator)
+	}
+	return nil
+}
+
+func joinLabels(labels []string) string {
+	return strings.Join(labels, labelSeparator)
+}
+
+func splitLabels(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, labelSeparator)
+}
+
+func sameLabels(a, b ActivityRecord) bool {
+	sameSet := func(a, b []string) bool {
+		a, b = slices.Clone(a), slices.Clone(b)
+		slices.Sort(a)
+		slices.Sort(b)
+		return slices.Equal(a, b)
+	}
+	return sameSet(a.Projects, b.Projects) && sameSet(a.Tags, b.Tags)
+}
+
+// Add a label to a sorted list of labels, keeping it sorted
+func addLabel(labels []string, label string) []string {
+	i, found := slices.BinarySearch(labels, label)
+	if found {
+		return labels
+	}
+	return slices.Insert(slices.Clone(labels), i, label)
+}
+
+func removeLabel(labels []string, label string) []string {
+	return slices.DeleteFunc(slices.Clone(labels), func(l string) bool { return l == label })
+}
+
+func formatLabels(record ActivityRecord) string {
+	var labels []string
+	for _, project := range record.Projects {
+		labels = append(labels, "@"+project)
+	}
+	for _, tag := range record.Tags {
+		labels = append(labels, "#"+tag)
+	}
+	return strings.Join(labels, " ")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Labels are stored as one value joined by the separator, so they split back into
+// the same labels, and labels containing the separator are rejected
+func TestJoinAndSplitLabels(t *testing.T) {
+	for _, tt := range []struct {
+		labels []string
+		joined string
+	}{
+		{nil, ""},
+		{[]string{"activitymon"}, "activitymon"},
+		{[]string{"activitymon", "client work", "deep-work"}, "activitymon" + labelSeparator + "client work" + labelSeparator + "deep-work"},
+	} {
+		if got := joinLabels(tt.labels); got != tt.joined {
+			t.Errorf("joinLabels(%q) = %q, want %q", tt.labels, got, tt.joined)
+		}
+		if got := splitLabels(tt.joined); !slices.Equal(got, tt.labels) {
+			t.Errorf("splitLabels(%q) = %q, want %q", tt.joined, got, tt.labels)
+		}
+	}
+
+	db := openTestDb(t)
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour)
+	for _, record := range []ActivityRecord{
+		{StartTime: start, EndTime: &end, Name: "Code", Projects: []string{"a" + labelSeparator + "b"}},
+		{StartTime: start, EndTime: &end, Name: "Code", Tags: []string{labelSeparator}},
+	} {
+		if _, err := db.insertActivity(db, record); err == nil || !strings.Contains(err.Error(), "can't contain") {
+			t.Errorf("saving labels %q and %q gave %v, want an error", record.Projects, record.Tags, err)
+		}
+	}
+}
+
+// Labeling blocks in the review saves the labels of all their activities as one
+// edit, which can be undone
+func TestReviewLabelBlocks(t *testing.T) {
+	db := openTestDb(t)
+	day := time.Date(2024, 11, 5, 0, 0, 0, 0, time.Local)
+	at := func(hour, minute int) *time.Time {
+		t := day.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+		return &t
+	}
+	for _, record := range []ActivityRecord{
+		{StartTime: *at(9, 0), EndTime: at(9, 30), Name: "Code"},
+		{StartTime: *at(9, 32), EndTime: at(10, 0), Name: "Code", Tags: []string{"deep-work"}},
+		{StartTime: *at(10, 0), EndTime: at(10, 15), Name: "Slack"},
+	} {
+		if _, err := db.insertActivity(db, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	labelsOf := func() []string {
+		t.Helper()
+		records, err := getStoredActivities(db, day, day.AddDate(0, 0, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var labels []string
+		for _, record := range records {
+			labels = append(labels, record.Name+" "+joinLabels(record.Projects)+" "+joinLabels(record.Tags))
+		}
+		return labels
+	}
+
+	screen := newReviewScreen(db, day)
+	if err := screen.load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(screen.blocks) != 2 {
+		t.Fatalf("got %d blocks, want 2", len(screen.blocks))
+	}
+	screen.setProject(screen.blocks[0], "activitymon")
+	screen.toggleTag(screen.blocks[0], "review")
+	// the tag is added to each activity's own tags
+	want := []string{"Code activitymon review", "Code activitymon deep-work" + labelSeparator + "review", "Slack  "}
+	if got := labelsOf(); !slices.Equal(got, want) {
+		t.Errorf("after labeling got %q, want %q", got, want)
+	}
+	if len(screen.projects) != 1 || screen.projects[0] != "activitymon" {
+		t.Errorf("got projects %q after reloading", screen.projects)
+	}
+
+	// A label with the separator isn't saved, and the error is shown
+	screen.setProject(screen.blocks[1], "a"+labelSeparator+"b")
+	if status := screen.statusView.GetText(true); !strings.Contains(status, "can't contain") {
+		t.Errorf("got status %q, want the label error", status)
+	}
+	if got := labelsOf(); !slices.Equal(got, want) {
+		t.Errorf("after an invalid label got %q, want %q", got, want)
+	}
+
+	// Undoing the tag brings back the project without it
+	if _, err := undoEdit(db, 0); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"Code activitymon ", "Code activitymon deep-work", "Slack  "}
+	if got := labelsOf(); !slices.Equal(got, want) {
+		t.Errorf("after undoing got %q, want %q", got, want)
+	}
+}
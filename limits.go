@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const defaultNotificationCooldown = 15 * time.Minute
+
+type limitNotification struct {
+	Rule    NotificationRule
+	Message string
+}
+
+// Keeps track of how long configured limits have been exceeded, and decides when to
+// notify. Notifications for a rule are rate-limited by the rule's cooldown.
+type limitWatcher struct {
+	rules        []NotificationRule
+	categories   map[string][]string
+	breakAfter   time.Duration
+	matchSince   map[string]time.Time
+	lastFired    map[string]time.Time
+	workingSince time.Time
+	lastActive   time.Time
+}
+
+func newLimitWatcher(cfg *Config) *limitWatcher {
+	breakAfter := time.Duration(cfg.Notifications.BreakMinutes) * time.Minute
+	if breakAfter <= 0 {
+		breakAfter = 5 * time.Minute
+	}
+	return &limitWatcher{
+		rules:      cfg.Notifications.Rules,
+		categories: cfg.Categories,
+		breakAfter: breakAfter,
+		matchSince: make(map[string]time.Time),
+		lastFired:  make(map[string]time.Time),
+	}
+}
+
+// Create a watcher for a reloaded config that carries over how long rules have been
+// exceeded and when they last notified, so a reload doesn't notify again right away
+func (w *limitWatcher) reload(cfg *Config) *limitWatcher {
+	reloaded := newLimitWatcher(cfg)
+	reloaded.workingSince, reloaded.lastActive = w.workingSince, w.lastActive
+	for i, rule := range reloaded.rules {
+		key := fmt.Sprintf("%d:%s", i, rule.Name)
+		if since, ok := w.matchSince[key]; ok {
+			reloaded.matchSince[key] = since
+		}
+		if lastFired, ok := w.lastFired[key]; ok {
+			reloaded.lastFired[key] = lastFired
+		}
+	}
+	return reloaded
+}
+
+// Record the current activity ("" when away) and return any limits that were crossed
+func (w *limitWatcher) observe(now time.Time, activityName string) []limitNotification {
+	if activityName != "" {
+		if w.workingSince.IsZero() || now.Sub(w.lastActive) >= w.breakAfter {
+			w.workingSince = now
+		}
+		w.lastActive = now
+	}
+
+	var notifications []limitNotification
+	for i, rule := range w.rules {
+		key := fmt.Sprintf("%d:%s", i, rule.Name)
+		limit := time.Duration(rule.Minutes) * time.Minute
+
+		var since time.Time
+		switch rule.Kind {
+		case "continuous":
+			if !w.ruleMatches(rule, activityName) {
+				delete(w.matchSince, key)
+				continue
+			}
+			if _, ok := w.matchSince[key]; !ok {
+				w.matchSince[key] = now
+			}
+			since = w.matchSince[key]
+		case "no-break":
+			if activityName == "" {
+				continue
+			}
+			since = w.workingSince
+		default:
+			continue
+		}
+
+		if now.Sub(since) < limit || !w.cooledDown(key, rule, now) {
+			continue
+		}
+		w.lastFired[key] = now
+		notifications = append(notifications, limitNotification{
+			Rule:    rule,
+			Message: limitMessage(rule, activityName, now.Sub(since)),
+		})
+	}
+
+	return notifications
+}
+
+func (w *limitWatcher) ruleMatches(rule NotificationRule, activityName string) bool {
+	if activityName == "" {
+		return false
+	}
+	if rule.Activity != "" && activityMatches(rule.Activity, activityName) {
+		return true
+	}
+	return rule.Category != "" && categorize(w.categories, activityName) == rule.Category
+}
+
+func (w *limitWatcher) cooledDown(key string, rule NotificationRule, now time.Time) bool {
+	lastFired, ok := w.lastFired[key]
+	if !ok {
+		return true
+	}
+	cooldown := defaultNotificationCooldown
+	if rule.CooldownMinutes > 0 {
+		cooldown = time.Duration(rule.CooldownMinutes) * time.Minute
+	}
+	return now.Sub(lastFired) >= cooldown
+}
+
+func limitMessage(rule NotificationRule, activityName string, elapsed time.Duration) string {
+	if rule.Kind == "no-break" {
+		return fmt.Sprintf("You've been working for %s without a break", formatTime(elapsed))
+	}
+	return fmt.Sprintf("You've spent %s on %s", formatTime(elapsed), activityName)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Write a notifier command that appends its arguments and environment to a file
+func stubNotifyCommand(t *testing.T) (command []string, output string) {
+	t.Helper()
+	dir := t.TempDir()
+	output = filepath.Join(dir, "notifications")
+	script := filepath.Join(dir, "notify")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+printf '%s|%s|%s\n' "$1" "$2" "$ACTIVITYMON_TITLE" >> "`+output+`"
+`), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []string{script}, output
+}
+
+func TestLimitsNotifyThroughCommand(t *testing.T) {
+	command, output := stubNotifyCommand(t)
+	cfg := &Config{
+		Categories: map[string][]string{"Distracting": {"youtube.com"}},
+		Notifications: NotificationsConfig{
+			Notifier:     NotifierConfig{Type: "command", Command: command},
+			BreakMinutes: 5,
+			Rules: []NotificationRule{
+				{Name: "Distracted", Kind: "continuous", Category: "Distracting", Minutes: 20},
+				{Name: "Take a break", Kind: "no-break", Minutes: 90, CooldownMinutes: 30},
+			},
+		},
+	}
+	notifier, err := newNotifier(cfg.Notifications.Notifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := newLimitWatcher(cfg)
+
+	activityAt := func(minute int) string {
+		switch {
+		case minute < 30 || (minute > 30 && minute < 60):
+			return "www.youtube.com"
+		case minute >= 110 && minute < 116:
+			return "" // away long enough for a break
+		default:
+			return "Code"
+		}
+	}
+
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	var fired, want []string
+	for minute := 0; minute <= 230; minute++ {
+		for _, n := range limits.observe(start.Add(time.Duration(minute)*time.Minute), activityAt(minute)) {
+			fired = append(fired, fmt.Sprintf("%d %s", minute, n.Rule.Name))
+			if err := notifier.Notify("activitymon: "+n.Rule.Name, n.Message); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, "activitymon: "+n.Rule.Name+"|"+n.Message+"|activitymon: "+n.Rule.Name)
+		}
+	}
+	// Distracted again once the cooldown is over and the continuous time restarted
+	// after switching away, and the break resets the no-break time
+	wantFired := []string{"20 Distracted", "51 Distracted", "90 Take a break", "206 Take a break"}
+	if strings.Join(fired, ", ") != strings.Join(wantFired, ", ") {
+		t.Errorf("got %v, want %v", fired, wantFired)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Split(strings.TrimSpace(string(data)), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("command got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if !strings.Contains(want[0], "You've spent 0h 20m 0s on www.youtube.com") {
+		t.Errorf("unexpected message %q", want[0])
+	}
+}
+
+func TestCommandNotifierError(t *testing.T) {
+	notifier, err := newNotifier(NotifierConfig{Type: "command", Command: []string{"sh", "-c", "echo broken >&2; exit 3", "notify"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = notifier.Notify("title", "message")
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("got %v, want the command's stderr in the error", err)
+	}
+}
+
+func TestLimitsKeepStateOnReload(t *testing.T) {
+	cfg := &Config{Notifications: NotificationsConfig{Rules: []NotificationRule{
+		{Name: "Video", Kind: "continuous", Activity: "youtube.com", Minutes: 20},
+	}}}
+	limits := newLimitWatcher(cfg)
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	var fired []int
+	for minute := 0; minute <= 40; minute++ {
+		if minute == 10 || minute == 25 {
+			limits = limits.reload(cfg)
+		}
+		if len(limits.observe(start.Add(time.Duration(minute)*time.Minute), "youtube.com")) > 0 {
+			fired = append(fired, minute)
+		}
+	}
+	if fmt.Sprint(fired) != "[20 35]" {
+		t.Errorf("notified after %v minutes, want [20 35]", fired)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// An exclusive lock that keeps more than one monitor from writing to the same
+// database. The lock file holds the PID of the owning process.
+type instanceLock struct {
+	file *os.File
+	path string
+}
+
+// Get the path of the lock file for the configured database
+func getLockPath(cfg *Config) (string, error) {
+	switch cfg.Database.Type {
+	case "sqlite":
+		dbPath, err := getSqlitePath(cfg.Database)
+		if err != nil {
+			return "", err
+		}
+		return dbPath + ".lock", nil
+	case "postgres":
+		configDir, err := getConfigDir()
+		if err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256([]byte(cfg.Database.PostgresConnStr))
+		return filepath.Join(configDir, fmt.Sprintf("postgres-%x.lock", sum[:8])), nil
+	default:
+		return "", fmt.Errorf("unsupported database type: %s", cfg.Database.Type)
+	}
+}
+
+func acquireInstanceLock(path string) (*instanceLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock file: %v", err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer file.Close()
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("error locking %s: %v", path, err)
+		}
+
+		pid := readLockPid(file)
+		if pid > 0 && !processExists(pid) {
+			return nil, fmt.Errorf("lock %s is held for pid %d, which is no longer running; remove the file if no monitor is running", path, pid)
+		}
+		return nil, fmt.Errorf("another monitor is already running for this database (pid %d)", pid)
+	}
+
+	// a PID left over from a monitor that exited without cleaning up is stale,
+	// since the lock itself is released when its process dies
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error writing lock file: %v", err)
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error writing lock file: %v", err)
+	}
+
+	return &instanceLock{file: file, path: path}, nil
+}
+
+// Clear the PID and unlock. The file is left in place: removing it would let another
+// process lock a new file at the same path while a third still holds the old one.
+func (l *instanceLock) Release() {
+	l.file.Truncate(0)
+	syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+}
+
+func readLockPid(file *os.File) int {
+	buf := make([]byte, 32)
+	n, _ := file.ReadAt(buf, 0)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestInstanceLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracker.db.lock")
+	lock, err := acquireInstanceLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("lock file holds %q, %v, want this process's pid", data, err)
+	}
+
+	// a second monitor can't lock it while the first holds it
+	if second, err := acquireInstanceLock(path); err == nil {
+		second.Release()
+		t.Fatal("acquired the lock twice")
+	} else if !strings.Contains(err.Error(), "already running") || !strings.Contains(err.Error(), strconv.Itoa(os.Getpid())) {
+		t.Errorf("got %v, want the running monitor's pid", err)
+	}
+
+	// releasing leaves the file in place, without the pid
+	lock.Release()
+	if data, err := os.ReadFile(path); err != nil || len(data) != 0 {
+		t.Errorf("after release the lock file holds %q, %v, want it empty", data, err)
+	}
+
+	second, err := acquireInstanceLock(path)
+	if err != nil {
+		t.Fatalf("got %v, want the lock after it was released", err)
+	}
+	second.Release()
+}
+
+// A pid left in the file by a monitor that exited without releasing its lock
+// doesn't keep another from starting
+func TestInstanceLockWithStalePid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracker.db.lock")
+	if err := os.WriteFile(path, []byte("999999999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := acquireInstanceLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+	if data, err := os.ReadFile(path); err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("lock file holds %q, %v, want this process's pid", data, err)
+	}
+}
+
+// Each database gets its own lock
+func TestGetLockPath(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	paths := make(map[string]bool)
+	for _, db := range []DatabaseConfig{
+		{Type: "sqlite"},
+		{Type: "sqlite", SqlitePath: filepath.Join(dir, "work.db")},
+		{Type: "postgres", PostgresConnStr: "postgres://localhost/a"},
+		{Type: "postgres", PostgresConnStr: "postgres://localhost/b"},
+	} {
+		path, err := getLockPath(&Config{Database: db})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if paths[path] || !strings.HasSuffix(path, ".lock") {
+			t.Errorf("%+v: got %s", db, path)
+		}
+		paths[path] = true
+	}
+	if _, err := getLockPath(&Config{Database: DatabaseConfig{Type: "mysql"}}); err == nil {
+		t.Error("got no error for an unsupported database")
+	}
+}
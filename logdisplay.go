@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// The tview color tags log entries are written with, and text from activities and
+// the config as tview.Escape escapes it
+var (
+	colorTagRegexp   = regexp.MustCompile(`\[(red|yellow|green|white|cyan|gray|lightblue|magenta)\]`)
+	escapedTagRegexp = regexp.MustCompile(`(\[[a-zA-Z0-9_,;: \-\."#]+\[*)\[\]`)
+)
+
+// LogDisplay writes the activity log as structured logs instead of showing the
+// terminal UI, for running the monitor headless as a service or over SSH
+type LogDisplay struct {
+	logger *slog.Logger
+}
+
+func NewLogDisplay(w io.Writer, format string) (*LogDisplay, error) {
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(w, nil)
+	case "json":
+		handler = slog.NewJSONHandler(w, nil)
+	default:
+		return nil, fmt.Errorf("unsupported log format: %s", format)
+	}
+	return &LogDisplay{logger: slog.New(handler)}, nil
+}
+
+func (d *LogDisplay) Start() error {
+	d.logger.Info("Monitor started")
+	return nil
+}
+
+func (d *LogDisplay) Stop() {
+	d.logger.Info("Monitor stopped")
+}
+
+// Log entries use the same tview color tags as the terminal UI; red entries are
+// logged as errors and yellow entries as warnings
+func (d *LogDisplay) AddLogEntry(entry string) {
+	level := slog.LevelInfo
+	switch {
+	case strings.HasPrefix(entry, "[red]"):
+		level = slog.LevelError
+	case strings.HasPrefix(entry, "[yellow]"):
+		level = slog.LevelWarn
+	}
+	entry = colorTagRegexp.ReplaceAllString(entry, "")
+	d.logger.Log(context.Background(), level, escapedTagRegexp.ReplaceAllString(entry, "$1]"))
+}
+
+// Live statistics are only shown in the terminal UI
+func (d *LogDisplay) UpdateStats(stats string) {}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/rivo/tview"
+)
+
+func TestLogDisplay(t *testing.T) {
+	var buf bytes.Buffer
+	display, err := NewLogDisplay(&buf, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []string{
+		"[green]2024-11-05 09:00:00 Started activity: github.com[white]",
+		"[red]Error inserting activity: disk full[white]",
+		"[yellow]Tracking paused[white]",
+		"Started activity: [wip] fix " + tview.Escape("[red] team [page]"),
+		"Received signal: interrupt",
+	} {
+		display.AddLogEntry(entry)
+	}
+	display.UpdateStats("ignored")
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry struct{ Level, Msg string }
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		got = append(got, entry.Level+" "+entry.Msg)
+	}
+	want := []string{
+		"INFO 2024-11-05 09:00:00 Started activity: github.com",
+		"ERROR Error inserting activity: disk full",
+		"WARN Tracking paused",
+		// only color tags are removed, and escaped text is kept as it was
+		"INFO Started activity: [wip] fix [red] team [page]",
+		"INFO Received signal: interrupt",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLogDisplayFormats(t *testing.T) {
+	var buf bytes.Buffer
+	display, err := NewLogDisplay(&buf, "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	display.AddLogEntry("[yellow]Configuration reloaded[white]")
+	if got := buf.String(); !strings.Contains(got, `level=WARN msg="Configuration reloaded"`) {
+		t.Errorf("got %q", got)
+	}
+	if _, err := NewLogDisplay(&buf, "xml"); err == nil {
+		t.Error("got no error for an unsupported format")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/urfave/cli/v2"
@@ -14,8 +15,27 @@ func main() {
 		Usage: "Simple activity tracker for Mac OS",
 		Commands: []*cli.Command{
 			{
-				Name:   "monitor",
-				Usage:  "Run the activity monitor",
+				Name:  "monitor",
+				Usage: "Run the activity monitor",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "headless",
+						Usage: "Run without the terminal UI, writing logs instead",
+					},
+					&cli.StringFlag{
+						Name:  "log-file",
+						Usage: "File to write logs to in headless mode (default: stderr)",
+					},
+					&cli.StringFlag{
+						Name:  "log-format",
+						Usage: "Log format in headless mode: text or json",
+						Value: "text",
+					},
+					&cli.StringFlag{
+						Name:  "metrics-addr",
+						Usage: "Address to serve Prometheus metrics on, e.g. 127.0.0.1:9321",
+					},
+				},
 				Action: monitorCmd,
 			},
 			{
@@ -27,9 +47,65 @@ func main() {
 						Usage: "Number of minutes to summarize",
 						Value: 240,
 					},
+					&cli.StringFlag{
+						Name:  "group-by",
+						Usage: "Group time by activity, category, device, hour, day, language, file, title or meeting",
+						Value: "activity",
+					},
+					&cli.BoolFlag{
+						Name:  "by-device",
+						Usage: "Show a separate summary for each device",
+					},
 				},
 				Action: summaryCmd,
 			},
+			{
+				Name:   "status",
+				Usage:  "Show the running monitor's current activity and session totals",
+				Action: statusCmd,
+			},
+			{
+				Name:      "pause",
+				Usage:     "Pause tracking in the running monitor",
+				ArgsUsage: "[duration]",
+				Action:    pauseCmd,
+			},
+			{
+				Name:   "resume",
+				Usage:  "Resume tracking in the running monitor",
+				Action: resumeCmd,
+			},
+			{
+				Name:   "reload-config",
+				Usage:  "Reload the configuration in the running monitor",
+				Action: reloadConfigCmd,
+			},
+			{
+				Name:  "serve",
+				Usage: "Serve a web dashboard and HTTP API for activity data",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "addr",
+						Usage: "Address to listen on",
+						Value: "127.0.0.1:8321",
+					},
+				},
+				Action: serveCmd,
+			},
+			exportCmd(),
+			importCmd(),
+			dbCmd(),
+			syncCmd(),
+			addCmd(),
+			editCmd(),
+			reviewCmd(),
+			timesheetCmd(),
+			projectsCmd(),
+			tagsCmd(),
+			shellHookCmd(),
+			shellEventCmd(),
+			calendarCmd(),
+			serviceCmd(),
 			configCmd(),
 		},
 	}
@@ -39,3 +115,14 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// Flags are only parsed before a command's arguments. Catch flags given after them,
+// which would otherwise be taken for arguments, e.g. an entry named "--from".
+func checkTrailingFlags(c *cli.Context) error {
+	for _, arg := range c.Args().Slice() {
+		if len(arg) > 1 && strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("%s must come before the arguments", arg)
+		}
+	}
+	return nil
+}
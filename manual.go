@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+const manualSource = "manual"
+
+func addCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "add",
+		Usage:     "Add a manual entry, e.g. for a meeting or call away from the computer",
+		ArgsUsage: "<name>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "from",
+				Usage:    "Start time, e.g. 14:00 or \"2024-11-05 14:00\"",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "to",
+				Usage: "End time (default: now)",
+			},
+			&cli.StringFlag{
+				Name:  "category",
+				Usage: "Category of the entry, one of the categories in the config (default: by name)",
+			},
+			&cli.BoolFlag{
+				Name:  "override",
+				Usage: "Replace tracked activities during the entry, instead of only filling the time around them",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if err := checkTrailingFlags(c); err != nil {
+				return err
+			}
+			if c.NArg() != 1 {
+				return fmt.Errorf("expected the name of the entry")
+			}
+			now := time.Now()
+			start, err := parseTimeParam(c.String("from"), now)
+			if err != nil {
+				return fmt.Errorf("invalid from: %v", err)
+			}
+			end := now
+			if value := c.String("to"); value != "" {
+				if end, err = parseTimeParam(value, now); err != nil {
+					return fmt.Errorf("invalid to: %v", err)
+				}
+			}
+
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			name, category := c.Args().First(), c.String("category")
+			if _, ok := cfg.Categories[category]; category != "" && !ok {
+				return fmt.Errorf("unknown category %q; add it to categories in the config first", category)
+			}
+
+			db, err := getDb()
+			if err != nil {
+				return fmt.Errorf("error connecting to database: %v", err)
+			}
+			defer db.Close()
+
+			editor, err := beginEdit(db, strings.Join(os.Args[1:], " "))
+			if err != nil {
+				return err
+			}
+			defer editor.rollback()
+			pieces, replaced, err := addManualActivity(editor, ActivityRecord{
+				StartTime: start.Truncate(time.Second),
+				EndTime:   &end,
+				Name:      name,
+				Category:  category,
+			}, c.Bool("override"))
+			if err != nil {
+				return err
+			}
+			if err := editor.commit(); err != nil {
+				return err
+			}
+			for _, record := range replaced {
+				fmt.Printf("Replaced %s from %s to %s\n", record.Name, record.StartTime.Format("15:04:05"),
+					record.endOr(editor.now).Format("15:04:05"))
+			}
+
+			var added time.Duration
+			for _, piece := range pieces {
+				added += piece.EndTime.Sub(piece.StartTime)
+			}
+			fmt.Printf("Added %s from %s to %s", name, start.Format("2006-01-02 15:04"), end.Format("15:04"))
+			if added != end.Sub(start) {
+				fmt.Printf(", %s of it around tracked activities", formatTime(added))
+			}
+			fmt.Printf(" (edit %d)\n", editor.editID)
+			return nil
+		},
+	}
+}
+
+// Record a manual entry. It fills the time where nothing was tracked, e.g. while the
+// computer was locked or asleep; with override, this device's tracked activities
+// during it are trimmed, split or deleted to make way for it instead, as part of the
+// same edit, so undoing the entry brings them back. Returns the parts of the entry
+// that were added, and the activities that were cut.
+func addManualActivity(editor *activityEditor, record ActivityRecord, override bool) (pieces, replaced []ActivityRecord, err error) {
+	now := editor.now
+	end := record.EndTime.Truncate(time.Second)
+	record.EndTime = &end
+	if !record.EndTime.After(record.StartTime) {
+		return nil, nil, fmt.Errorf("the entry must end after it starts")
+	}
+	if record.EndTime.After(now) {
+		return nil, nil, fmt.Errorf("the entry can't end in the future")
+	}
+	device, err := getDevice()
+	if err != nil {
+		return nil, nil, err
+	}
+	record.Source = manualSource
+	record.DeviceID, record.Hostname = device.ID, device.Hostname
+
+	overlapping, err := editor.activities(record.StartTime, *record.EndTime)
+	if err != nil {
+		return nil, nil, err
+	}
+	if override {
+		var kept []ActivityRecord
+		for _, other := range overlapping {
+			if other.DeviceID != device.ID && other.DeviceID != "" {
+				kept = append(kept, other)
+				continue
+			}
+			if err := editor.cut(other, record.StartTime, *record.EndTime); err != nil {
+				return nil, nil, err
+			}
+			replaced = append(replaced, other)
+		}
+		overlapping = kept
+	}
+
+	pieces = subtractActivities(record, overlapping, now)
+	for _, piece := range pieces {
+		if _, err := editor.insert(piece); err != nil {
+			return nil, nil, err
+		}
+	}
+	return pieces, replaced, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// An entry that overrides tracked activities is logged with them, so undoing it
+// brings them back
+func TestAddManualOverrideCanBeUndone(t *testing.T) {
+	db := openTestDb(t)
+	device, err := getDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	at := func(minutes int) *time.Time {
+		t := start.Add(time.Duration(minutes) * time.Minute)
+		return &t
+	}
+	for _, record := range []ActivityRecord{
+		{StartTime: *at(0), EndTime: at(30), Name: "Code", DeviceID: device.ID},
+		{StartTime: *at(30), EndTime: at(45), Name: "Slack", DeviceID: device.ID},
+		{StartTime: *at(45), EndTime: at(90), Name: "github.com", DeviceID: device.ID},
+	} {
+		if _, err := db.insertActivity(db, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, err := getActivities(db, *at(0), *at(90))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	editor, err := beginEdit(db, "add --override")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pieces, replaced, err := addManualActivity(editor, ActivityRecord{StartTime: *at(20), EndTime: at(60), Name: "Design review", Category: "Meetings"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := editor.commit(); err != nil {
+		t.Fatal(err)
+	}
+	if len(pieces) != 1 || len(replaced) != 3 {
+		t.Fatalf("got %d pieces and %d replaced activities, want 1 and 3", len(pieces), len(replaced))
+	}
+	summary, err := summarizeActivities(mustGetActivities(t, db, *at(0), *at(90)), nil, SummaryOptions{
+		Start: *at(0), End: *at(90), GroupBy: "category", Categories: map[string][]string{"Meetings": {}, "Work": {"Code", "Slack", "github.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Activities) != 2 || summary.Activities[0].Name != "Work" || summary.Activities[1].Name != "Meetings" ||
+		summary.Activities[1].Duration != 40*time.Minute {
+		t.Errorf("got %+v, want 40m in meetings", summary.Activities)
+	}
+
+	changes, err := getEditChanges(db, db, editor.editID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := 0
+	for _, change := range changes {
+		if change.after == nil {
+			deleted++
+		}
+	}
+	if deleted != 1 {
+		t.Errorf("got %d deletions in the edit log, want the activity covered by the entry", deleted)
+	}
+
+	if _, err := undoEdit(db, editor.editID); err != nil {
+		t.Fatal(err)
+	}
+	after := mustGetActivities(t, db, *at(0), *at(90))
+	if len(after) != len(before) {
+		t.Fatalf("got %+v, want %+v", after, before)
+	}
+	for i := range after {
+		if !sameActivity(after[i], before[i]) {
+			t.Errorf("got %+v, want %+v", after[i], before[i])
+		}
+	}
+}
+
+// Overriding splits an activity that spans the entry and leaves other devices'
+// activities alone, filling around them, and undoing it joins the activity again
+func TestAddManualOverrideSplitsActivity(t *testing.T) {
+	db := openTestDb(t)
+	device, err := getDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	at := func(minutes int) *time.Time {
+		t := start.Add(time.Duration(minutes) * time.Minute)
+		return &t
+	}
+	var codeID int64
+	for _, record := range []ActivityRecord{
+		{StartTime: *at(0), EndTime: at(120), Name: "Code", DeviceID: device.ID},
+		{StartTime: *at(40), EndTime: at(50), Name: "Slack", DeviceID: "other-device", Hostname: "desktop"},
+	} {
+		id, err := db.insertActivity(db, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.Name == "Code" {
+			codeID = id
+		}
+	}
+	before := mustGetActivities(t, db, *at(0), *at(120))
+
+	editor, err := beginEdit(db, "add --override")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pieces, replaced, err := addManualActivity(editor, ActivityRecord{StartTime: *at(30), EndTime: at(60), Name: "Standup"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := editor.commit(); err != nil {
+		t.Fatal(err)
+	}
+	if len(replaced) != 1 || replaced[0].ID != codeID {
+		t.Errorf("got replaced %+v, want only this device's activity", replaced)
+	}
+	if len(pieces) != 2 {
+		t.Errorf("got %d pieces, want the entry around the other device's activity", len(pieces))
+	}
+
+	var got []string
+	for _, record := range mustGetActivities(t, db, *at(0), *at(120)) {
+		got = append(got, fmt.Sprintf("%s %s–%s", record.Name, record.StartTime.Format("15:04"), record.EndTime.Format("15:04")))
+		if record.Name == "Code" && record.StartTime.Equal(*at(60)) && record.ID != codeID {
+			t.Errorf("the later part of the split activity has id %d, want %d", record.ID, codeID)
+		}
+	}
+	want := "Code 09:00–09:30, Standup 09:30–09:40, Slack 09:40–09:50, Standup 09:50–10:00, Code 10:00–11:00"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, ", "), want)
+	}
+
+	if _, err := undoEdit(db, editor.editID); err != nil {
+		t.Fatal(err)
+	}
+	after := mustGetActivities(t, db, *at(0), *at(120))
+	if len(after) != len(before) {
+		t.Fatalf("got %+v, want %+v", after, before)
+	}
+	for i := range after {
+		if !sameActivity(after[i], before[i]) {
+			t.Errorf("got %+v, want %+v", after[i], before[i])
+		}
+	}
+}
+
+func TestAddRejectsTrailingFlags(t *testing.T) {
+	app := &cli.App{Commands: []*cli.Command{addCmd()}}
+	err := app.Run([]string{"activitymon", "add", "--from", "14:00", "Design review", "--to", "15:00"})
+	if err == nil || !strings.Contains(err.Error(), "--to must come before the arguments") {
+		t.Errorf("got %v, want the misplaced flag named", err)
+	}
+}
+
+func mustGetActivities(t *testing.T, db *DB, since, until time.Time) []ActivityRecord {
+	t.Helper()
+	records, err := getActivities(db, since, until)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var dbWriteLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// Metrics collected by the running monitor, exposed in the Prometheus text format
+type monitorMetrics struct {
+	mu              sync.Mutex
+	trackedSeconds  map[[2]string]float64 // by app and category
+	contextSwitches float64
+	collectorErrors map[string]float64 // by collector
+	dbWrites        map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func newMonitorMetrics() *monitorMetrics {
+	return &monitorMetrics{
+		trackedSeconds:  make(map[[2]string]float64),
+		collectorErrors: make(map[string]float64),
+		dbWrites:        make(map[string]*histogram),
+	}
+}
+
+func (m *monitorMetrics) addTrackedTime(app, category string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.trackedSeconds[[2]string{app, category}] += d.Seconds()
+}
+
+func (m *monitorMetrics) incContextSwitches() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.contextSwitches++
+}
+
+func (m *monitorMetrics) incCollectorErrors(collector string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collectorErrors[collector]++
+}
+
+func (m *monitorMetrics) observeDBWrite(operation string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.dbWrites[operation]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(dbWriteLatencyBuckets))}
+		m.dbWrites[operation] = h
+	}
+	seconds := d.Seconds()
+	for i, bound := range dbWriteLatencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (m *monitorMetrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP activitymon_tracked_seconds_total Time tracked per app and category.")
+	fmt.Fprintln(w, "# TYPE activitymon_tracked_seconds_total counter")
+	tracked := make([][2]string, 0, len(m.trackedSeconds))
+	for key := range m.trackedSeconds {
+		tracked = append(tracked, key)
+	}
+	sort.Slice(tracked, func(i, j int) bool {
+		return tracked[i][0] < tracked[j][0] || (tracked[i][0] == tracked[j][0] && tracked[i][1] < tracked[j][1])
+	})
+	for _, key := range tracked {
+		fmt.Fprintf(w, "activitymon_tracked_seconds_total{app=%s,category=%s} %g\n",
+			promLabel(key[0]), promLabel(key[1]), m.trackedSeconds[key])
+	}
+
+	fmt.Fprintln(w, "# HELP activitymon_context_switches_total Number of times the current activity changed.")
+	fmt.Fprintln(w, "# TYPE activitymon_context_switches_total counter")
+	fmt.Fprintf(w, "activitymon_context_switches_total %g\n", m.contextSwitches)
+
+	fmt.Fprintln(w, "# HELP activitymon_collector_errors_total Errors getting window or browser information.")
+	fmt.Fprintln(w, "# TYPE activitymon_collector_errors_total counter")
+	for _, collector := range sortedKeys(m.collectorErrors) {
+		fmt.Fprintf(w, "activitymon_collector_errors_total{collector=%s} %g\n", promLabel(collector), m.collectorErrors[collector])
+	}
+
+	fmt.Fprintln(w, "# HELP activitymon_db_write_duration_seconds Latency of database writes.")
+	fmt.Fprintln(w, "# TYPE activitymon_db_write_duration_seconds histogram")
+	for _, operation := range sortedKeys(m.dbWrites) {
+		h := m.dbWrites[operation]
+		var cumulative uint64
+		for i, bound := range dbWriteLatencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "activitymon_db_write_duration_seconds_bucket{operation=%s,le=\"%g\"} %d\n", promLabel(operation), bound, cumulative)
+		}
+		fmt.Fprintf(w, "activitymon_db_write_duration_seconds_bucket{operation=%s,le=\"+Inf\"} %d\n", promLabel(operation), h.count)
+		fmt.Fprintf(w, "activitymon_db_write_duration_seconds_sum{operation=%s} %g\n", promLabel(operation), h.sum)
+		fmt.Fprintf(w, "activitymon_db_write_duration_seconds_count{operation=%s} %d\n", promLabel(operation), h.count)
+	}
+}
+
+func metricsHandler(m *monitorMetrics) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.writeTo(w)
+	})
+	return mux
+}
+
+// Serve /metrics until the context is cancelled
+func startMetricsServer(ctx context.Context, addr string, m *monitorMetrics) error {
+	server := &http.Server{Addr: addr, Handler: metricsHandler(m)}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.ListenAndServe()
+	}()
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	// report errors like the address being in use right away
+	select {
+	case err := <-errChan:
+		return fmt.Errorf("error serving metrics: %v", err)
+	case <-time.After(100 * time.Millisecond):
+		return nil
+	}
+}
+
+// Quote a Prometheus label value
+func promLabel(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The metrics are served in the Prometheus text format, with cumulative
+// histogram buckets and escaped label values
+func TestMetricsHandler(t *testing.T) {
+	m := newMonitorMetrics()
+	m.addTrackedTime("Code", "development", 90*time.Second)
+	m.addTrackedTime(`say "hi" \ bye`, "line\nbreak", time.Minute)
+	m.incContextSwitches()
+	m.incContextSwitches()
+	m.incCollectorErrors("browser")
+	for _, d := range []time.Duration{500 * time.Microsecond, 3 * time.Millisecond, 4 * time.Millisecond, 200 * time.Millisecond, 5 * time.Second} {
+		m.observeDBWrite("insert", d)
+	}
+
+	response := httptest.NewRecorder()
+	metricsHandler(m).ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("got status %d", response.Code)
+	}
+	if contentType := response.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("got content type %q", contentType)
+	}
+
+	lines := strings.Split(response.Body.String(), "\n")
+	for _, want := range []string{
+		"# HELP activitymon_tracked_seconds_total Time tracked per app and category.",
+		"# TYPE activitymon_tracked_seconds_total counter",
+		`activitymon_tracked_seconds_total{app="Code",category="development"} 90`,
+		`activitymon_tracked_seconds_total{app="say \"hi\" \\ bye",category="line\nbreak"} 60`,
+		"# TYPE activitymon_context_switches_total counter",
+		"activitymon_context_switches_total 2",
+		"# TYPE activitymon_collector_errors_total counter",
+		`activitymon_collector_errors_total{collector="browser"} 1`,
+		"# HELP activitymon_db_write_duration_seconds Latency of database writes.",
+		"# TYPE activitymon_db_write_duration_seconds histogram",
+		`activitymon_db_write_duration_seconds_bucket{operation="insert",le="0.001"} 1`,
+		`activitymon_db_write_duration_seconds_bucket{operation="insert",le="0.0025"} 1`,
+		`activitymon_db_write_duration_seconds_bucket{operation="insert",le="0.005"} 3`,
+		`activitymon_db_write_duration_seconds_bucket{operation="insert",le="0.1"} 3`,
+		`activitymon_db_write_duration_seconds_bucket{operation="insert",le="0.25"} 4`,
+		`activitymon_db_write_duration_seconds_bucket{operation="insert",le="2.5"} 4`,
+		`activitymon_db_write_duration_seconds_bucket{operation="insert",le="+Inf"} 5`,
+		`activitymon_db_write_duration_seconds_sum{operation="insert"} 5.2075`,
+		`activitymon_db_write_duration_seconds_count{operation="insert"} 5`,
+	} {
+		found := false
+		for _, line := range lines {
+			found = found || line == want
+		}
+		if !found {
+			t.Errorf("missing %q in:\n%s", want, response.Body.String())
+		}
+	}
+
+	// Buckets never go down, so each one counts the writes of all the smaller ones
+	last := 0
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "activitymon_db_write_duration_seconds_bucket") {
+			continue
+		}
+		count, err := strconv.Atoi(line[strings.LastIndex(line, " ")+1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count < last {
+			t.Errorf("bucket %q has fewer writes than the one before it", line)
+		}
+		last = count
+	}
+}
@@ -9,18 +9,53 @@ import (
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/rivo/tview"
 	"github.com/urfave/cli/v2"
 )
 
 func monitorCmd(c *cli.Context) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	lockPath, err := getLockPath(cfg)
+	if err != nil {
+		return err
+	}
+	lock, err := acquireInstanceLock(lockPath)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
 	db, err := getDb()
 	if err != nil {
 		return fmt.Errorf("error opening database: %v", err)
 	}
 	defer db.Close()
 
+	var display Display
+	if c.Bool("headless") {
+		logWriter := os.Stderr
+		if logFile := c.String("log-file"); logFile != "" {
+			f, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return fmt.Errorf("error opening log file: %v", err)
+			}
+			defer f.Close()
+			logWriter = f
+		}
+		display, err = NewLogDisplay(logWriter, c.String("log-format"))
+		if err != nil {
+			return err
+		}
+	} else {
+		display = NewMonitor()
+	}
+
 	if err := db.cleanupUnfinishedActivities(); err != nil {
-		fmt.Printf("Error cleaning up unfinished activities: %v\n", err)
+		display.AddLogEntry(fmt.Sprintf("[red]Error cleaning up unfinished activities: %v[white]", err))
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -29,95 +64,400 @@ func monitorCmd(c *cli.Context) error {
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 
+	metrics := newMonitorMetrics()
+	if addr := c.String("metrics-addr"); addr != "" {
+		if err := startMetricsServer(ctx, addr, metrics); err != nil {
+			return err
+		}
+	}
+
 	errChan := make(chan error)
 	go func() {
-		errChan <- monitor(ctx, db)
+		errChan <- monitor(ctx, db, cfg, display, metrics)
 	}()
 
 	select {
 	case err := <-errChan:
 		return err
 	case sig := <-signalChan:
-		fmt.Printf("Received signal: %v\n", sig)
+		display.AddLogEntry(fmt.Sprintf("Received signal: %v", sig))
+		// the monitor ends its current activity before returning
 		cancel()
-		if err := db.endCurrentActivity(time.Now()); err != nil {
-			fmt.Printf("Error ending current activity: %v\n", err)
-		}
-		return nil
+		return <-errChan
 	}
 }
 
-func monitor(ctx context.Context, db *DB) error {
+func monitor(ctx context.Context, db *DB, cfg *Config, display Display, metrics *monitorMetrics) error {
 	startTime := time.Now()
-	display := NewMonitor()
 	if err := display.Start(); err != nil {
 		return err
 	}
 	defer display.Stop()
 
-	var lastAppName, lastDomain string
+	notifier, err := newNotifier(cfg.Notifications.Notifier)
+	if err != nil {
+		return err
+	}
+	labeler, err := newActivityLabeler(cfg.Labels)
+	if err != nil {
+		return err
+	}
+	privacy, err := newPrivacyFilter(cfg.Privacy)
+	if err != nil {
+		return err
+	}
+	gitProjects := newGitProjectDetector(cfg.GitProjects)
+	calendars := newCalendarWatcher(cfg.Calendar)
+	limits := newLimitWatcher(cfg)
+	logHookError := func(err error) {
+		display.AddLogEntry(fmt.Sprintf("[red]%v[white]", err))
+	}
+	hooks := newHookRunner(cfg.Hooks, logHookError)
+	defer func() { hooks.wait(hookTimeout) }()
+
+	socketPath, err := getSocketPath(cfg)
+	if err != nil {
+		return err
+	}
+	controlCalls := make(chan controlCall)
+	if err := startControlServer(ctx, socketPath, controlCalls); err != nil {
+		return err
+	}
+	defer os.Remove(socketPath)
+
+	var lastAppName, lastDomain, lastProject, lastFile string
+	var editors editorTracker
+	var currentID int64
+	var currentActivity string
+	var currentSince time.Time
+	var paused bool
+	var pausedUntil time.Time
+	lastTick := time.Now()
+
+	// end the current activity, if any, and forget it
+	endActivity := func(endTime time.Time) error {
+		id := currentID
+		if currentActivity != "" {
+			startTime := currentSince
+			hooks.fire(hookEvent{
+				Event:           hookActivityEnd,
+				Time:            endTime,
+				Activity:        currentActivity,
+				App:             lastAppName,
+				Domain:          lastDomain,
+				Category:        categorize(cfg.Categories, currentActivity),
+				StartTime:       &startTime,
+				DurationSeconds: endTime.Sub(currentSince).Seconds(),
+			})
+		}
+		lastAppName, lastDomain, lastProject, lastFile, currentActivity = "", "", "", "", ""
+		currentID = 0
+		if id == 0 {
+			return nil
+		}
+		writeStart := time.Now()
+		err := db.endCurrentActivity(id, endTime)
+		metrics.observeDBWrite("end", time.Since(writeStart))
+		return err
+	}
+
+	// roll up this device's activities older than the retention period; other
+	// devices sharing the database roll up their own
+	device, err := getDevice()
+	if err != nil {
+		return err
+	}
+	compact := func() {
+		cutoff := rollupCutoff(cfg.Retention.RawDays, time.Now())
+		compacted, err := compactActivities(db, cutoff, device.ID)
+		if err != nil {
+			display.AddLogEntry(fmt.Sprintf("[red]Error rolling up old activities: %v[white]", err))
+		} else if compacted > 0 {
+			display.AddLogEntry(fmt.Sprintf("[yellow]Rolled up %d old activities[white]", compacted))
+		}
+		if _, err := compactCommands(db, cutoff, device.ID); err != nil {
+			display.AddLogEntry(fmt.Sprintf("[red]Error deleting old shell commands: %v[white]", err))
+		}
+	}
+	compact()
+
+	importCalendars := func() {
+		for _, message := range calendars.check(db, time.Now()) {
+			display.AddLogEntry(message)
+		}
+	}
+	importCalendars()
+
 	ticker := time.NewTicker(time.Second)
 	statsTicker := time.NewTicker(5 * time.Second)
+	compactTicker := time.NewTicker(time.Hour)
+	calendarTicker := time.NewTicker(calendarWatchInterval)
+	heartbeatTicker := time.NewTicker(openActivityHeartbeat)
 
 	for {
 		select {
 		case <-ctx.Done():
+			if err := endActivity(time.Now()); err != nil {
+				return fmt.Errorf("error ending current activity: %v", err)
+			}
 			return nil
 
+		case call := <-controlCalls:
+			response := controlResponse{OK: true}
+			switch call.request.Command {
+			case "status":
+				status := &monitorStatus{
+					Activity:     currentActivity,
+					Since:        currentSince,
+					Paused:       paused,
+					SessionStart: startTime,
+				}
+				if !pausedUntil.IsZero() {
+					status.PausedUntil = &pausedUntil
+				}
+				data, err := getSummaryData(db, startTime)
+				if err != nil {
+					response = controlResponse{Error: err.Error()}
+					break
+				}
+				for _, activity := range data.Activities {
+					status.SessionTotals = append(status.SessionTotals, activityTotal{
+						Name:    activity.Name,
+						Seconds: activity.Duration.Seconds(),
+					})
+				}
+				response.Status = status
+
+			case "pause":
+				var duration time.Duration
+				if call.request.Duration != "" {
+					duration, err = time.ParseDuration(call.request.Duration)
+					if err != nil {
+						response = controlResponse{Error: fmt.Sprintf("invalid duration: %v", err)}
+						break
+					}
+				}
+				if err := endActivity(time.Now()); err != nil {
+					display.AddLogEntry(fmt.Sprintf("[red]Error ending current activity: %v[white]", err))
+				}
+				paused = true
+				pausedUntil = time.Time{}
+				if duration > 0 {
+					pausedUntil = time.Now().Add(duration)
+					display.AddLogEntry(fmt.Sprintf("[yellow]Tracking paused until %s[white]", pausedUntil.Format("15:04:05")))
+				} else {
+					display.AddLogEntry("[yellow]Tracking paused[white]")
+				}
+
+			case "resume":
+				paused = false
+				pausedUntil = time.Time{}
+				display.AddLogEntry("[yellow]Tracking resumed[white]")
+
+			case "reload-config":
+				newCfg, err := loadConfig()
+				var reloadedNotifier Notifier
+				var reloadedPrivacy *privacyFilter
+				var reloadedLabeler *activityLabeler
+				if err == nil {
+					reloadedNotifier, err = newNotifier(newCfg.Notifications.Notifier)
+				}
+				if err == nil {
+					reloadedPrivacy, err = newPrivacyFilter(newCfg.Privacy)
+				}
+				if err == nil {
+					reloadedLabeler, err = newActivityLabeler(newCfg.Labels)
+				}
+				if err != nil {
+					response = controlResponse{Error: err.Error()}
+					display.AddLogEntry(fmt.Sprintf("[red]Error reloading config: %v[white]", err))
+					break
+				}
+				cfg = newCfg
+				notifier, privacy, labeler = reloadedNotifier, reloadedPrivacy, reloadedLabeler
+				gitProjects = newGitProjectDetector(cfg.GitProjects)
+				calendars = newCalendarWatcher(cfg.Calendar)
+				importCalendars()
+				limits = limits.reload(cfg)
+				hooks = hooks.reload(cfg.Hooks)
+				display.AddLogEntry("[yellow]Configuration reloaded[white]")
+
+			case "shell-command":
+				if call.request.ShellCommand == nil {
+					response = controlResponse{Error: "missing shell command"}
+					break
+				}
+				// commands finished while paused aren't tracked either
+				if paused && (pausedUntil.IsZero() || time.Now().Before(pausedUntil)) {
+					break
+				}
+				if err := db.insertShellCommand(*call.request.ShellCommand, privacy); err != nil {
+					response = controlResponse{Error: err.Error()}
+					display.AddLogEntry(fmt.Sprintf("[red]Error storing shell command: %v[white]", err))
+				}
+
+			case "editor":
+				if call.request.Editor == nil {
+					response = controlResponse{Error: "missing editor report"}
+					break
+				}
+				if err := editors.report(*call.request.Editor, lastAppName); err != nil {
+					response = controlResponse{Error: err.Error()}
+				}
+
+			default:
+				response = controlResponse{Error: fmt.Sprintf("unknown command: %s", call.request.Command)}
+			}
+			call.reply <- response
+
 		case <-ticker.C:
 			currentTime := time.Now()
-			appName, windowTitle, err := getAppAndWindow()
+			if currentActivity != "" {
+				metrics.addTrackedTime(lastAppName, categorize(cfg.Categories, currentActivity), currentTime.Sub(lastTick))
+			}
+			lastTick = currentTime
+
+			if paused {
+				if pausedUntil.IsZero() || currentTime.Before(pausedUntil) {
+					continue
+				}
+				paused = false
+				pausedUntil = time.Time{}
+				display.AddLogEntry("[yellow]Tracking resumed[white]")
+			}
+
+			appName, windowTitle, pid, err := getAppAndWindow()
 			if err != nil {
+				metrics.incCollectorErrors("window")
 				display.AddLogEntry(fmt.Sprintf("[red]Failed to get window info: %v. Retrying...[white]", err))
-				if err := db.endCurrentActivity(currentTime); err != nil {
+				if err := endActivity(currentTime); err != nil {
 					display.AddLogEntry(fmt.Sprintf("[red]Error ending current activity: %v[white]", err))
 				}
-				lastAppName = ""
-				lastDomain = ""
 				continue
 			}
 
-			domain, err := getBrowserDomain(appName)
+			// no input for a while counts as being away, from when the input stopped
+			if cfg.IdleMinutes > 0 && appName != "" {
+				idleTime, err := getIdleTime()
+				if err != nil {
+					metrics.incCollectorErrors("idle")
+					display.AddLogEntry(fmt.Sprintf("[red]Failed to get idle time: %v[white]", err))
+				} else if idleTime >= time.Duration(cfg.IdleMinutes)*time.Minute {
+					if lastAppName != "" {
+						idleSince := currentTime.Add(-idleTime)
+						if idleSince.Before(currentSince) {
+							idleSince = currentSince
+						}
+						if err := endActivity(idleSince); err != nil {
+							display.AddLogEntry(fmt.Sprintf("[red]Error ending current activity: %v[white]", err))
+						}
+						hooks.fire(hookEvent{Event: hookIdle, Time: idleSince})
+						display.AddLogEntry(fmt.Sprintf("[yellow]Idle since %s[white]", idleSince.Format("15:04:05")))
+					}
+					continue
+				}
+			}
+
+			url, err := getBrowserUrl(appName)
 			if err != nil {
+				metrics.incCollectorErrors("browser")
 				display.AddLogEntry(fmt.Sprintf("[red]Failed to get browser URL info: %v[white]", err))
 			}
+			incognito := false
+			if isBrowser(appName) && !cfg.Privacy.KeepIncognito {
+				if incognito, err = isIncognitoWindow(appName, windowTitle); err != nil {
+					metrics.incCollectorErrors("browser")
+					display.AddLogEntry(fmt.Sprintf("[red]Failed to get browser window mode: %v[white]", err))
+				}
+			}
+			// nothing is kept that the privacy rules redact, including the domain, and
+			// projects aren't detected from redacted paths
+			windowTitle, url = privacy.apply(appName, windowTitle, url, incognito)
+			domain := getDomain(url)
+			var project, file, language string
+			if editor := editors.get(appName, pid); editor != nil {
+				project, file, language = editor.Project, editor.File, editor.Language
+				file = privacy.applyToFile(appName, file, incognito)
+			}
+			if project == "" {
+				if project, err = gitProjects.detect(appName, windowTitle, pid, currentTime); err != nil {
+					metrics.incCollectorErrors("project")
+					display.AddLogEntry(fmt.Sprintf("[red]Failed to detect git project: %v[white]", err))
+				}
+			}
 
 			if appName == "" && windowTitle == "" {
 				// computer is likely asleep or locked
 				if lastAppName != "" {
-					if err := db.endCurrentActivity(currentTime); err != nil {
+					if err := endActivity(currentTime); err != nil {
 						display.AddLogEntry(fmt.Sprintf("[red]Error ending current activity: %v[white]", err))
 					}
-					lastAppName = ""
-					lastDomain = ""
+					hooks.fire(hookEvent{Event: hookAway, Time: currentTime})
 				}
-			} else if appName != lastAppName || domain != lastDomain {
+			} else if appName != lastAppName || domain != lastDomain || project != lastProject || file != lastFile {
 				// activity has changed
-				if lastAppName != "" {
-					if err := db.endCurrentActivity(currentTime); err != nil {
-						display.AddLogEntry(fmt.Sprintf("[red]Error ending current activity: %v[white]", err))
-					}
+				if err := endActivity(currentTime); err != nil {
+					display.AddLogEntry(fmt.Sprintf("[red]Error ending current activity: %v[white]", err))
 				}
+				metrics.incContextSwitches()
 
 				activityName := appName
 				if domain != "" {
 					activityName = domain
 				}
-				if err := db.insertActivity(currentTime, activityName); err != nil {
+				writeStart := time.Now()
+				record := labeler.apply(ActivityRecord{
+					StartTime: currentTime,
+					Name:      activityName,
+					App:       appName,
+					Title:     windowTitle,
+					URL:       url,
+					UpdatedAt: &currentTime,
+					File:      file,
+					Language:  language,
+				})
+				if project != "" && len(record.Projects) == 0 {
+					record.Projects = []string{project}
+				}
+				currentID, err = db.insertActivity(db, privacy.forStorage(record))
+				metrics.observeDBWrite("insert", time.Since(writeStart))
+				if err != nil {
 					display.AddLogEntry(fmt.Sprintf("[red]Error inserting activity: %v[white]", err))
 				} else {
-					display.AddLogEntry(fmt.Sprintf("Started activity: %s", activityName))
+					display.AddLogEntry(fmt.Sprintf("Started activity: %s", tview.Escape(activityName)))
 				}
 
 				lastAppName = appName
 				lastDomain = domain
+				lastProject = project
+				lastFile = file
+				currentActivity = activityName
+				currentSince = currentTime
+				hooks.fire(hookEvent{
+					Event:     hookActivityStart,
+					Time:      currentTime,
+					Activity:  activityName,
+					App:       appName,
+					Domain:    domain,
+					Category:  categorize(cfg.Categories, activityName),
+					StartTime: &currentTime,
+				})
 				if appName != lastAppName || domain != lastDomain {
 					display.AddLogEntry(fmt.Sprintf("[green]%s Started activity: %s[white]",
 						currentTime.Format("2006-01-02 15:04:05"),
-						activityName))
+						tview.Escape(activityName)))
 				}
 			}
 
+			for _, n := range limits.observe(currentTime, currentActivity) {
+				display.AddLogEntry(fmt.Sprintf("[yellow]Limit reached (%s): %s[white]", tview.Escape(n.Rule.Name), tview.Escape(n.Message)))
+				go func(notifier Notifier, n limitNotification) {
+					if err := notifier.Notify("activitymon: "+n.Rule.Name, n.Message); err != nil {
+						display.AddLogEntry(fmt.Sprintf("[red]Error sending notification: %v[white]", err))
+					}
+				}(notifier, n)
+			}
+
 		case <-statsTicker.C:
 			// show stats since the start of the session, up to 12 hours
 			minStartTime := startTime.Add(-12 * time.Hour)
@@ -131,6 +471,19 @@ func monitor(ctx context.Context, db *DB) error {
 				continue
 			}
 			display.UpdateStats(stats)
+
+		case <-compactTicker.C:
+			compact()
+
+		case <-calendarTicker.C:
+			importCalendars()
+
+		case <-heartbeatTicker.C:
+			if currentID != 0 {
+				if err := db.touchCurrentActivity(currentID, time.Now()); err != nil {
+					display.AddLogEntry(fmt.Sprintf("[red]Error updating current activity: %v[white]", err))
+				}
+			}
 		}
 	}
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+type Notifier interface {
+	Notify(title, message string) error
+}
+
+func newNotifier(cfg NotifierConfig) (Notifier, error) {
+	notifierType := cfg.Type
+	if notifierType == "" || notifierType == "auto" {
+		if runtime.GOOS == "darwin" {
+			notifierType = "osascript"
+		} else {
+			notifierType = "notify-send"
+		}
+	}
+
+	switch notifierType {
+	case "osascript":
+		return osascriptNotifier{}, nil
+	case "notify-send":
+		return commandNotifier{args: []string{"notify-send"}}, nil
+	case "command":
+		if len(cfg.Command) == 0 {
+			return nil, fmt.Errorf("command notifier requires a command")
+		}
+		return commandNotifier{args: cfg.Command, useEnv: true}, nil
+	case "webhook":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("webhook notifier requires a url")
+		}
+		return webhookNotifier{url: cfg.URL}, nil
+	default:
+		return nil, fmt.Errorf("unsupported notifier type: %s", cfg.Type)
+	}
+}
+
+// Show a notification with AppleScript's "display notification"
+type osascriptNotifier struct{}
+
+func (osascriptNotifier) Notify(title, message string) error {
+	_, err := runAppleScript(fmt.Sprintf("display notification %s with title %s",
+		appleScriptString(message), appleScriptString(title)))
+	return err
+}
+
+// Run a command with the title and message appended as arguments. Custom commands
+// also get them as ACTIVITYMON_TITLE and ACTIVITYMON_MESSAGE environment variables.
+type commandNotifier struct {
+	args   []string
+	useEnv bool
+}
+
+func (n commandNotifier) Notify(title, message string) error {
+	cmd := exec.Command(n.args[0], append(n.args[1:], title, message)...)
+	if n.useEnv {
+		cmd.Env = append(os.Environ(), "ACTIVITYMON_TITLE="+title, "ACTIVITYMON_MESSAGE="+message)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("notification command error: %v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// POST the notification as JSON
+type webhookNotifier struct {
+	url string
+}
+
+func (n webhookNotifier) Notify(title, message string) error {
+	payload, err := json.Marshal(map[string]string{
+		"title":   title,
+		"message": message,
+		"time":    time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	return postJSON(n.url, payload)
+}
+
+// Quote a string as an AppleScript string literal
+func appleScriptString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
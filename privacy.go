@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	defaultRedaction = "[redacted]"
+	unknownBrowser   = "Web browser"
+
+	hashPrefix = "hmac:"
+	hashLength = 21
+
+	// The key hashed values are keyed with, in the config directory
+	privacyKeyFile = "privacy.key"
+)
+
+var (
+	redactionActions = []string{"drop", "hash", "redact"}
+	redactionFields  = []string{"", "title", "url"}
+
+	// Title suffixes browsers give private windows
+	incognitoTitleMarkers = []string{"private browsing", "(incognito)", "[inprivate]", "- inprivate", "— private"}
+)
+
+// Browser-specific AppleScript snippets to get whether the front window is private
+var browserIncognitoScripts = map[string]string{
+	"Google Chrome": "tell application \"Google Chrome\" to get mode of front window",
+}
+
+type privacyRule struct {
+	RedactionRule
+	pattern *regexp.Regexp
+	hashKey []byte
+}
+
+// Redacts window titles and URLs before they're stored, following the configured rules
+type privacyFilter struct {
+	rules         []privacyRule
+	storeTitles   bool
+	keepIncognito bool
+}
+
+func newPrivacyFilter(cfg PrivacyConfig) (*privacyFilter, error) {
+	filter := &privacyFilter{storeTitles: cfg.StoreTitles, keepIncognito: cfg.KeepIncognito}
+	for i, rule := range cfg.Rules {
+		if !slices.Contains(redactionActions, rule.Action) {
+			return nil, fmt.Errorf("privacy rule %d: unsupported action %q (expected one of %s)",
+				i+1, rule.Action, strings.Join(redactionActions, ", "))
+		}
+		if !slices.Contains(redactionFields, rule.Field) {
+			return nil, fmt.Errorf("privacy rule %d: unsupported field %q (expected title or url)", i+1, rule.Field)
+		}
+
+		compiled := privacyRule{RedactionRule: rule}
+		if rule.Action == "hash" {
+			key, err := loadPrivacyKey()
+			if err != nil {
+				return nil, err
+			}
+			compiled.hashKey = key
+		}
+		if rule.Action == "redact" {
+			if rule.Pattern == "" {
+				return nil, fmt.Errorf("privacy rule %d: redact needs a pattern", i+1)
+			}
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("privacy rule %d: invalid pattern: %v", i+1, err)
+			}
+			compiled.pattern = pattern
+		}
+		filter.rules = append(filter.rules, compiled)
+	}
+	return filter, nil
+}
+
+// Apply the rules that match the app and the URL's domain to the title and URL, in
+// order. Private browsing windows keep neither, unless configured otherwise.
+func (f *privacyFilter) apply(app, title, url string, incognito bool) (string, string) {
+	if incognito && !f.keepIncognito {
+		return "", ""
+	}
+
+	domain := getDomain(url)
+	for _, rule := range f.rules {
+		if rule.App != "" && !strings.EqualFold(rule.App, app) {
+			continue
+		}
+		if rule.Domain != "" && !activityMatches(rule.Domain, domain) {
+			continue
+		}
+		if rule.Field != "url" {
+			title = rule.redact(title)
+		}
+		if rule.Field != "title" {
+			url = rule.redact(url)
+		}
+	}
+	return title, url
+}
+
+func (r privacyRule) redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	switch r.Action {
+	case "drop":
+		return ""
+	case "hash":
+		// stable, so redacted values can still be grouped, and not hashed again when
+		// an activity is redacted twice, e.g. when it comes back through sync
+		if strings.HasPrefix(value, hashPrefix) && len(value) == hashLength {
+			return value
+		}
+		mac := hmac.New(sha256.New, r.hashKey)
+		mac.Write([]byte(value))
+		return fmt.Sprintf("%s%x", hashPrefix, mac.Sum(nil))[:hashLength]
+	default:
+		replacement := defaultRedaction
+		if r.Replacement != nil {
+			replacement = *r.Replacement
+		}
+		return r.pattern.ReplaceAllString(value, replacement)
+	}
+}
+
+// Redact an activity from elsewhere, such as an import. Browsing activities named
+// after a domain are renamed if their URL no longer has it, to the browser, or to
+// unknownBrowser where the import doesn't say which browser it was.
+func (f *privacyFilter) applyToRecord(record ActivityRecord) ActivityRecord {
+	domain := getDomain(record.URL)
+	record.Title, record.URL = f.apply(record.App, record.Title, record.URL, record.incognito)
+	record.File = f.applyToFile(record.App, record.File, record.incognito)
+	if newDomain := getDomain(record.URL); domain != "" && record.Name == domain && newDomain != domain {
+		record.Name = newDomain
+		if record.Name == "" {
+			record.Name = record.App
+		}
+		if record.Name == "" {
+			record.Name = unknownBrowser
+		}
+	}
+	return f.forStorage(record)
+}
+
+// Redact the path of a file being edited like a window title of the app
+func (f *privacyFilter) applyToFile(app, file string, incognito bool) string {
+	file, _ = f.apply(app, file, "", incognito)
+	return file
+}
+
+// Redact a shell command's working directory like a window title of the app it ran
+// in, and drop it unless titles are stored
+func (f *privacyFilter) applyToCommand(command shellCommand) shellCommand {
+	command.Cwd, _ = f.apply(command.App, command.Cwd, "", false)
+	if !f.storeTitles {
+		command.Cwd = ""
+	}
+	return command
+}
+
+// Remove the title, URL and file of an activity that's about to be stored, unless
+// storing them is turned on. The activity keeps its name, so browsing still counts
+// by domain.
+func (f *privacyFilter) forStorage(record ActivityRecord) ActivityRecord {
+	if !f.storeTitles {
+		record.Title, record.URL, record.File = "", "", ""
+	}
+	return record
+}
+
+// Load the key for hashing values, creating it the first time. It never leaves this
+// machine, so hashes of guessable values like domains can't be reversed by hashing
+// candidates.
+func loadPrivacyKey() ([]byte, error) {
+	configDir, err := getConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(configDir, privacyKeyFile)
+
+	for {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+			if err != nil || len(key) == 0 {
+				return nil, fmt.Errorf("invalid privacy key in %s", path)
+			}
+			return key, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("unable to read privacy key: %v", err)
+		}
+
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("unable to generate privacy key: %v", err)
+		}
+		// another process may be creating it at the same time; read theirs if so
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to save privacy key: %v", err)
+		}
+		_, err = f.WriteString(hex.EncodeToString(key) + "\n")
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to save privacy key: %v", err)
+		}
+		return key, nil
+	}
+}
+
+// Check whether the front window of a browser is a private browsing window, by its
+// title, or by asking the browser where it can say
+func isIncognitoWindow(app, title string) (bool, error) {
+	if hasIncognitoTitle(title) {
+		return true, nil
+	}
+
+	script, ok := browserIncognitoScripts[app]
+	if !ok {
+		return false, nil
+	}
+	mode, err := runAppleScript(script)
+	if err != nil {
+		return false, err
+	}
+	return mode == "incognito", nil
+}
+
+func hasIncognitoTitle(title string) bool {
+	title = strings.ToLower(title)
+	for _, marker := range incognitoTitleMarkers {
+		if strings.HasSuffix(title, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestPrivacyFilter(t *testing.T, cfg PrivacyConfig) *privacyFilter {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	filter, err := newPrivacyFilter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filter
+}
+
+func TestPrivacyFilterApply(t *testing.T) {
+	hidden := "[hidden]"
+	filter := newTestPrivacyFilter(t, PrivacyConfig{StoreTitles: true, Rules: []RedactionRule{
+		{App: "Slack", Field: "title", Action: "drop"},
+		{Domain: "bank.example.com", Action: "hash"},
+		{Domain: "github.com", Field: "url", Action: "redact", Pattern: `\?.*`},
+		{App: "Terminal", Action: "redact", Pattern: `token=\w+`, Replacement: &hidden},
+	}})
+	hash := func(value string) string {
+		title, _ := filter.apply("Firefox", value, "https://bank.example.com", false)
+		return title
+	}
+
+	for _, test := range []struct {
+		app, title, url    string
+		incognito          bool
+		wantTitle, wantURL string
+	}{
+		{"Slack", "general | Acme", "", false, "", ""},
+		{"slack", "general | Acme", "", false, "", ""},
+		{"Code", "general | Acme", "", false, "general | Acme", ""},
+		{"Firefox", "Balance", "https://bank.example.com/accounts", false, hash("Balance"), hash("https://bank.example.com/accounts")},
+		{"Firefox", "Statements", "https://www.bank.example.com/statements", false, hash("Statements"), hash("https://www.bank.example.com/statements")},
+		{"Firefox", "Pulls", "https://github.com/pulls?q=is%3Aopen", false, "Pulls", "https://github.com/pulls[redacted]"},
+		{"Terminal", "curl ?token=abc123", "", false, "curl ?[hidden]", ""},
+		{"Firefox", "Pulls", "https://github.com/pulls", true, "", ""},
+	} {
+		title, url := filter.apply(test.app, test.title, test.url, test.incognito)
+		if title != test.wantTitle || url != test.wantURL {
+			t.Errorf("%s %q %q: got %q %q, want %q %q", test.app, test.title, test.url, title, url, test.wantTitle, test.wantURL)
+		}
+	}
+
+	// hashes are keyed, stable and not hashed again
+	plain := fmt.Sprintf("%s%x", hashPrefix, sha256.Sum256([]byte("Balance")))[:hashLength]
+	if h := hash("Balance"); len(h) != hashLength || h == plain || h != hash("Balance") || hash(h) != h {
+		t.Errorf("unexpected hash %q", h)
+	}
+
+	keepIncognito := newTestPrivacyFilter(t, PrivacyConfig{KeepIncognito: true})
+	if title, url := keepIncognito.apply("Firefox", "Pulls", "https://github.com/pulls", true); title != "Pulls" || url != "https://github.com/pulls" {
+		t.Errorf("got %q %q, want private windows kept", title, url)
+	}
+}
+
+func TestPrivacyFilterApplyToRecord(t *testing.T) {
+	var empty string
+	rules := []RedactionRule{
+		{Domain: "bank.example.com", Field: "url", Action: "drop"},
+		{Domain: "github.com", Field: "url", Action: "redact", Pattern: `/[^/]+$`, Replacement: &empty},
+		{App: "Code", Field: "title", Action: "redact", Pattern: `secret/`, Replacement: &empty},
+	}
+	browsing := func(name, title, url string, incognito bool) ActivityRecord {
+		return ActivityRecord{Name: name, App: "Firefox", Title: title, URL: url, incognito: incognito}
+	}
+
+	for _, test := range []struct {
+		name        string
+		storeTitles bool
+		record      ActivityRecord
+		want        ActivityRecord
+	}{
+		{"renamed without the domain", true,
+			browsing("bank.example.com", "Balance", "https://bank.example.com/accounts", false),
+			browsing("Firefox", "Balance", "", false)},
+		{"domain kept", true,
+			browsing("github.com", "Pulls", "https://github.com/pulls", false),
+			browsing("github.com", "Pulls", "https://github.com", false)},
+		{"private window", true,
+			browsing("github.com", "Pulls", "https://github.com/pulls", true),
+			browsing("Firefox", "", "", true)},
+		{"titles not stored", false,
+			browsing("github.com", "Pulls", "https://github.com/pulls", false),
+			browsing("github.com", "", "", false)},
+		{"other apps", false,
+			ActivityRecord{Name: "Code", App: "Code", Title: "main.go", File: "/src/main.go"},
+			ActivityRecord{Name: "Code", App: "Code"}},
+		{"file redacted", true,
+			ActivityRecord{Name: "Code", App: "Code", Title: "main.go", File: "/src/secret/main.go"},
+			ActivityRecord{Name: "Code", App: "Code", Title: "main.go", File: "/src/main.go"}},
+	} {
+		filter := newTestPrivacyFilter(t, PrivacyConfig{StoreTitles: test.storeTitles, Rules: rules})
+		if got := filter.applyToRecord(test.record); fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+// The hash key is created once and kept private
+func TestPrivacyKey(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	key, err := loadPrivacyKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := loadPrivacyKey()
+	if err != nil || string(again) != string(key) || len(key) != 32 {
+		t.Errorf("got %x, %v, want the same 32-byte key %x", again, err, key)
+	}
+	configDir, err := getConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(configDir, privacyKeyFile)); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("got %v, %v, want a file only the user can read", info, err)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+type labelRule struct {
+	LabelRule
+	title *regexp.Regexp
+}
+
+// Gives activities projects and tags as they're recorded, following the configured rules
+type activityLabeler struct {
+	rules []labelRule
+}
+
+func newActivityLabeler(rules []LabelRule) (*activityLabeler, error) {
+	l := &activityLabeler{}
+	for i, rule := range rules {
+		if rule.Project == "" && len(rule.Tags) == 0 {
+			return nil, fmt.Errorf("label rule %d: needs a project or tags", i+1)
+		}
+		for _, name := range append([]string{rule.Project}, rule.Tags...) {
+			if err := checkLabel(name); err != nil {
+				return nil, fmt.Errorf("label rule %d: %v", i+1, err)
+			}
+		}
+		compiled := labelRule{LabelRule: rule}
+		if rule.Title != "" {
+			title, err := regexp.Compile(rule.Title)
+			if err != nil {
+				return nil, fmt.Errorf("label rule %d: invalid title pattern: %v", i+1, err)
+			}
+			compiled.title = title
+		}
+		l.rules = append(l.rules, compiled)
+	}
+	return l, nil
+}
+
+func (r labelRule) matches(record ActivityRecord) bool {
+	return (r.Activity == "" || activityMatches(r.Activity, record.Name)) &&
+		(r.App == "" || strings.EqualFold(r.App, record.App)) &&
+		(r.title == nil || r.title.MatchString(record.Title))
+}
+
+// Label an activity with the project of the first matching rule that has one, unless
+// it has a project already, and the tags of every matching rule
+func (l *activityLabeler) apply(record ActivityRecord) ActivityRecord {
+	hasProject := len(record.Projects) > 0
+	for _, rule := range l.rules {
+		if !rule.matches(record) {
+			continue
+		}
+		if rule.Project != "" && !hasProject {
+			record.Projects = addLabel(record.Projects, rule.Project)
+			hasProject = true
+		}
+		for _, tag := range rule.Tags {
+			record.Tags = addLabel(record.Tags, tag)
+		}
+	}
+	return record
+}
+
+// Count the activities labeled with each project or tag
+func getLabelCounts(db *DB, kind string) (map[string]int, error) {
+	for _, labels := range labelTables {
+		if labels.table != kind {
+			continue
+		}
+		rows, err := db.Query(fmt.Sprintf(`
+			SELECT l.name, COUNT(a.activity_id)
+			FROM %s l LEFT JOIN %s a ON a.%s = l.id
+			GROUP BY l.name
+		`, labels.table, labels.assignments, labels.column))
+		if err != nil {
+			return nil, fmt.Errorf("error querying %s: %v", kind, err)
+		}
+		defer rows.Close()
+
+		counts := make(map[string]int)
+		for rows.Next() {
+			var name string
+			var count int
+			if err := rows.Scan(&name, &count); err != nil {
+				return nil, fmt.Errorf("error scanning row: %v", err)
+			}
+			counts[name] = count
+		}
+		return counts, rows.Err()
+	}
+	return nil, fmt.Errorf("unknown label kind: %s", kind)
+}
+
+func labelListCmd(kind string) *cli.Command {
+	return &cli.Command{
+		Name:  "list",
+		Usage: fmt.Sprintf("List %s and how many activities have each", kind),
+		Action: func(c *cli.Context) error {
+			db, err := getDb()
+			if err != nil {
+				return fmt.Errorf("error connecting to database: %v", err)
+			}
+			defer db.Close()
+
+			counts, err := getLabelCounts(db, kind)
+			if err != nil {
+				return err
+			}
+			for _, name := range sortedKeys(counts) {
+				fmt.Printf("%-40s %6d activities\n", name, counts[name])
+			}
+			return nil
+		},
+	}
+}
+
+func projectsCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "projects",
+		Usage: "List projects, or label activities by the rules in the config",
+		Subcommands: []*cli.Command{
+			labelListCmd("projects"),
+			{
+				Name:  "apply",
+				Usage: "Label recorded activities using the label rules in the config",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "since", Usage: "Label activities after this time", Required: true},
+					&cli.StringFlag{Name: "until", Usage: "Label activities before this time (default: now)"},
+				},
+				Action: func(c *cli.Context) error {
+					now := time.Now()
+					since, err := parseTimeParam(c.String("since"), now)
+					if err != nil {
+						return fmt.Errorf("invalid since: %v", err)
+					}
+					until := now
+					if value := c.String("until"); value != "" {
+						if until, err = parseTimeParam(value, now); err != nil {
+							return fmt.Errorf("invalid until: %v", err)
+						}
+					}
+
+					cfg, err := loadConfig()
+					if err != nil {
+						return err
+					}
+					labeler, err := newActivityLabeler(cfg.Labels)
+					if err != nil {
+						return err
+					}
+					db, err := getDb()
+					if err != nil {
+						return fmt.Errorf("error connecting to database: %v", err)
+					}
+					defer db.Close()
+
+					// the rules may match titles, so they need decrypting
+					records, err := getActivities(db, since, until)
+					if err != nil {
+						return err
+					}
+					editor, err := beginEdit(db, strings.Join(os.Args[1:], " "))
+					if err != nil {
+						return err
+					}
+					defer editor.rollback()
+					labeled := 0
+					for _, record := range records {
+						applied := labeler.apply(record)
+						if sameLabels(record, applied) {
+							continue
+						}
+						if err := editor.label(record, applied.Projects, applied.Tags); err != nil {
+							return err
+						}
+						labeled++
+					}
+					if err := editor.commit(); err != nil {
+						return err
+					}
+					fmt.Printf("Labeled %d of %d activities", labeled, len(records))
+					if editor.editID != 0 {
+						fmt.Printf(" (edit %d)", editor.editID)
+					}
+					fmt.Println()
+					return nil
+				},
+			},
+		},
+	}
+}
+
+func tagsCmd() *cli.Command {
+	return &cli.Command{
+		Name:        "tags",
+		Usage:       "List tags",
+		Subcommands: []*cli.Command{labelListCmd("tags")},
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestActivityLabeler(t *testing.T) {
+	labeler, err := newActivityLabeler([]LabelRule{
+		{Activity: "github.com", Title: `activitymon`, Project: "activitymon", Tags: []string{"review"}},
+		{App: "slack", Tags: []string{"chat"}},
+		{Activity: "github.com", Project: "open-source"},
+		{Title: `(?i)youtube`, Tags: []string{personalTag}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name           string
+		record         ActivityRecord
+		projects, tags []string
+	}{
+		{"first project wins", ActivityRecord{Name: "github.com", Title: "Pull requests · me/activitymon"},
+			[]string{"activitymon"}, []string{"review"}},
+		{"subdomain", ActivityRecord{Name: "gist.github.com", Title: "Gist"}, []string{"open-source"}, nil},
+		{"app is case insensitive", ActivityRecord{Name: "Slack", App: "Slack"}, nil, []string{"chat"}},
+		{"tags of every rule", ActivityRecord{Name: "Slack", App: "Slack", Title: "YouTube link"},
+			nil, []string{"chat", personalTag}},
+		{"existing project is kept", ActivityRecord{Name: "github.com", Title: "activitymon", Projects: []string{"mine"}},
+			[]string{"mine"}, []string{"review"}},
+		{"existing tags are kept", ActivityRecord{Name: "Code", Tags: []string{"deep-work"}}, nil, []string{"deep-work"}},
+		{"no match", ActivityRecord{Name: "Code", App: "Code", Title: "main.go"}, nil, nil},
+		{"activity must match exactly", ActivityRecord{Name: "notgithub.com"}, nil, nil},
+	} {
+		got := labeler.apply(tt.record)
+		if !slices.Equal(got.Projects, tt.projects) || !slices.Equal(got.Tags, tt.tags) {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.name, got.Projects, got.Tags, tt.projects, tt.tags)
+		}
+	}
+}
+
+func TestActivityLabelerRejectsInvalidRules(t *testing.T) {
+	for _, rule := range []LabelRule{
+		{Activity: "github.com"},
+		{Title: "(", Project: "activitymon"},
+		{Project: "a" + labelSeparator + "b"},
+		{Tags: []string{"x" + labelSeparator}},
+	} {
+		if _, err := newActivityLabeler([]LabelRule{rule}); err == nil {
+			t.Errorf("rule %+v was accepted", rule)
+		}
+	}
+}
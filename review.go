@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/urfave/cli/v2"
+)
+
+// Consecutive activities with the same name are reviewed as one block, unless
+// there's more than this much time between them
+const reviewBlockGap = 5 * time.Minute
+
+// Number of projects that can be assigned with the number keys
+const reviewProjectKeys = 9
+
+// Consecutive activities with the same name
+type reviewBlock struct {
+	Start, End time.Time
+	Name       string
+	Records    []ActivityRecord
+}
+
+// The block's projects and tags, as the union of its activities'
+func (b reviewBlock) labels() ActivityRecord {
+	var labels ActivityRecord
+	for _, record := range b.Records {
+		for _, project := range record.Projects {
+			labels.Projects = addLabel(labels.Projects, project)
+		}
+		for _, tag := range record.Tags {
+			labels.Tags = addLabel(labels.Tags, tag)
+		}
+	}
+	return labels
+}
+
+// Group activities, ordered by start time, into blocks, clipped to the given range
+func coalesceBlocks(records []ActivityRecord, since, until, now time.Time) []reviewBlock {
+	var blocks []reviewBlock
+	last := make(map[string]int) // the latest block of each name
+	for _, record := range records {
+		start, end := record.StartTime, record.endOr(now)
+		if start.Before(since) {
+			start = since
+		}
+		if end.After(until) {
+			end = until
+		}
+		if !end.After(start) {
+			continue
+		}
+
+		// only merge into the latest block if nothing else started since
+		if i, ok := last[record.Name]; ok && i == len(blocks)-1 && !start.After(blocks[i].End.Add(reviewBlockGap)) {
+			if end.After(blocks[i].End) {
+				blocks[i].End = end
+			}
+			blocks[i].Records = append(blocks[i].Records, record)
+			continue
+		}
+		last[record.Name] = len(blocks)
+		blocks = append(blocks, reviewBlock{Start: start, End: end, Name: record.Name, Records: []ActivityRecord{record}})
+	}
+	return blocks
+}
+
+// The interactive review of a day's activities
+type reviewScreen struct {
+	db       *DB
+	day      time.Time
+	blocks   []reviewBlock
+	projects []string
+
+	app        *tview.Application
+	headerView *tview.TextView
+	table      *tview.Table
+	sideView   *tview.TextView
+	input      *tview.InputField
+	statusView *tview.TextView
+}
+
+func reviewCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "review",
+		Usage: "Go through a day's activities and label them with projects and tags",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "date",
+				Usage: "Day to review, e.g. 2024-11-05 (default: today)",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			now := time.Now()
+			day := now
+			if value := c.String("date"); value != "" {
+				var err error
+				if day, err = time.ParseInLocation("2006-01-02", value, time.Local); err != nil {
+					return fmt.Errorf("invalid date: %v", err)
+				}
+			}
+
+			db, err := getDb()
+			if err != nil {
+				return fmt.Errorf("error connecting to database: %v", err)
+			}
+			defer db.Close()
+
+			screen := newReviewScreen(db, time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local))
+			if err := screen.load(); err != nil {
+				return err
+			}
+			return screen.app.Run()
+		},
+	}
+}
+
+func newReviewScreen(db *DB, day time.Time) *reviewScreen {
+	s := &reviewScreen{db: db, day: day, app: tview.NewApplication()}
+
+	s.headerView = tview.NewTextView().SetDynamicColors(true)
+	s.table = tview.NewTable().SetSelectable(true, false).SetFixed(1, 0)
+	s.table.SetBorder(true).SetTitle(" Blocks ")
+	s.table.SetInputCapture(s.handleKey)
+	s.sideView = tview.NewTextView().SetDynamicColors(true).SetWordWrap(true)
+	s.sideView.SetBorder(true).SetTitle(" Projects ")
+	s.input = tview.NewInputField()
+	s.statusView = tview.NewTextView().SetDynamicColors(true)
+
+	splitFlex := tview.NewFlex().
+		AddItem(s.table, 0, 3, true).
+		AddItem(s.sideView, 32, 0, false)
+	mainFlex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(s.headerView, 1, 0, false).
+		AddItem(splitFlex, 0, 1, true).
+		AddItem(s.input, 1, 0, false).
+		AddItem(s.statusView, 1, 0, false)
+	s.app.SetRoot(mainFlex, true)
+	return s
+}
+
+// Reload the day's blocks and the known projects, and redraw
+func (s *reviewScreen) load() error {
+	now := time.Now()
+	until := s.day.AddDate(0, 0, 1)
+	records, err := getStoredActivities(s.db, s.day, until)
+	if err != nil {
+		return err
+	}
+	s.blocks = coalesceBlocks(records, s.day, until, now)
+	if s.projects, err = getLabelNames(s.db, "projects"); err != nil {
+		return err
+	}
+	s.draw()
+	return nil
+}
+
+func (s *reviewScreen) draw() {
+	var total, labeled time.Duration
+	for _, block := range s.blocks {
+		duration := block.End.Sub(block.Start)
+		total += duration
+		if labels := block.labels(); len(labels.Projects) > 0 || slices.Contains(labels.Tags, personalTag) {
+			labeled += duration
+		}
+	}
+	s.headerView.SetText(fmt.Sprintf(" [cyan]%s[white]  %d blocks, %s tracked, %s unlabeled",
+		s.day.Format("Monday, January 2, 2006"), len(s.blocks), formatTime(total), formatTime(total-labeled)))
+
+	selected, _ := s.table.GetSelection()
+	s.table.Clear()
+	for col, title := range []string{"Time", "Duration", "Activity", "Labels"} {
+		s.table.SetCell(0, col, tview.NewTableCell(title).SetTextColor(tcell.ColorYellow).SetSelectable(false))
+	}
+	for i, block := range s.blocks {
+		labels := block.labels()
+		var text []string
+		for _, project := range labels.Projects {
+			text = append(text, "[green]@"+tview.Escape(project)+"[white]")
+		}
+		for _, tag := range labels.Tags {
+			color := "yellow"
+			if tag == personalTag {
+				color = "gray"
+			}
+			text = append(text, "["+color+"]#"+tview.Escape(tag)+"[white]")
+		}
+		s.table.SetCell(i+1, 0, tview.NewTableCell(block.Start.Format("15:04")+"–"+block.End.Format("15:04")+"  "))
+		s.table.SetCell(i+1, 1, tview.NewTableCell(formatTime(block.End.Sub(block.Start))+"  ").SetAlign(tview.AlignRight))
+		s.table.SetCell(i+1, 2, tview.NewTableCell(tview.Escape(truncateString(block.Name, 40))).SetExpansion(1))
+		s.table.SetCell(i+1, 3, tview.NewTableCell(strings.Join(text, " ")).SetExpansion(1))
+	}
+	if selected < 1 {
+		selected = 1
+	}
+	s.table.Select(min(selected, len(s.blocks)), 0)
+
+	var side strings.Builder
+	for i, project := range s.projects[:min(len(s.projects), reviewProjectKeys)] {
+		fmt.Fprintf(&side, "[yellow]%d[white] %s\n", i+1, tview.Escape(project))
+	}
+	if len(s.projects) > 0 {
+		side.WriteString("\n")
+	}
+	side.WriteString(`[yellow]0[white] clear project
+[yellow]n[white] new project
+[yellow]t[white] toggle a tag
+[yellow]p[white] toggle personal
+[yellow]a[white] apply to all blocks
+  of this activity
+[yellow]u[white] undo
+[yellow]q[white] quit`)
+	s.sideView.SetText(side.String())
+}
+
+func (s *reviewScreen) selectedBlock() (reviewBlock, bool) {
+	row, _ := s.table.GetSelection()
+	if row < 1 || row > len(s.blocks) {
+		return reviewBlock{}, false
+	}
+	return s.blocks[row-1], true
+}
+
+func (s *reviewScreen) handleKey(event *tcell.EventKey) *tcell.EventKey {
+	block, ok := s.selectedBlock()
+	if event.Key() == tcell.KeyEscape || event.Rune() == 'q' {
+		s.app.Stop()
+		return nil
+	}
+	if event.Key() != tcell.KeyRune {
+		return event
+	}
+
+	switch key := event.Rune(); {
+	case key == 'u':
+		if id, err := undoEdit(s.db, 0); err != nil {
+			s.setStatus("[red]" + tview.Escape(err.Error()) + "[white]")
+		} else {
+			s.setStatus(fmt.Sprintf("Undid edit %d", id))
+		}
+		s.reload()
+
+	case !ok:
+		return event
+
+	case key >= '0' && key <= '9':
+		index := int(key - '1')
+		if key == '0' {
+			s.labelBlocks([]reviewBlock{block}, func(labels ActivityRecord) ActivityRecord {
+				labels.Projects = nil
+				return labels
+			})
+		} else if index < len(s.projects) {
+			s.setProject(block, s.projects[index])
+		}
+
+	case key == 'n':
+		s.prompt("New project: ", func(name string) { s.setProject(block, name) })
+
+	case key == 't':
+		s.prompt("Toggle tag: ", func(name string) { s.toggleTag(block, name) })
+
+	case key == 'p':
+		s.toggleTag(block, personalTag)
+
+	case key == 'a':
+		labels := block.labels()
+		var similar []reviewBlock
+		for _, other := range s.blocks {
+			if other.Name == block.Name {
+				similar = append(similar, other)
+			}
+		}
+		s.labelBlocks(similar, func(ActivityRecord) ActivityRecord { return labels })
+
+	default:
+		return event
+	}
+	return nil
+}
+
+func (s *reviewScreen) setProject(block reviewBlock, project string) {
+	s.labelBlocks([]reviewBlock{block}, func(labels ActivityRecord) ActivityRecord {
+		labels.Projects = []string{project}
+		return labels
+	})
+}
+
+func (s *reviewScreen) toggleTag(block reviewBlock, tag string) {
+	hasTag := slices.Contains(block.labels().Tags, tag)
+	s.labelBlocks([]reviewBlock{block}, func(labels ActivityRecord) ActivityRecord {
+		if hasTag {
+			labels.Tags = removeLabel(labels.Tags, tag)
+		} else {
+			labels.Tags = addLabel(labels.Tags, tag)
+		}
+		return labels
+	})
+}
+
+// Relabel the activities of blocks as one edit, so it can be undone
+func (s *reviewScreen) labelBlocks(blocks []reviewBlock, relabel func(ActivityRecord) ActivityRecord) {
+	defer s.reload()
+	editor, err := beginEdit(s.db, "review --date "+s.day.Format("2006-01-02"))
+	if err != nil {
+		s.setStatus("[red]" + tview.Escape(err.Error()) + "[white]")
+		return
+	}
+	defer editor.rollback()
+	for _, block := range blocks {
+		for _, record := range block.Records {
+			labels := relabel(ActivityRecord{Projects: record.Projects, Tags: record.Tags})
+			if err := editor.label(record, labels.Projects, labels.Tags); err != nil {
+				s.setStatus("[red]" + tview.Escape(err.Error()) + "[white]")
+				return
+			}
+		}
+	}
+	if err := editor.commit(); err != nil {
+		s.setStatus("[red]" + tview.Escape(err.Error()) + "[white]")
+		return
+	}
+	if editor.editID != 0 {
+		s.setStatus(fmt.Sprintf("Labeled %s (edit %d)", blocks[0].Name, editor.editID))
+	}
+}
+
+func (s *reviewScreen) reload() {
+	if err := s.load(); err != nil {
+		s.setStatus("[red]" + tview.Escape(err.Error()) + "[white]")
+	}
+}
+
+func (s *reviewScreen) setStatus(status string) {
+	s.statusView.SetText(" " + status)
+}
+
+// Ask for a name in the input line, then return to the blocks
+func (s *reviewScreen) prompt(label string, done func(string)) {
+	s.input.SetLabel(label).SetText("")
+	s.input.SetDoneFunc(func(key tcell.Key) {
+		name := strings.TrimSpace(s.input.GetText())
+		s.input.SetLabel("").SetText("")
+		s.app.SetFocus(s.table)
+		if key == tcell.KeyEnter && name != "" {
+			done(name)
+		}
+	})
+	s.app.SetFocus(s.input)
+}
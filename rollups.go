@@ -0,0 +1,377 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+const compactBatchSize = 1000
+
+// The time spent on an activity during an hour, kept in place of the raw activities
+// once they're older than the retention period. Window titles, URLs and files are
+// dropped, while labels, category, source and language are kept.
+type ActivityRollup struct {
+	Hour     time.Time
+	Name     string
+	App      string
+	DeviceID string
+	Hostname string
+	Projects []string
+	Tags     []string
+	Category string
+	Source   string
+	Language string
+	Duration time.Duration
+}
+
+// Get the rollup as an activity, for grouping it like one
+func (r ActivityRollup) record() ActivityRecord {
+	return ActivityRecord{StartTime: r.Hour, Name: r.Name, App: r.App, DeviceID: r.DeviceID, Hostname: r.Hostname,
+		Projects: r.Projects, Tags: r.Tags, Category: r.Category, Source: r.Source, Language: r.Language}
+}
+
+const rollupColumns = `hour, activity_name, app_name, device_id, hostname, projects, tags, category, source, language, seconds`
+
+func createRollupsTable(table string) string {
+	return `
+		CREATE TABLE IF NOT EXISTS ` + table + ` (
+			hour TIMESTAMP NOT NULL,
+			activity_name TEXT NOT NULL,
+			app_name TEXT NOT NULL,
+			device_id TEXT NOT NULL,
+			hostname TEXT NOT NULL,
+			projects TEXT NOT NULL DEFAULT '',
+			tags TEXT NOT NULL DEFAULT '',
+			category TEXT NOT NULL DEFAULT '',
+			source TEXT NOT NULL DEFAULT '',
+			language TEXT NOT NULL DEFAULT '',
+			seconds DOUBLE PRECISION NOT NULL,
+			PRIMARY KEY (hour, activity_name, app_name, device_id, projects, tags, category, source, language)
+		)`
+}
+
+func (db *DB) setupRollups() error {
+	if _, err := db.Exec(createRollupsTable("activity_rollups")); err != nil {
+		return err
+	}
+	return db.migrateRollupKey()
+}
+
+// Rebuild the rollups table from before rollups kept labels, category, source and
+// language, since they're part of its primary key
+func (db *DB) migrateRollupKey() error {
+	exists, err := db.hasColumn("activity_rollups", "projects")
+	if err != nil || exists {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, statement := range []string{
+		createRollupsTable("activity_rollups_new"),
+		`INSERT INTO activity_rollups_new (hour, activity_name, app_name, device_id, hostname, seconds)
+			SELECT hour, activity_name, app_name, device_id, hostname, seconds FROM activity_rollups`,
+		`DROP TABLE activity_rollups`,
+		`ALTER TABLE activity_rollups_new RENAME TO activity_rollups`,
+	} {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Join labels as they're stored in a rollup, sorted so that the same set always
+// gives the same rollup
+func rollupLabels(labels []string) string {
+	labels = slices.Clone(labels)
+	slices.Sort(labels)
+	return joinLabels(labels)
+}
+
+// The values of rollupColumns
+func (r ActivityRollup) values() []any {
+	return []any{dbTime(r.Hour), r.Name, r.App, r.DeviceID, r.Hostname, rollupLabels(r.Projects), rollupLabels(r.Tags),
+		r.Category, r.Source, r.Language, r.Duration.Seconds()}
+}
+
+// Get the time before which activities are rolled up: the start of the day rawDays
+// days ago, or the zero time if raw activities are kept forever
+func rollupCutoff(rawDays int, now time.Time) time.Time {
+	if rawDays <= 0 {
+		return time.Time{}
+	}
+	now = now.Local()
+	return time.Date(now.Year(), now.Month(), now.Day()-rawDays, 0, 0, 0, 0, time.Local)
+}
+
+// Roll up the parts of ended activities before the cutoff into hourly totals. Activities
+// that end before the cutoff are deleted, and ones that cross it are trimmed to start
+// at it. Each batch is committed with its rollups, so an interrupted compaction never
+// counts time twice. Only the given device's activities are rolled up, or every
+// device's if it's empty. Returns the number of activities rolled up.
+func compactActivities(db *DB, cutoff time.Time, deviceID string) (int, error) {
+	if cutoff.IsZero() {
+		return 0, nil
+	}
+
+	deviceFilter := ""
+	if deviceID != "" {
+		deviceFilter = "AND (device_id = ? OR device_id = '')"
+	}
+
+	var compacted int
+	var lastID int64
+	for {
+		args := []any{dbTime(cutoff), lastID}
+		if deviceID != "" {
+			args = append(args, deviceID)
+		}
+		rows, err := db.Query(db.rebind(`
+			SELECT `+activityColumns+`
+			FROM activities
+			WHERE end_time IS NOT NULL AND start_time < ? AND id > ? `+deviceFilter+`
+			ORDER BY id
+			LIMIT ?
+		`), append(args, compactBatchSize)...)
+		if err != nil {
+			return compacted, fmt.Errorf("error querying database: %v", err)
+		}
+		batch, err := scanActivities(rows)
+		rows.Close()
+		if err != nil {
+			return compacted, err
+		}
+		if len(batch) == 0 {
+			return compacted, nil
+		}
+
+		n, err := compactBatch(db, batch, cutoff)
+		if err != nil {
+			return compacted, err
+		}
+		lastID = batch[len(batch)-1].ID
+		compacted += n
+	}
+}
+
+// Roll up a batch of activities and return how many were rolled up. Activities
+// that changed since they were read, e.g. because another process compacted them
+// first, are left alone.
+func compactBatch(db *DB, batch []ActivityRecord, cutoff time.Time) (int, error) {
+	type rollupKey struct {
+		hour                                                            time.Time
+		name, app, deviceID, projects, tags, category, source, language string
+	}
+	rollups := make(map[rollupKey]*ActivityRollup)
+	var keys []rollupKey
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	now := dbTime(time.Now())
+	compacted := 0
+	for i, record := range batch {
+		var result sql.Result
+		if record.EndTime.After(cutoff) {
+			result, err = tx.Exec(db.rebind(`
+				UPDATE activities SET start_time = ?, updated_at = ?, stored_at = ?
+				WHERE id = ? AND start_time = ? AND end_time = ?
+			`), dbTime(cutoff), now, now, record.ID, dbTime(record.StartTime), dbTime(*record.EndTime))
+		} else {
+			result, err = tx.Exec(db.rebind(`DELETE FROM activities WHERE id = ? AND start_time = ? AND end_time = ?`),
+				record.ID, dbTime(record.StartTime), dbTime(*record.EndTime))
+		}
+		var affected int64
+		if err == nil {
+			affected, err = result.RowsAffected()
+		}
+		if err == nil && affected == 1 {
+			err = db.loadLabels(tx, batch[i:i+1])
+			record = batch[i]
+		}
+		if err == nil && affected == 1 && !record.EndTime.After(cutoff) {
+			err = db.deleteLabels(tx, record.ID)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error compacting activity %d: %v", record.ID, err)
+		}
+		if affected != 1 {
+			continue
+		}
+		compacted++
+
+		end := *record.EndTime
+		if end.After(cutoff) {
+			end = cutoff
+		}
+		for start := record.StartTime; start.Before(end); {
+			t := start.Local()
+			hour := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, time.Local)
+			next := hour.Add(time.Hour)
+			if next.After(end) {
+				next = end
+			}
+
+			key := rollupKey{hour, record.Name, record.App, record.DeviceID, rollupLabels(record.Projects),
+				rollupLabels(record.Tags), record.Category, record.Source, record.Language}
+			if rollups[key] == nil {
+				rollups[key] = &ActivityRollup{Hour: hour, Name: record.Name, App: record.App, DeviceID: record.DeviceID,
+					Hostname: record.Hostname, Projects: record.Projects, Tags: record.Tags, Category: record.Category,
+					Source: record.Source, Language: record.Language}
+				keys = append(keys, key)
+			}
+			rollups[key].Duration += next.Sub(start)
+			start = next
+		}
+	}
+
+	stmt, err := tx.Prepare(db.rebind(`
+		INSERT INTO activity_rollups (` + rollupColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (hour, activity_name, app_name, device_id, projects, tags, category, source, language)
+		DO UPDATE SET seconds = activity_rollups.seconds + excluded.seconds, hostname = excluded.hostname
+	`))
+	if err != nil {
+		return 0, fmt.Errorf("error preparing rollup insert: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, key := range keys {
+		if _, err := stmt.Exec(rollups[key].values()...); err != nil {
+			return 0, fmt.Errorf("error saving rollup: %v", err)
+		}
+	}
+
+	return compacted, tx.Commit()
+}
+
+// Get the rollups for the hours that overlap the given time range
+func getRollups(db *DB, since, until time.Time) ([]ActivityRollup, error) {
+	rows, err := db.Query(db.rebind(`
+		SELECT `+rollupColumns+`
+		FROM activity_rollups
+		WHERE hour > ? AND hour < ?
+		ORDER BY hour, activity_name
+	`), dbTime(since.Add(-time.Hour)), dbTime(until))
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	var rollups []ActivityRollup
+	for rows.Next() {
+		var rollup ActivityRollup
+		var projects, tags string
+		var seconds float64
+		if err := rows.Scan(&rollup.Hour, &rollup.Name, &rollup.App, &rollup.DeviceID, &rollup.Hostname,
+			&projects, &tags, &rollup.Category, &rollup.Source, &rollup.Language, &seconds); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		rollup.Projects, rollup.Tags = splitLabels(projects), splitLabels(tags)
+		rollup.Hour = localTime(rollup.Hour)
+		rollup.Duration = secondsDuration(seconds)
+		rollups = append(rollups, rollup)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rows: %v", err)
+	}
+	return rollups, nil
+}
+
+// Copy all rollups into another database in a single transaction
+func copyRollups(from, to *DB) error {
+	rollups, err := getRollups(from, time.Time{}, time.Now().AddDate(1, 0, 0))
+	if err != nil {
+		return err
+	}
+
+	// Replace the destination's rollups, since the source may have compacted more
+	// activities since the last copy
+	tx, err := to.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM activity_rollups`); err != nil {
+		return fmt.Errorf("error clearing destination rollups: %v", err)
+	}
+
+	stmt, err := tx.Prepare(to.rebind(`
+		INSERT INTO activity_rollups (` + rollupColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`))
+	if err != nil {
+		return fmt.Errorf("error preparing rollup insert: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, rollup := range rollups {
+		if _, err := stmt.Exec(rollup.values()...); err != nil {
+			return fmt.Errorf("error copying rollup: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Copied %d hourly rollups\n", len(rollups))
+	return nil
+}
+
+func dbCompactCmd(c *cli.Context) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	rawDays := cfg.Retention.RawDays
+	if c.IsSet("raw-days") {
+		rawDays = c.Int("raw-days")
+	}
+	if rawDays <= 0 {
+		return fmt.Errorf("no retention period configured; set retention.rawDays in the config or pass --raw-days")
+	}
+
+	db, err := getDb()
+	if err != nil {
+		return fmt.Errorf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	var deviceID string
+	if !c.Bool("all-devices") {
+		device, err := getDevice()
+		if err != nil {
+			return err
+		}
+		deviceID = device.ID
+	}
+	cutoff := rollupCutoff(rawDays, time.Now())
+	compacted, err := compactActivities(db, cutoff, deviceID)
+	if err != nil {
+		return err
+	}
+	deleted, err := compactCommands(db, cutoff, deviceID)
+	if err != nil {
+		return err
+	}
+	if db.dbType == "sqlite" && c.Bool("vacuum") {
+		if _, err := db.Exec("VACUUM"); err != nil {
+			return fmt.Errorf("error vacuuming database: %v", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Rolled up %d activities and deleted %d shell commands before %s\n",
+		compacted, deleted, cutoff.Format("2006-01-02"))
+	return nil
+}
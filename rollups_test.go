@@ -0,0 +1,137 @@
+package main
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestCompactActivities(t *testing.T) {
+	db := openTestDb(t)
+	start := time.Date(2024, 11, 5, 9, 30, 0, 0, time.Local)
+	cutoff := start.Add(24 * time.Hour)
+	for _, deviceID := range []string{"laptop", "desktop"} {
+		end := start.Add(time.Hour)
+		if _, err := db.insertActivity(db, ActivityRecord{StartTime: start, EndTime: &end, Name: "Code", DeviceID: deviceID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a batch read by two processes at once is only rolled up once
+	batch, err := getStoredActivities(db, start, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptop := batch[:1]
+	for i, want := range []int{1, 0} {
+		if compacted, err := compactBatch(db, laptop, cutoff); err != nil || compacted != want {
+			t.Errorf("compaction %d: got %d, %v, want %d rolled up", i+1, compacted, err, want)
+		}
+	}
+	// and the desktop's activity is left to the desktop
+	if compacted, err := compactActivities(db, cutoff, "laptop"); err != nil || compacted != 0 {
+		t.Errorf("got %d, %v, want nothing left to roll up", compacted, err)
+	}
+
+	rollups, err := getRollups(db, start, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total time.Duration
+	for _, rollup := range rollups {
+		if rollup.DeviceID != "laptop" {
+			t.Errorf("unexpected rollup %+v", rollup)
+		}
+		total += rollup.Duration
+	}
+	if total != time.Hour {
+		t.Errorf("rolled up %s, want an hour", total)
+	}
+	if remaining, err := getStoredActivities(db, start, cutoff); err != nil || len(remaining) != 1 || remaining[0].DeviceID != "desktop" {
+		t.Errorf("got %+v, %v, want the desktop's activity", remaining, err)
+	}
+}
+
+// Labels, category, source and language are kept in rollups, so a labeled activity
+// still counts toward its project
+func TestCompactKeepsLabels(t *testing.T) {
+	db := openTestDb(t)
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	end := start.Add(90 * time.Minute)
+	for _, record := range []ActivityRecord{
+		{StartTime: start, EndTime: &end, Name: "Code", App: "Code", Projects: []string{"b", "a"}, Tags: []string{"review"},
+			Category: "Development", Language: "go"},
+		{StartTime: start, EndTime: &end, Name: "Code", App: "Code", Projects: []string{"a", "b"}, Tags: []string{"review"},
+			Category: "Development", Language: "go", DeviceID: "desktop"},
+		{StartTime: start, EndTime: &end, Name: "Code", App: "Code"},
+		{StartTime: start, EndTime: &end, Name: "Code", App: "Code", Source: manualSource, Projects: []string{"a"}},
+	} {
+		if _, err := db.insertActivity(db, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := compactActivities(db, start.Add(24*time.Hour), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	rollups, err := getRollups(db, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	projects := make(map[string]time.Duration)
+	var sources []string
+	for _, rollup := range rollups {
+		for _, project := range rollup.record().Projects {
+			projects[project] += rollup.Duration
+		}
+		if rollup.Source != "" {
+			sources = append(sources, rollup.Source)
+		}
+		if len(rollup.Tags) > 0 && (rollup.Category != "Development" || rollup.Language != "go") {
+			t.Errorf("rollup %+v lost its category or language", rollup)
+		}
+	}
+	if want := map[string]time.Duration{"a": 270 * time.Minute, "b": 180 * time.Minute}; !maps.Equal(projects, want) {
+		t.Errorf("got project totals %v, want %v", projects, want)
+	}
+	if len(sources) != 2 || sources[0] != manualSource {
+		t.Errorf("got sources %v, want the manual entry's two hours", sources)
+	}
+	if len(rollups) != 8 {
+		t.Errorf("got %d rollups, want 4 per hour", len(rollups))
+	}
+}
+
+// Rollups from before they kept labels are moved to the new table
+func TestMigrateRollupKey(t *testing.T) {
+	db := openTestDb(t)
+	for _, statement := range []string{
+		`DROP TABLE activity_rollups`,
+		`CREATE TABLE activity_rollups (
+			hour TIMESTAMP NOT NULL,
+			activity_name TEXT NOT NULL,
+			app_name TEXT NOT NULL,
+			device_id TEXT NOT NULL,
+			hostname TEXT NOT NULL,
+			seconds DOUBLE PRECISION NOT NULL,
+			PRIMARY KEY (hour, activity_name, app_name, device_id)
+		)`,
+		`INSERT INTO activity_rollups VALUES ('2024-11-05 09:00:00', 'Code', 'Code', '', '', 1800)`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.setupRollups(); err != nil {
+		t.Fatal(err)
+	}
+
+	hour := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	rollups, err := getRollups(db, hour, hour.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rollups) != 1 || rollups[0].Name != "Code" || rollups[0].Duration != 30*time.Minute {
+		t.Errorf("got %+v, want the old rollup", rollups)
+	}
+}
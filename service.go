@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/urfave/cli/v2"
+)
+
+//go:embed services/*
+var serviceTemplates embed.FS
+
+const (
+	launchdLabel    = "com.activitymon.monitor"
+	systemdUnitName = "activitymon.service"
+)
+
+type serviceDefinition struct {
+	Label      string
+	Args       []string
+	ExecStart  string
+	StdoutPath string // launchd only; under systemd, output that doesn't go to the log file is in the journal
+	StderrPath string
+	LogPath    string
+}
+
+func serviceCmd() *cli.Command {
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:  "dir",
+			Usage: "Directory for the service file, instead of the default launchd/systemd location. The service isn't loaded when this is set",
+		},
+		&cli.StringFlag{
+			Name:  "os",
+			Usage: "Service manager to use: darwin (launchd) or linux (systemd)",
+			Value: runtime.GOOS,
+		},
+	}
+
+	return &cli.Command{
+		Name:  "service",
+		Usage: "Run the headless monitor as a login service",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "install",
+				Usage:  "Install and start the login service",
+				Flags:  flags,
+				Action: serviceInstallCmd,
+			},
+			{
+				Name:   "uninstall",
+				Usage:  "Stop and remove the login service",
+				Flags:  flags,
+				Action: serviceUninstallCmd,
+			},
+			{
+				Name:   "status",
+				Usage:  "Show the status of the login service",
+				Flags:  flags,
+				Action: serviceStatusCmd,
+			},
+		},
+	}
+}
+
+// Get the path of the service file for the given OS
+func getServicePath(goos, dir string) (string, error) {
+	var name, defaultDir string
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to get home directory: %v", err)
+	}
+
+	switch goos {
+	case "darwin":
+		name = launchdLabel + ".plist"
+		defaultDir = filepath.Join(homeDir, "Library", "LaunchAgents")
+	case "linux":
+		name = systemdUnitName
+		defaultDir = filepath.Join(homeDir, ".config", "systemd", "user")
+	default:
+		return "", fmt.Errorf("unsupported service platform: %s", goos)
+	}
+
+	if dir == "" {
+		dir = defaultDir
+	}
+	return filepath.Join(dir, name), nil
+}
+
+func newServiceDefinition() (*serviceDefinition, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("unable to find the activitymon executable: %v", err)
+	}
+	configDir, err := getConfigDir()
+	if err != nil {
+		return nil, err
+	}
+
+	logPath := filepath.Join(configDir, "activitymon.log")
+	args := []string{executable, "monitor", "--headless", "--log-file", logPath}
+	quotedArgs := make([]string, len(args))
+	for i, arg := range args {
+		quotedArgs[i] = systemdQuote(arg)
+	}
+
+	return &serviceDefinition{
+		Label:      launchdLabel,
+		Args:       args,
+		ExecStart:  strings.Join(quotedArgs, " "),
+		StdoutPath: filepath.Join(configDir, "activitymon.out.log"),
+		StderrPath: filepath.Join(configDir, "activitymon.err.log"),
+		LogPath:    logPath,
+	}, nil
+}
+
+// Render the launchd plist or systemd unit for the given OS
+func renderService(goos string, def *serviceDefinition) ([]byte, error) {
+	var templateName string
+	switch goos {
+	case "darwin":
+		templateName = "launchd.plist"
+	case "linux":
+		templateName = "systemd.service"
+	default:
+		return nil, fmt.Errorf("unsupported service platform: %s", goos)
+	}
+
+	funcs := template.FuncMap{"xml": template.HTMLEscapeString}
+	tmpl, err := template.New(templateName).Funcs(funcs).ParseFS(serviceTemplates, "services/"+templateName)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, def); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func serviceInstallCmd(c *cli.Context) error {
+	goos := c.String("os")
+	path, err := getServicePath(goos, c.String("dir"))
+	if err != nil {
+		return err
+	}
+
+	def, err := newServiceDefinition()
+	if err != nil {
+		return err
+	}
+	data, err := renderService(goos, def)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("unable to create service directory: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("unable to write service file: %v", err)
+	}
+	fmt.Printf("Wrote %s\n", path)
+
+	if c.String("dir") != "" {
+		return nil
+	}
+
+	switch goos {
+	case "darwin":
+		err = runServiceManager("launchctl", "load", "-w", path)
+	case "linux":
+		if err = runServiceManager("systemctl", "--user", "daemon-reload"); err == nil {
+			err = runServiceManager("systemctl", "--user", "enable", "--now", systemdUnitName)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Service started, logging to %s\n", def.LogPath)
+	return nil
+}
+
+func serviceUninstallCmd(c *cli.Context) error {
+	goos := c.String("os")
+	path, err := getServicePath(goos, c.String("dir"))
+	if err != nil {
+		return err
+	}
+
+	if c.String("dir") == "" {
+		switch goos {
+		case "darwin":
+			err = runServiceManager("launchctl", "unload", "-w", path)
+		case "linux":
+			err = runServiceManager("systemctl", "--user", "disable", "--now", systemdUnitName)
+		}
+		if err != nil {
+			fmt.Printf("Error stopping service: %v\n", err)
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("service is not installed (%s not found)", path)
+		}
+		return fmt.Errorf("unable to remove service file: %v", err)
+	}
+	fmt.Printf("Removed %s\n", path)
+
+	if goos == "linux" && c.String("dir") == "" {
+		return runServiceManager("systemctl", "--user", "daemon-reload")
+	}
+	return nil
+}
+
+func serviceStatusCmd(c *cli.Context) error {
+	goos := c.String("os")
+	path, err := getServicePath(goos, c.String("dir"))
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		fmt.Printf("Service is not installed (%s not found)\n", path)
+		return nil
+	}
+	fmt.Printf("Service file: %s\n", path)
+
+	if c.String("dir") != "" {
+		return nil
+	}
+
+	var cmd *exec.Cmd
+	switch goos {
+	case "darwin":
+		cmd = exec.Command("launchctl", "list", launchdLabel)
+	case "linux":
+		cmd = exec.Command("systemctl", "--user", "status", systemdUnitName)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// systemctl status exits non-zero when the service isn't running
+	cmd.Run()
+	return nil
+}
+
+func runServiceManager(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s error: %v, stderr: %s", name, err, stderr.String())
+	}
+	return nil
+}
+
+// Quote an argument for a systemd ExecStart line
+func systemdQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\$%") {
+		return s
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, `$`, `$$`)
+	s = strings.ReplaceAll(s, `%`, `%%`)
+	return `"` + s + `"`
+}
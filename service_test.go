@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Install the service into a temporary directory, with a home directory that needs quoting
+func installTestService(t *testing.T, goos string) (data string, def *serviceDefinition) {
+	t.Helper()
+	t.Setenv("HOME", filepath.Join(t.TempDir(), `My "Home" 100%`))
+	dir := t.TempDir()
+
+	app := &cli.App{Commands: []*cli.Command{serviceCmd()}}
+	if err := app.Run([]string{"activitymon", "service", "install", "--dir", dir, "--os", goos}); err != nil {
+		t.Fatal(err)
+	}
+	path, err := getServicePath(goos, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err = newServiceDefinition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents), def
+}
+
+// Split an ExecStart line into arguments the way systemd does
+func splitExecStart(line string) []string {
+	var args []string
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimLeft(line, " ") {
+		if line[0] != '"' {
+			arg, rest, _ := strings.Cut(line, " ")
+			args, line = append(args, arg), rest
+			continue
+		}
+		var arg strings.Builder
+		i := 1
+		for ; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+			}
+			arg.WriteByte(line[i])
+		}
+		args, line = append(args, arg.String()), line[i+1:]
+	}
+	for i, arg := range args {
+		args[i] = strings.NewReplacer("$$", "$", "%%", "%").Replace(arg)
+	}
+	return args
+}
+
+func TestSystemdService(t *testing.T) {
+	data, def := installTestService(t, "linux")
+
+	var execStart string
+	for _, line := range strings.Split(data, "\n") {
+		if value, ok := strings.CutPrefix(line, "ExecStart="); ok {
+			execStart = value
+		}
+		if strings.HasPrefix(line, "StandardOutput=") || strings.HasPrefix(line, "StandardError=") {
+			t.Errorf("unexpected %q: systemd doesn't unquote these paths", line)
+		}
+	}
+	if got := splitExecStart(execStart); !slices.Equal(got, def.Args) {
+		t.Errorf("ExecStart runs %q, want %q", got, def.Args)
+	}
+	if !slices.Contains(def.Args, def.LogPath) || !strings.Contains(def.LogPath, `My "Home" 100%`) {
+		t.Errorf("monitor doesn't log to %q: %q", def.LogPath, def.Args)
+	}
+}
+
+func TestLaunchdService(t *testing.T) {
+	data, def := installTestService(t, "darwin")
+
+	var plist struct {
+		Dict struct {
+			Keys    []string `xml:"key"`
+			Strings []string `xml:"string"`
+			Array   struct {
+				Strings []string `xml:"string"`
+			} `xml:"array"`
+		} `xml:"dict"`
+	}
+	if err := xml.Unmarshal([]byte(data), &plist); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(plist.Dict.Array.Strings, def.Args) {
+		t.Errorf("ProgramArguments are %q, want %q", plist.Dict.Array.Strings, def.Args)
+	}
+	if want := []string{launchdLabel, def.StdoutPath, def.StderrPath}; !slices.Equal(plist.Dict.Strings, want) {
+		t.Errorf("got %q, want %q", plist.Dict.Strings, want)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -13,11 +14,14 @@ import (
 const (
 	MaxActivityLength = 50
 	BarChartWidth     = 50
+
+	MaxCommandsPerActivity = 5
 )
 
 type Activity struct {
-	Name     string
-	Duration time.Duration
+	Name      string
+	Duration  time.Duration
+	Breakdown []Activity // time per shell command, for terminals
 }
 
 type SummaryData struct {
@@ -26,89 +30,501 @@ type SummaryData struct {
 	TimePeriod    time.Duration
 }
 
+type SummaryOptions struct {
+	Start      time.Time
+	End        time.Time
+	GroupBy    string              // "activity" (default), "category", "device", "hour", "day", "language", "file", "title" or "meeting"
+	Categories map[string][]string // used when grouping by category
+	DeviceID   string              // only summarize this device's activities, if set
+	Commands   []shellCommand      // broken down under the activities they ran in, when grouping by activity
+	Events     []calendarEvent     // used when grouping by meeting
+}
+
+var summaryGroups = []string{"activity", "category", "device", "hour", "day", "language", "file", "title", "meeting"}
+
+// Groups for time without editor data, when grouping by language or file, and
+// without a window title
+const (
+	noLanguage = "(no language)"
+	noFile     = "(no file)"
+	noTitle    = "(no title)"
+)
+
 func getSummaryData(db *DB, startTime time.Time) (*SummaryData, error) {
-	now := time.Now()
-	timeDelta := now.Sub(startTime)
+	return getGroupedSummaryData(db, SummaryOptions{Start: startTime, End: time.Now()})
+}
 
-	var query string
-	if db.dbType == "postgres" {
-		query = `
-		SELECT
-			activity_name,
-			EXTRACT(EPOCH FROM (
-				CASE
-					WHEN COALESCE(end_time, CURRENT_TIMESTAMP) > $1 THEN $1
-					ELSE COALESCE(end_time, CURRENT_TIMESTAMP)
-				END
-				-
-				CASE
-					WHEN start_time < $3 THEN $3
-					ELSE start_time
-				END
-			)) AS duration_seconds
-		FROM activities
-		WHERE start_time < $5 AND (end_time > $6 OR end_time IS NULL)
-		GROUP BY activity_name
-		ORDER BY duration_seconds DESC`
-	} else {
-		query = `
-		SELECT
-			activity_name,
-			SUM(
-				JULIANDAY(
-					CASE
-						WHEN COALESCE(end_time, CURRENT_TIMESTAMP) > ? THEN ?
-						ELSE COALESCE(end_time, CURRENT_TIMESTAMP)
-					END
-				) - JULIANDAY(
-					CASE
-						WHEN start_time < ? THEN ?
-						ELSE start_time
-					END
-				)
-			) * 86400 AS duration_seconds
-		FROM activities
-		WHERE start_time < ? AND (end_time > ? OR end_time IS NULL)
-		GROUP BY activity_name
-		ORDER BY duration_seconds DESC`
+func getGroupedSummaryData(db *DB, opts SummaryOptions) (*SummaryData, error) {
+	rollups, err := getRollups(db, opts.Start, opts.End)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Commands, err = getShellCommands(db, opts.Start, opts.End); err != nil {
+		return nil, err
+	}
+	if opts.Events, err = getCalendarEvents(db, opts.Start, opts.End); err != nil {
+		return nil, err
+	}
+
+	totals, ok, err := getActivityTotals(db, opts, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		terminals, err := getCommandActivities(db, opts)
+		if err != nil {
+			return nil, err
+		}
+		return summarizeTotals(totals, terminals, rollups, opts)
 	}
 
-	rows, err := db.Query(query,
-		now.Format("2006-01-02 15:04:05"), now.Format("2006-01-02 15:04:05"),
-		startTime.Format("2006-01-02 15:04:05"), startTime.Format("2006-01-02 15:04:05"),
-		now.Format("2006-01-02 15:04:05"), startTime.Format("2006-01-02 15:04:05"))
+	records, err := getSummaryActivities(db, opts)
+	if err != nil {
+		return nil, err
+	}
+	return summarizeActivities(records, rollups, opts)
+}
+
+// Get the activities to summarize. Files and titles may be encrypted, so they're
+// only decrypted when grouping by them.
+func getSummaryActivities(db *DB, opts SummaryOptions) ([]ActivityRecord, error) {
+	if opts.GroupBy == "file" || opts.GroupBy == "title" {
+		return getActivities(db, opts.Start, opts.End)
+	}
+	return getStoredActivities(db, opts.Start, opts.End)
+}
+
+// Get the activities of the apps that shell commands ran in, to break down their time
+// by command
+func getCommandActivities(db *DB, opts SummaryOptions) ([]ActivityRecord, error) {
+	if (opts.GroupBy != "" && opts.GroupBy != "activity") || len(opts.Commands) == 0 {
+		return nil, nil
+	}
+	var apps []any
+	for _, command := range opts.Commands {
+		if !slices.Contains(apps, any(command.App)) {
+			apps = append(apps, command.App)
+		}
+	}
+	rows, err := db.Query(db.rebind(`
+		SELECT `+activityColumns+`
+		FROM activities
+		WHERE start_time < ? AND (end_time > ? OR end_time IS NULL) AND app_name IN (`+placeholders(len(apps))+`)
+		ORDER BY start_time, id
+	`), append([]any{dbTime(opts.End), dbTime(opts.Start)}, apps...)...)
 	if err != nil {
 		return nil, fmt.Errorf("error querying database: %v", err)
 	}
 	defer rows.Close()
+	return scanActivities(rows)
+}
+
+// The time spent on an activity name on a device, added up by the database
+type groupTotal struct {
+	Name, DeviceID, Hostname, Language, Category string
+	Duration                                     time.Duration
+}
+
+func (t groupTotal) record() ActivityRecord {
+	return ActivityRecord{Name: t.Name, DeviceID: t.DeviceID, Hostname: t.Hostname, Language: t.Language, Category: t.Category}
+}
+
+// Groupings that only need the time per activity name, device, language and category
+var totalGroups = []string{"activity", "category", "device", "language"}
+
+// Add up the time of the activities in the summary's range in the database, instead
+// of loading every activity. This isn't possible (and ok is false) when grouping by
+// time, file or meeting, when there are activities from several devices, which
+// may overlap and must then only be counted once, or when there are ongoing
+// activities that are no longer updated, which only count until they went stale.
+func getActivityTotals(db *DB, opts SummaryOptions, now time.Time) (totals []groupTotal, ok bool, err error) {
+	groupBy := opts.GroupBy
+	if groupBy == "" {
+		groupBy = "activity"
+	}
+	if !slices.Contains(totalGroups, groupBy) {
+		return nil, false, nil
+	}
+
+	filter, filterArgs := "", []any{}
+	if opts.DeviceID != "" {
+		filter, filterArgs = " AND device_id = ?", []any{opts.DeviceID}
+	} else {
+		var devices int
+		if err := db.QueryRow(db.rebind(`
+			SELECT COUNT(DISTINCT device_id)
+			FROM activities
+			WHERE start_time < ? AND (end_time > ? OR end_time IS NULL)
+		`), dbTime(opts.End), dbTime(opts.Start)).Scan(&devices); err != nil {
+			return nil, false, fmt.Errorf("error querying database: %v", err)
+		}
+		if devices > 1 {
+			return nil, false, nil
+		}
+	}
+	staleBefore := dbTime(now.Add(-openActivityTimeout))
+	var stale int
+	if err := db.QueryRow(db.rebind(`
+		SELECT COUNT(*)
+		FROM activities
+		WHERE end_time IS NULL AND start_time < ? AND start_time < ? AND (updated_at IS NULL OR updated_at < ?)
+	`), dbTime(opts.End), staleBefore, staleBefore).Scan(&stale); err != nil {
+		return nil, false, fmt.Errorf("error querying database: %v", err)
+	}
+	if stale > 0 {
+		return nil, false, nil
+	}
+
+	// Times are stored as local time without an offset, so durations are only the
+	// difference of the stored times while the offset stays the same. The range is
+	// added up in parts between daylight saving time changes.
+	duration := `MAX(0, strftime('%s', MIN(COALESCE(end_time, ?), ?)) - strftime('%s', MAX(start_time, ?)))`
+	if db.dbType == "postgres" {
+		duration = `GREATEST(0, EXTRACT(EPOCH FROM LEAST(COALESCE(end_time, CAST(? AS TIMESTAMP)), CAST(? AS TIMESTAMP)) - GREATEST(start_time, CAST(? AS TIMESTAMP))))`
+	}
+	query := db.rebind(`
+		SELECT activity_name, device_id, hostname, language, category, SUM(` + duration + `)
+		FROM activities
+		WHERE start_time < ? AND (end_time > ? OR end_time IS NULL)` + filter + `
+		GROUP BY activity_name, device_id, hostname, language, category
+	`)
 
-	var activities []Activity
-	var totalDuration time.Duration
+	byGroup := make(map[groupTotal]time.Duration)
+	boundaries := append(append([]time.Time{opts.Start}, zoneTransitions(opts.Start, opts.End)...), opts.End)
+	for i := 0; i+1 < len(boundaries); i++ {
+		_, offset := boundaries[i].Zone()
+		zone := time.FixedZone("", offset)
+		since, until := boundaries[i].In(zone).Format(dbTimeFormat), boundaries[i+1].In(zone).Format(dbTimeFormat)
+		ongoingEnd := now.In(zone).Format(dbTimeFormat)
 
-	for rows.Next() {
-		var activity string
-		var durationSeconds float64
-		if err := rows.Scan(&activity, &durationSeconds); err != nil {
-			return nil, fmt.Errorf("error scanning row: %v", err)
+		rows, err := db.Query(query, append([]any{ongoingEnd, until, since, until, since}, filterArgs...)...)
+		if err != nil {
+			return nil, false, fmt.Errorf("error querying database: %v", err)
 		}
-		duration := time.Duration(durationSeconds) * time.Second
-		if duration > timeDelta {
-			duration = timeDelta
+		for rows.Next() {
+			var group groupTotal
+			var seconds float64
+			if err := rows.Scan(&group.Name, &group.DeviceID, &group.Hostname, &group.Language, &group.Category, &seconds); err != nil {
+				rows.Close()
+				return nil, false, fmt.Errorf("error scanning row: %v", err)
+			}
+			byGroup[group] += secondsDuration(seconds)
 		}
-		activities = append(activities, Activity{Name: activity, Duration: duration})
-		totalDuration += duration
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, false, fmt.Errorf("error reading rows: %v", err)
+		}
+	}
+
+	for group, duration := range byGroup {
+		group.Duration = duration
+		totals = append(totals, group)
 	}
+	return totals, true, nil
+}
+
+// Find the times in a range at which the local UTC offset changes
+func zoneTransitions(start, end time.Time) []time.Time {
+	var transitions []time.Time
+	_, offset := start.Zone()
+	for t := start; t.Before(end); {
+		next := t.Add(24 * time.Hour)
+		if next.After(end) {
+			next = end
+		}
+		_, nextOffset := next.Zone()
+		if nextOffset == offset {
+			t = next
+			continue
+		}
+		for next.Sub(t) > time.Second {
+			middle := t.Add(next.Sub(t) / 2)
+			if _, middleOffset := middle.Zone(); middleOffset == offset {
+				t = middle
+			} else {
+				next = middle
+			}
+		}
+		transition := next.Truncate(time.Second)
+		transitions = append(transitions, transition)
+		t, offset = transition, nextOffset
+	}
+	return transitions
+}
+
+// Adds up the time per group for a summary
+type summaryBuilder struct {
+	opts             SummaryOptions
+	durations        map[string]time.Duration
+	commandDurations map[string]map[string]time.Duration
+	total            time.Duration
+}
+
+func newSummaryBuilder(opts SummaryOptions) (*summaryBuilder, error) {
+	if opts.GroupBy == "" {
+		opts.GroupBy = "activity"
+	}
+	if !slices.Contains(summaryGroups, opts.GroupBy) {
+		return nil, fmt.Errorf("unsupported summary grouping: %s (expected one of %s)",
+			opts.GroupBy, strings.Join(summaryGroups, ", "))
+	}
+	return &summaryBuilder{
+		opts:             opts,
+		durations:        make(map[string]time.Duration),
+		commandDurations: make(map[string]map[string]time.Duration),
+	}, nil
+}
+
+// Clip an activity to the summary's time range
+func (b *summaryBuilder) clip(record ActivityRecord, now time.Time) (time.Time, time.Time) {
+	start := record.StartTime
+	if start.Before(b.opts.Start) {
+		start = b.opts.Start
+	}
+	end := record.endOr(now)
+	if end.After(b.opts.End) {
+		end = b.opts.End
+	}
+	return start, end
+}
+
+func (b *summaryBuilder) addActivity(record ActivityRecord, now time.Time) {
+	start, end := b.clip(record, now)
+	b.addCommands(record, now)
+
+	// split activities that cross hour or day boundaries when grouping by time
+	for start.Before(end) {
+		key, next := summaryGroupKey(b.opts, record, start)
+		if next.IsZero() || next.After(end) {
+			next = end
+		}
+		b.durations[key] += next.Sub(start)
+		b.total += next.Sub(start)
+		start = next
+	}
+}
+
+// Break down the time of an activity by the shell commands that ran during it
+func (b *summaryBuilder) addCommands(record ActivityRecord, now time.Time) {
+	start, end := b.clip(record, now)
+	if b.opts.GroupBy != "activity" || len(b.opts.Commands) == 0 || !start.Before(end) {
+		return
+	}
+	if b.commandDurations[record.Name] == nil {
+		b.commandDurations[record.Name] = make(map[string]time.Duration)
+	}
+	commandTimes(record, b.opts.Commands, start, end, b.commandDurations[record.Name])
+}
+
+func (b *summaryBuilder) addTotal(total groupTotal) {
+	if total.Duration <= 0 {
+		return
+	}
+	key, _ := summaryGroupKey(b.opts, total.record(), b.opts.Start)
+	b.durations[key] += total.Duration
+	b.total += total.Duration
+}
+
+// Add rollups, counting those for hours that are only partly in the range in proportion
+func (b *summaryBuilder) addRollups(rollups []ActivityRollup) {
+	for _, rollup := range rollups {
+		if b.opts.DeviceID != "" && rollup.DeviceID != b.opts.DeviceID {
+			continue
+		}
+		start, end := rollup.Hour, rollup.Hour.Add(time.Hour)
+		if start.Before(b.opts.Start) {
+			start = b.opts.Start
+		}
+		if end.After(b.opts.End) {
+			end = b.opts.End
+		}
+		if !start.Before(end) {
+			continue
+		}
+		duration := time.Duration(float64(rollup.Duration) * end.Sub(start).Hours())
+		key, _ := summaryGroupKey(b.opts, rollup.record(), start)
+		b.durations[key] += duration
+		b.total += duration
+	}
+}
+
+func (b *summaryBuilder) data() *SummaryData {
+	activities := make([]Activity, 0, len(b.durations))
+	for name, duration := range b.durations {
+		activity := Activity{Name: name, Duration: duration}
+		for command, duration := range b.commandDurations[name] {
+			activity.Breakdown = append(activity.Breakdown, Activity{Name: command, Duration: duration})
+		}
+		sort.Slice(activity.Breakdown, func(i, j int) bool {
+			if activity.Breakdown[i].Duration != activity.Breakdown[j].Duration {
+				return activity.Breakdown[i].Duration > activity.Breakdown[j].Duration
+			}
+			return activity.Breakdown[i].Name < activity.Breakdown[j].Name
+		})
+		activities = append(activities, activity)
+	}
+	sort.Slice(activities, func(i, j int) bool {
+		if b.opts.GroupBy == "hour" || b.opts.GroupBy == "day" {
+			return activities[i].Name < activities[j].Name
+		}
+		if activities[i].Duration != activities[j].Duration {
+			return activities[i].Duration > activities[j].Duration
+		}
+		return activities[i].Name < activities[j].Name
+	})
 
 	return &SummaryData{
 		Activities:    activities,
-		TotalDuration: totalDuration,
-		TimePeriod:    timeDelta,
-	}, nil
+		TotalDuration: b.total,
+		TimePeriod:    b.opts.End.Sub(b.opts.Start),
+	}
+}
+
+// Total up the time spent in each group, counting only the part of each
+// activity that falls within the summary's time range. Rollups for hours that
+// are only partly in the range are counted in proportion.
+func summarizeActivities(records []ActivityRecord, rollups []ActivityRollup, opts SummaryOptions) (*SummaryData, error) {
+	builder, err := newSummaryBuilder(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if opts.DeviceID != "" {
+		records = slices.DeleteFunc(slices.Clone(records), func(record ActivityRecord) bool {
+			return record.DeviceID != opts.DeviceID
+		})
+	}
+	for _, record := range mergeOverlappingActivities(records, now) {
+		builder.addActivity(record, now)
+	}
+	builder.addRollups(rollups)
+	return builder.data(), nil
+}
+
+// Total up the time spent in each group from totals added up by the database, with
+// the activities that shell commands ran in to break down their time
+func summarizeTotals(totals []groupTotal, terminals []ActivityRecord, rollups []ActivityRollup, opts SummaryOptions) (*SummaryData, error) {
+	builder, err := newSummaryBuilder(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, total := range totals {
+		builder.addTotal(total)
+	}
+	for _, record := range terminals {
+		if opts.DeviceID == "" || record.DeviceID == opts.DeviceID {
+			builder.addCommands(record, now)
+		}
+	}
+	builder.addRollups(rollups)
+	return builder.data(), nil
+}
+
+// Get the group for the part of an activity starting at t, and the time at which
+// the group changes (zero if it doesn't change during the activity)
+func summaryGroupKey(opts SummaryOptions, record ActivityRecord, t time.Time) (string, time.Time) {
+	t = t.Local()
+	switch opts.GroupBy {
+	case "category":
+		return activityCategory(opts.Categories, record), time.Time{}
+	case "device":
+		return deviceName(record.DeviceID, record.Hostname), time.Time{}
+	case "hour":
+		hour := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, time.Local)
+		return hour.Format("2006-01-02 15:00"), hour.Add(time.Hour)
+	case "day":
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+		return day.Format("2006-01-02"), day.AddDate(0, 0, 1)
+	case "language":
+		if record.Language == "" {
+			return noLanguage, time.Time{}
+		}
+		return record.Language, time.Time{}
+	case "file":
+		if record.File == "" {
+			return noFile, time.Time{}
+		}
+		return shortenHome(record.File), time.Time{}
+	case "title":
+		if record.Title == "" {
+			return noTitle, time.Time{}
+		}
+		return record.Title, time.Time{}
+	case "meeting":
+		return meetingAt(opts.Events, t)
+	default:
+		return record.Name, time.Time{}
+	}
+}
+
+// When activities from several devices overlap, count the overlapping time once,
+// for the activity that started most recently. Activities must be ordered by start
+// time, and are returned clipped to the time they're counted for.
+func mergeOverlappingActivities(records []ActivityRecord, now time.Time) []ActivityRecord {
+	devices := make(map[string]bool)
+	for _, record := range records {
+		devices[record.DeviceID] = true
+	}
+	if len(devices) < 2 {
+		return records
+	}
+
+	var boundaries []time.Time
+	for _, record := range records {
+		boundaries = append(boundaries, record.StartTime, record.endOr(now))
+	}
+	sort.Slice(boundaries, func(i, j int) bool { return boundaries[i].Before(boundaries[j]) })
+
+	var merged []ActivityRecord
+	var active []int // indexes of records covering the current point
+	next := 0
+	for i := 0; i+1 < len(boundaries); i++ {
+		start, end := boundaries[i], boundaries[i+1]
+		if !start.Before(end) {
+			continue
+		}
+
+		active = slices.DeleteFunc(active, func(j int) bool { return !records[j].endOr(now).After(start) })
+		for next < len(records) && !records[next].StartTime.After(start) {
+			if records[next].endOr(now).After(start) {
+				active = append(active, next)
+			}
+			next++
+		}
+		if len(active) == 0 {
+			continue
+		}
+
+		latest := active[0]
+		for _, j := range active[1:] {
+			if !records[j].StartTime.Before(records[latest].StartTime) {
+				latest = j
+			}
+		}
+
+		// extend the previous piece if it's the same activity
+		if n := len(merged); n > 0 && merged[n-1].ID == records[latest].ID &&
+			merged[n-1].DeviceID == records[latest].DeviceID && merged[n-1].EndTime.Equal(start) {
+			pieceEnd := end
+			merged[n-1].EndTime = &pieceEnd
+			continue
+		}
+		piece := records[latest]
+		pieceEnd := end
+		piece.StartTime, piece.EndTime = start, &pieceEnd
+		merged = append(merged, piece)
+	}
+
+	return merged
 }
 
 func formatSummary(data *SummaryData) string {
 	if len(data.Activities) == 0 {
-		return "[yellow]No activity data found for the last %d minutes[white]\n"
+		return fmt.Sprintf("[yellow]No activity data found for the last %d minutes[white]\n", int(data.TimePeriod.Minutes()))
 	}
 
 	var buf strings.Builder
@@ -133,6 +549,10 @@ func formatSummary(data *SummaryData) string {
 	// Create activity bars
 	maxDuration := data.Activities[0].Duration
 	for _, activity := range data.Activities {
+		name := truncateString(activity.Name, 30)
+		if strings.HasPrefix(activity.Name, "/") || strings.HasPrefix(activity.Name, "~/") {
+			name = truncatePath(activity.Name, 30)
+		}
 		percentage := float64(activity.Duration) / float64(data.TotalDuration) * 100
 		if percentage > 0.5 {
 			barLength := int(float64(activity.Duration) / float64(maxDuration) * BarChartWidth)
@@ -140,10 +560,15 @@ func formatSummary(data *SummaryData) string {
 
 			// Format each line with tview color tags
 			buf.WriteString(fmt.Sprintf("[lightblue]%-30s[yellow] %s [green]%5.2f%% [magenta]%s[white]\n",
-				truncateString(activity.Name, 30),
+				name,
 				formatTime(activity.Duration),
 				percentage,
 				bar))
+
+			// the commands run in a terminal
+			for _, command := range activity.Breakdown[:min(len(activity.Breakdown), MaxCommandsPerActivity)] {
+				buf.WriteString(fmt.Sprintf("[gray]  └ %-26s %s[white]\n", truncateString(command.Name, 26), formatTime(command.Duration)))
+			}
 		}
 	}
 
@@ -151,22 +576,103 @@ func formatSummary(data *SummaryData) string {
 }
 
 func summaryCmd(c *cli.Context) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
 	db, err := getDb()
 	if err != nil {
 		return fmt.Errorf("error connecting to database: %v", err)
 	}
 	defer db.Close()
 
-	startTime := time.Now().Add(-time.Duration(-1*c.Int("minutes")) * time.Minute)
-	data, err := getSummaryData(db, startTime)
+	now := time.Now()
+	opts := SummaryOptions{
+		Start:      now.Add(-time.Duration(c.Int("minutes")) * time.Minute),
+		End:        now,
+		GroupBy:    c.String("group-by"),
+		Categories: cfg.Categories,
+	}
+	events, err := getCalendarEvents(db, opts.Start, opts.End)
 	if err != nil {
 		return err
 	}
+	var meetings string
+	if len(events) > 0 {
+		records, err := getStoredActivities(db, opts.Start, opts.End)
+		if err != nil {
+			return err
+		}
+		meetings = formatMeetings(meetingTimes(events, records, append(slices.Clone(callApps), cfg.Calendar.CallApps...),
+			opts.Start, opts.End, now))
+	}
 
-	fmt.Print(formatSummary(data))
+	devices, err := getSummaryDevices(db, opts.Start, opts.End)
+	if err != nil {
+		return err
+	}
+	if !c.Bool("by-device") || len(devices) == 0 {
+		data, err := getGroupedSummaryData(db, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Print(formatSummary(data) + meetings)
+		return nil
+	}
+
+	deviceIDs := sortedKeys(devices)
+	sort.SliceStable(deviceIDs, func(i, j int) bool { return devices[deviceIDs[i]] < devices[deviceIDs[j]] })
+
+	for i, deviceID := range deviceIDs {
+		opts.DeviceID = deviceID
+		data, err := getGroupedSummaryData(db, opts)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("[cyan]💻 %s[white]\n", devices[deviceID])
+		fmt.Print(formatSummary(data))
+	}
+	fmt.Print(meetings)
 	return nil
 }
 
+// Get the names of the devices with activities or rollups in a time range, by device id
+func getSummaryDevices(db *DB, since, until time.Time) (map[string]string, error) {
+	devices := make(map[string]string)
+	for _, query := range []string{`
+		SELECT DISTINCT device_id, hostname
+		FROM activities
+		WHERE start_time < ? AND (end_time > ? OR end_time IS NULL)
+	`, `
+		SELECT DISTINCT device_id, hostname
+		FROM activity_rollups
+		WHERE hour < ? AND hour > ?
+	`} {
+		rows, err := db.Query(db.rebind(query), dbTime(until), dbTime(since.Add(-time.Hour)))
+		if err != nil {
+			return nil, fmt.Errorf("error querying database: %v", err)
+		}
+		for rows.Next() {
+			var deviceID, hostname string
+			if err := rows.Scan(&deviceID, &hostname); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error scanning row: %v", err)
+			}
+			devices[deviceID] = deviceName(deviceID, hostname)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading rows: %v", err)
+		}
+	}
+	return devices, nil
+}
+
 func getLatestStats(db *DB, startTime time.Time) (string, error) {
 	data, err := getSummaryData(db, startTime)
 	if err != nil {
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestDb(t *testing.T) *DB {
+	t.Helper()
+	db, err := openDb(DatabaseConfig{Type: "sqlite", SqlitePath: filepath.Join(t.TempDir(), "tracker.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Summaries added up by the database match those added up from the activities,
+// including across daylight saving time changes
+func TestActivityTotalsMatchActivities(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	local := time.Local
+	time.Local = berlin
+	defer func() { time.Local = local }()
+
+	db := openTestDb(t)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, berlin)
+	}
+	end := func(t time.Time) *time.Time { return &t }
+	for _, record := range []ActivityRecord{
+		{StartTime: at(3, 30, 22, 0), EndTime: end(at(3, 31, 1, 30)), Name: "Code", App: "Code", Language: "go"},
+		{StartTime: at(3, 31, 1, 30), EndTime: end(at(3, 31, 4, 0)), Name: "github.com", App: "Firefox"}, // clocks go forward at 2:00
+		{StartTime: at(3, 31, 4, 0), EndTime: end(at(3, 31, 4, 20)), Name: "Code", App: "Code", Language: "python"},
+		{StartTime: at(4, 2, 9, 0), EndTime: end(at(4, 2, 9, 45)), Name: "Slack", App: "Slack"},
+		{StartTime: at(4, 2, 10, 0), UpdatedAt: end(at(4, 2, 10, 59)), Name: "iTerm2", App: "iTerm2"}, // ongoing
+	} {
+		record.DeviceID, record.Hostname = "device", "laptop"
+		if _, err := db.insertActivity(db, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := at(4, 2, 11, 0)
+	for _, opts := range []SummaryOptions{
+		{Start: at(3, 30, 0, 0), End: now},
+		{Start: at(3, 31, 1, 0), End: at(3, 31, 3, 30)},
+		{Start: at(3, 30, 23, 0), End: at(4, 2, 10, 30), GroupBy: "category", Categories: map[string][]string{"Work": {"Code", "github.com"}}},
+		{Start: at(3, 30, 0, 0), End: now, GroupBy: "language"},
+		{Start: at(3, 30, 0, 0), End: now, GroupBy: "device", DeviceID: "device"},
+	} {
+		records, err := getStoredActivities(db, opts.Start, opts.End)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range records {
+			if records[i].EndTime == nil {
+				records[i].EndTime = &now
+			}
+		}
+		want, err := summarizeActivities(records, nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		totals, ok, err := getActivityTotals(db, opts, now)
+		if err != nil || !ok {
+			t.Fatalf("%+v: got %v, %v", opts, ok, err)
+		}
+		got, err := summarizeTotals(totals, nil, nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s to %s by %q: got %+v, want %+v", opts.Start, opts.End, opts.GroupBy, got, want)
+		}
+	}
+}
+
+func TestActivityTotalsNeedActivities(t *testing.T) {
+	db := openTestDb(t)
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	for _, deviceID := range []string{"laptop", "desktop"} {
+		end := start.Add(time.Hour)
+		if _, err := db.insertActivity(db, ActivityRecord{StartTime: start, EndTime: &end, Name: "Code", DeviceID: deviceID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, opts := range []SummaryOptions{
+		{GroupBy: "activity"}, // overlapping devices
+		{GroupBy: "hour", DeviceID: "laptop"},
+		{GroupBy: "file", DeviceID: "laptop"},
+	} {
+		opts.Start, opts.End = start, start.Add(2*time.Hour)
+		if _, ok, err := getActivityTotals(db, opts, opts.End); err != nil || ok {
+			t.Errorf("%+v: got %v, %v, want the activities to be needed", opts, ok, err)
+		}
+	}
+	opts := SummaryOptions{Start: start, End: start.Add(2 * time.Hour), DeviceID: "laptop"}
+	if totals, ok, err := getActivityTotals(db, opts, opts.End); err != nil || !ok || len(totals) != 1 || totals[0].Duration != time.Hour {
+		t.Errorf("got %+v, %v, %v, want an hour on the laptop", totals, ok, err)
+	}
+}
+
+func TestZoneTransitions(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, berlin)
+	got := zoneTransitions(start, start.AddDate(1, 0, 0))
+	want := []time.Time{
+		time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC),
+		time.Date(2024, 10, 27, 1, 0, 0, 0, time.UTC),
+	}
+	if len(got) != len(want) || !got[0].Equal(want[0]) || !got[1].Equal(want[1]) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// Ongoing activities that are no longer updated, e.g. from a device that went
+// offline, only count until they went stale, and aren't current
+func TestStaleOpenActivities(t *testing.T) {
+	db := openTestDb(t)
+	now := time.Now().Truncate(time.Second)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	for _, record := range []ActivityRecord{
+		{StartTime: *at(-3 * time.Hour), UpdatedAt: at(-2 * time.Hour), Name: "Slack", DeviceID: "desktop", Hostname: "desktop"},
+		{StartTime: *at(-30 * time.Minute), UpdatedAt: at(-time.Minute), Name: "Code", DeviceID: "laptop", Hostname: "laptop"},
+	} {
+		if _, err := db.insertActivity(db, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, deviceID := range []string{"", "desktop"} {
+		data, err := getGroupedSummaryData(db, SummaryOptions{Start: now.Add(-4 * time.Hour), End: now, GroupBy: "activity", DeviceID: deviceID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, activity := range data.Activities {
+			if activity.Name == "Slack" && activity.Duration != time.Hour+openActivityTimeout {
+				t.Errorf("device %q: stale activity counted for %s, want %s", deviceID, activity.Duration, time.Hour+openActivityTimeout)
+			}
+			if activity.Name == "Code" && activity.Duration < 30*time.Minute {
+				t.Errorf("device %q: ongoing activity counted for %s, want at least 30m", deviceID, activity.Duration)
+			}
+		}
+	}
+
+	current, err := getCurrentActivity(db)
+	if err != nil || current == nil || current.Name != "Code" {
+		t.Fatalf("got %+v, %v, want the laptop's activity", current, err)
+	}
+	if err := db.endCurrentActivity(current.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if current, err := getCurrentActivity(db); err != nil || current != nil {
+		t.Errorf("got %+v, %v, want no current activity", current, err)
+	}
+}
+
+// Overlapping time is counted once, for the activity that started later
+func TestMergeOverlappingActivities(t *testing.T) {
+	base := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	now := base.Add(2 * time.Hour)
+	// an activity from start to end minutes after base, or ongoing if end is open
+	activity := func(id int64, device string, start, end int) ActivityRecord {
+		record := ActivityRecord{ID: id, DeviceID: device, StartTime: base.Add(time.Duration(start) * time.Minute),
+			UpdatedAt: &now}
+		if end >= 0 {
+			endTime := base.Add(time.Duration(end) * time.Minute)
+			record.EndTime = &endTime
+		}
+		return record
+	}
+	const open = -1
+
+	for _, tt := range []struct {
+		name    string
+		records []ActivityRecord
+		want    string
+	}{
+		{"one device isn't merged", []ActivityRecord{activity(1, "a", 0, 30), activity(2, "a", 10, 20)},
+			"1 0-30, 2 10-20"},
+		{"partial overlap", []ActivityRecord{activity(1, "a", 0, 30), activity(2, "b", 20, 40)},
+			"1 0-20, 2 20-40"},
+		{"nested", []ActivityRecord{activity(1, "a", 0, 60), activity(2, "b", 10, 20)},
+			"1 0-10, 2 10-20, 1 20-60"},
+		{"nested twice", []ActivityRecord{activity(1, "a", 0, 60), activity(2, "b", 10, 50), activity(3, "a", 20, 30)},
+			"1 0-10, 2 10-20, 3 20-30, 2 30-50, 1 50-60"},
+		{"identical start counts the later one", []ActivityRecord{activity(1, "a", 0, 30), activity(2, "b", 0, 20)},
+			"2 0-20, 1 20-30"},
+		{"identical activities", []ActivityRecord{activity(1, "a", 0, 30), activity(2, "b", 0, 30)},
+			"2 0-30"},
+		{"open-ended", []ActivityRecord{activity(1, "a", 0, open), activity(2, "b", 30, 60)},
+			"1 0-30, 2 30-60, 1 60-120"},
+		{"open-ended started later", []ActivityRecord{activity(1, "a", 0, 90), activity(2, "b", 30, open)},
+			"1 0-30, 2 30-120"},
+		{"adjacent", []ActivityRecord{activity(1, "a", 0, 30), activity(2, "b", 30, 60)},
+			"1 0-30, 2 30-60"},
+		{"gap", []ActivityRecord{activity(1, "a", 0, 10), activity(2, "b", 20, 30)},
+			"1 0-10, 2 20-30"},
+	} {
+		var got []string
+		var total time.Duration
+		for _, record := range mergeOverlappingActivities(tt.records, now) {
+			end := record.endOr(now)
+			got = append(got, fmt.Sprintf("%d %d-%d", record.ID,
+				int(record.StartTime.Sub(base).Minutes()), int(end.Sub(base).Minutes())))
+			total += end.Sub(record.StartTime)
+		}
+		if strings.Join(got, ", ") != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, strings.Join(got, ", "), tt.want)
+		}
+
+		// across devices, the merged time is the union of the activities
+		covered, devices := make(map[int]bool), make(map[string]bool)
+		for _, record := range tt.records {
+			devices[record.DeviceID] = true
+			for m := record.StartTime; m.Before(record.endOr(now)); m = m.Add(time.Minute) {
+				covered[int(m.Sub(base).Minutes())] = true
+			}
+		}
+		if len(devices) > 1 && total != time.Duration(len(covered))*time.Minute {
+			t.Errorf("%s: counted %s, want %d minutes", tt.name, total, len(covered))
+		}
+	}
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	syncFileName = "activitymon-sync.db"
+
+	// Rows are read again from this long before the sync cursor, so rows written
+	// around the last sync, or by a device with a clock that's a little behind, are
+	// not missed. Rows that are read again are left as they are.
+	syncLookback = 24 * time.Hour
+)
+
+type syncStats struct {
+	Inserted  int
+	Updated   int
+	Deleted   int
+	Unchanged int
+	Commands  int // shell commands inserted
+}
+
+// A tombstone for a deleted activity, identified like activities are across databases
+type activityDeletion struct {
+	DeviceID  string
+	OriginID  int64
+	DeletedAt time.Time
+}
+
+func (db *DB) setupDeletions() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS activity_deletions (
+			device_id TEXT NOT NULL,
+			origin_id BIGINT NOT NULL,
+			deleted_at TIMESTAMP NOT NULL,
+			stored_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (device_id, origin_id)
+		)`)
+	return err
+}
+
+// Save a tombstone, unless there's already one for a later deletion
+func (db *DB) saveDeletion(q querier, deletion activityDeletion, deletedAt, storedAt string) error {
+	if _, err := q.Exec(db.rebind(`
+		INSERT INTO activity_deletions (device_id, origin_id, deleted_at, stored_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (device_id, origin_id) DO UPDATE SET deleted_at = excluded.deleted_at, stored_at = excluded.stored_at
+		WHERE excluded.deleted_at > activity_deletions.deleted_at
+	`), deletion.DeviceID, deletion.OriginID, deletedAt, storedAt); err != nil {
+		return fmt.Errorf("error saving deletion of activity %d: %v", deletion.OriginID, err)
+	}
+	return nil
+}
+
+func syncCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "sync",
+		Usage:     "Merge activities with a SQLite database in a shared folder",
+		ArgsUsage: "<path-or-dir>",
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("expected the path of the shared database, or the folder to keep it in")
+			}
+			path, err := filepath.Abs(c.Args().First())
+			if err != nil {
+				return err
+			}
+			if info, err := os.Stat(path); err == nil && info.IsDir() {
+				path = filepath.Join(path, syncFileName)
+			}
+
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			local, err := openDb(cfg.Database)
+			if err != nil {
+				return fmt.Errorf("error connecting to database: %v", err)
+			}
+			defer local.Close()
+
+			// the shared database is encrypted like the local one
+			remote, err := openDb(DatabaseConfig{Type: "sqlite", SqlitePath: path, Encryption: cfg.Database.Encryption})
+			if err != nil {
+				return fmt.Errorf("error opening shared database: %v", err)
+			}
+			defer remote.Close()
+
+			privacy, err := newPrivacyFilter(cfg.Privacy)
+			if err != nil {
+				return err
+			}
+			return syncDatabases(local, remote, "sqlite:"+path, privacy)
+		},
+	}
+}
+
+// Merge activities both ways between the local database and a shared one. Activities
+// are identified across databases by the device that recorded them and their id on
+// that device, and only rows written since the last sync with the peer are read.
+// Activities pulled from the shared database go through this device's privacy rules.
+func syncDatabases(local, remote *DB, peer string, privacy *privacyFilter) error {
+	if _, err := local.Exec(`
+		CREATE TABLE IF NOT EXISTS sync_state (
+			peer TEXT NOT NULL,
+			direction TEXT NOT NULL,
+			cursor TIMESTAMP NOT NULL,
+			PRIMARY KEY (peer, direction)
+		)`); err != nil {
+		return fmt.Errorf("error creating sync_state table: %v", err)
+	}
+
+	// activities recorded before devices were tracked were recorded here
+	device, err := getDevice()
+	if err != nil {
+		return err
+	}
+	if _, err := local.Exec(local.rebind(`
+		UPDATE activities SET device_id = ?, hostname = ? WHERE device_id = ''
+	`), device.ID, device.Hostname); err != nil {
+		return fmt.Errorf("error assigning activities to this device: %v", err)
+	}
+	for _, db := range []*DB{local, remote} {
+		if _, err := db.Exec(`
+			UPDATE activities SET updated_at = COALESCE(end_time, start_time) WHERE updated_at IS NULL
+		`); err != nil {
+			return fmt.Errorf("error setting activity update times: %v", err)
+		}
+		if _, err := db.Exec(`
+			UPDATE activities SET stored_at = updated_at WHERE stored_at IS NULL
+		`); err != nil {
+			return fmt.Errorf("error setting activity store times: %v", err)
+		}
+		if _, err := db.Exec(`
+			UPDATE commands SET stored_at = end_time WHERE stored_at IS NULL
+		`); err != nil {
+			return fmt.Errorf("error setting command store times: %v", err)
+		}
+	}
+
+	for _, direction := range []struct {
+		name     string
+		from, to *DB
+	}{
+		{"push", local, remote},
+		{"pull", remote, local},
+	} {
+		cursor, err := getSyncCursor(local, peer, direction.name)
+		if err != nil {
+			return err
+		}
+		latest, err := getLatestStoreTime(direction.from)
+		if err != nil {
+			return err
+		}
+		records, err := getActivitiesStoredSince(direction.from, cursor.Add(-syncLookback))
+		if err != nil {
+			return err
+		}
+		if direction.to == local {
+			for i := range records {
+				records[i] = privacy.applyToRecord(records[i])
+			}
+		}
+		deletions, err := getDeletionsStoredSince(direction.from, cursor.Add(-syncLookback))
+		if err != nil {
+			return err
+		}
+		deleted, err := mergeDeletions(direction.to, deletions)
+		if err != nil {
+			return err
+		}
+		stats, err := mergeActivities(direction.to, records)
+		if err != nil {
+			return err
+		}
+		stats.Deleted = deleted
+		commands, err := getCommandsStoredSince(direction.from, cursor.Add(-syncLookback))
+		if err != nil {
+			return err
+		}
+		if direction.to == local {
+			for i := range commands {
+				commands[i] = privacy.applyToCommand(commands[i])
+			}
+		}
+		if stats.Commands, err = mergeCommands(direction.to, commands); err != nil {
+			return err
+		}
+		if err := setSyncCursor(local, peer, direction.name, latest); err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d new, %d updated, %d deleted, %d unchanged, %d new shell commands\n",
+			strings.ToUpper(direction.name[:1])+direction.name[1:], stats.Inserted, stats.Updated, stats.Deleted, stats.Unchanged,
+			stats.Commands)
+	}
+	return nil
+}
+
+// Get the latest store time read from the database synced from in the given
+// direction, or the zero time if it hasn't been synced with
+func getSyncCursor(db *DB, peer, direction string) (time.Time, error) {
+	var cursor time.Time
+	err := db.QueryRow(db.rebind(`SELECT cursor FROM sync_state WHERE peer = ? AND direction = ?`),
+		peer, direction).Scan(&cursor)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error reading sync cursor: %v", err)
+	}
+	return localTime(cursor), nil
+}
+
+func setSyncCursor(db *DB, peer, direction string, cursor time.Time) error {
+	if cursor.IsZero() {
+		return nil
+	}
+	var err error
+	if db.dbType == "postgres" {
+		_, err = db.Exec(`
+			INSERT INTO sync_state (peer, direction, cursor) VALUES ($1, $2, $3)
+			ON CONFLICT (peer, direction) DO UPDATE SET cursor = EXCLUDED.cursor`, peer, direction, dbTime(cursor))
+	} else {
+		_, err = db.Exec(`INSERT OR REPLACE INTO sync_state (peer, direction, cursor) VALUES (?, ?, ?)`,
+			peer, direction, dbTime(cursor))
+	}
+	if err != nil {
+		return fmt.Errorf("error saving sync cursor: %v", err)
+	}
+	return nil
+}
+
+// Get the time the latest activity, deletion or command was written to the database
+func getLatestStoreTime(db *DB) (time.Time, error) {
+	var latest time.Time
+	for _, table := range []string{"activities", "activity_deletions", "commands"} {
+		// not MAX(), which sqlite returns as text
+		var storedAt time.Time
+		err := db.QueryRow(`
+			SELECT stored_at FROM ` + table + ` WHERE stored_at IS NOT NULL ORDER BY stored_at DESC LIMIT 1
+		`).Scan(&storedAt)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error querying database: %v", err)
+		}
+		if storedAt = localTime(storedAt); storedAt.After(latest) {
+			latest = storedAt
+		}
+	}
+	return latest, nil
+}
+
+// Get the activities written to the database since the given time, whether they were
+// recorded there or arrived from elsewhere
+func getActivitiesStoredSince(db *DB, since time.Time) ([]ActivityRecord, error) {
+	rows, err := db.Query(db.rebind(`
+		SELECT `+activityColumns+`
+		FROM activities
+		WHERE stored_at >= ?
+		ORDER BY stored_at, id
+	`), dbTime(since))
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	records, err := scanActivities(rows)
+	if err == nil {
+		err = db.decryptActivities(records)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return records, db.loadLabels(db, records)
+}
+
+func getDeletionsStoredSince(db *DB, since time.Time) ([]activityDeletion, error) {
+	rows, err := db.Query(db.rebind(`
+		SELECT device_id, origin_id, deleted_at FROM activity_deletions WHERE stored_at >= ? ORDER BY stored_at
+	`), dbTime(since))
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	var deletions []activityDeletion
+	for rows.Next() {
+		var deletion activityDeletion
+		if err := rows.Scan(&deletion.DeviceID, &deletion.OriginID, &deletion.DeletedAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		deletion.DeletedAt = localTime(deletion.DeletedAt)
+		deletions = append(deletions, deletion)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rows: %v", err)
+	}
+	return deletions, nil
+}
+
+// Apply deletions from another database, in a single transaction, and return how
+// many activities were deleted. The tombstones are kept, so the deletions are passed
+// on, and an activity isn't deleted if it was updated after it was deleted elsewhere.
+func mergeDeletions(db *DB, deletions []activityDeletion) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	deleted := 0
+	now := dbTime(time.Now())
+	for _, deletion := range deletions {
+		deletedAt := dbTime(deletion.DeletedAt)
+		if err := db.saveDeletion(tx, deletion, deletedAt, now); err != nil {
+			return 0, err
+		}
+		rows, err := tx.Query(db.rebind(`
+			SELECT id FROM activities
+			WHERE device_id = ? AND (origin_id = ? OR (origin_id = 0 AND id = ?)) AND (updated_at IS NULL OR updated_at <= ?)
+		`), deletion.DeviceID, deletion.OriginID, deletion.OriginID, deletedAt)
+		if err != nil {
+			return 0, fmt.Errorf("error querying database: %v", err)
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return 0, fmt.Errorf("error scanning row: %v", err)
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		for _, id := range ids {
+			if _, err := tx.Exec(db.rebind(`DELETE FROM activities WHERE id = ?`), id); err != nil {
+				return 0, fmt.Errorf("error deleting activity %d: %v", id, err)
+			}
+			if err := db.deleteLabels(tx, id); err != nil {
+				return 0, err
+			}
+			deleted++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing sync: %v", err)
+	}
+	return deleted, nil
+}
+
+// Insert or update activities from another database, in a single transaction. An
+// activity that's already here is replaced if the incoming copy was updated later,
+// or, if both were updated at the same time, if it wins the tie-break. An activity
+// that was deleted here after it was last updated is left deleted.
+func mergeActivities(db *DB, records []ActivityRecord) (syncStats, error) {
+	var stats syncStats
+	tx, err := db.Begin()
+	if err != nil {
+		return stats, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	now := dbTime(time.Now())
+	for _, record := range records {
+		originID := record.originID()
+		rows, err := tx.Query(db.rebind(`
+			SELECT `+activityColumns+`
+			FROM activities
+			WHERE device_id = ? AND (origin_id = ? OR (origin_id = 0 AND id = ?))
+		`), record.DeviceID, originID, originID)
+		if err != nil {
+			return stats, fmt.Errorf("error querying database: %v", err)
+		}
+		existing, err := scanActivities(rows)
+		rows.Close()
+		if err == nil {
+			err = db.decryptActivities(existing)
+		}
+		if err == nil {
+			err = db.loadLabels(tx, existing)
+		}
+		if err != nil {
+			return stats, err
+		}
+		var deletedAt time.Time
+		err = tx.QueryRow(db.rebind(`SELECT deleted_at FROM activity_deletions WHERE device_id = ? AND origin_id = ?`),
+			record.DeviceID, originID).Scan(&deletedAt)
+		if err != nil && err != sql.ErrNoRows {
+			return stats, fmt.Errorf("error querying database: %v", err)
+		}
+		if err == nil && (record.UpdatedAt == nil || !record.UpdatedAt.After(localTime(deletedAt))) {
+			stats.Unchanged++
+			continue
+		}
+
+		record.ID, record.OriginID = 0, originID
+		values, err := db.activityValues(record)
+		if err != nil {
+			return stats, err
+		}
+
+		if len(existing) == 0 {
+			query := fmt.Sprintf("INSERT INTO activities (%s, stored_at) VALUES (%s)",
+				strings.Join(activityFields, ", "), placeholders(len(activityFields)+1))
+			id, err := db.insertReturningID(tx, query, append(values, now)...)
+			if err == nil {
+				err = db.saveLabels(tx, id, record)
+			}
+			if err != nil {
+				return stats, fmt.Errorf("error inserting activity: %v", err)
+			}
+			stats.Inserted++
+			continue
+		}
+
+		current := existing[0]
+		if sameActivity(current, record) || !supersedes(record, current) {
+			stats.Unchanged++
+			continue
+		}
+		// the activity keeps the id it's known by here
+		var assignments []string
+		var args []any
+		for i, field := range activityFields {
+			if field == "device_id" || field == "origin_id" {
+				continue
+			}
+			assignments = append(assignments, field+" = ?")
+			args = append(args, values[i])
+		}
+		if _, err := tx.Exec(db.rebind(fmt.Sprintf("UPDATE activities SET %s, stored_at = ? WHERE id = ?",
+			strings.Join(assignments, ", "))), append(args, now, current.ID)...); err != nil {
+			return stats, fmt.Errorf("error updating activity %d: %v", current.ID, err)
+		}
+		if err := db.saveLabels(tx, current.ID, record); err != nil {
+			return stats, err
+		}
+		stats.Updated++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return stats, fmt.Errorf("error committing sync: %v", err)
+	}
+	return stats, nil
+}
+
+func getCommandsStoredSince(db *DB, since time.Time) ([]shellCommand, error) {
+	rows, err := db.Query(db.rebind(`
+		SELECT `+commandColumns+`
+		FROM commands
+		WHERE stored_at >= ?
+		ORDER BY stored_at, id
+	`), dbTime(since))
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	commands, err := scanShellCommands(rows)
+	if err != nil {
+		return nil, err
+	}
+	return commands, db.decryptCommands(commands)
+}
+
+// Insert shell commands from another database that aren't here yet, in a single
+// transaction, and return how many were inserted. Commands don't change once
+// they're recorded, so ones that are here already are left as they are.
+func mergeCommands(db *DB, commands []shellCommand) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	inserted := 0
+	for _, command := range commands {
+		originID := command.originID()
+		var existing int
+		if err := tx.QueryRow(db.rebind(`
+			SELECT COUNT(*) FROM commands WHERE device_id = ? AND (origin_id = ? OR (origin_id = 0 AND id = ?))
+		`), command.DeviceID, originID, originID).Scan(&existing); err != nil {
+			return 0, fmt.Errorf("error querying database: %v", err)
+		}
+		if existing > 0 {
+			continue
+		}
+		command.OriginID = originID
+		if err := db.storeShellCommand(tx, command); err != nil {
+			return 0, err
+		}
+		inserted++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing sync: %v", err)
+	}
+	return inserted, nil
+}
+
+// Check whether one copy of an activity should replace another. The copy updated
+// last wins; otherwise an ended activity wins over an ongoing one, a later end wins,
+// and remaining differences are settled by comparing the activities' text, so every
+// device settles on the same copy.
+func supersedes(a, b ActivityRecord) bool {
+	if a.UpdatedAt != nil && b.UpdatedAt != nil && !a.UpdatedAt.Equal(*b.UpdatedAt) {
+		return a.UpdatedAt.After(*b.UpdatedAt)
+	}
+	if (a.EndTime == nil) != (b.EndTime == nil) {
+		return a.EndTime != nil
+	}
+	if a.EndTime != nil && !a.EndTime.Equal(*b.EndTime) {
+		return a.EndTime.After(*b.EndTime)
+	}
+	if !a.StartTime.Equal(b.StartTime) {
+		return a.StartTime.Before(b.StartTime)
+	}
+	key := func(r ActivityRecord) string {
+		return strings.Join([]string{r.Name, r.App, r.Title, r.URL, r.Hostname, r.File, r.Language, r.Category}, "\x00")
+	}
+	return key(a) > key(b)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Activities pulled from another device are redacted, and stay the same when synced again
+func TestSyncRedactsPulledActivities(t *testing.T) {
+	local, remote := openTestDb(t), openTestDb(t)
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour)
+	for _, record := range []ActivityRecord{
+		{StartTime: start, EndTime: &end, Name: "bank.example.com", App: "Firefox", Title: "Balance",
+			URL: "https://bank.example.com/accounts", DeviceID: "phone", UpdatedAt: &end},
+		{StartTime: end, EndTime: &end, Name: "Code", App: "Code", Title: "main.go", DeviceID: "phone", UpdatedAt: &end},
+	} {
+		if _, err := remote.insertActivity(remote, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	privacy, err := newPrivacyFilter(PrivacyConfig{StoreTitles: true, Rules: []RedactionRule{
+		{Domain: "bank.example.com", Action: "hash"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := syncDatabases(local, remote, "test", privacy); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, err := getActivities(local, time.Unix(0, 0), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d activities, want 2", len(records))
+	}
+	if bank := records[0]; bank.Name != "Firefox" || !strings.HasPrefix(bank.Title, hashPrefix) || !strings.HasPrefix(bank.URL, hashPrefix) {
+		t.Errorf("got %+v, want the title and URL hashed", bank)
+	}
+	if code := records[1]; code.Title != "main.go" {
+		t.Errorf("got %+v, want the title kept", code)
+	}
+
+	stats, err := mergeActivities(local, []ActivityRecord{privacy.applyToRecord(records[0])})
+	if err != nil || stats != (syncStats{Unchanged: 1}) {
+		t.Errorf("got %+v, %v, want the activity unchanged when redacted again", stats, err)
+	}
+}
+
+// Deleting an activity deletes it from the other databases it was synced to, unless
+// it was updated there after it was deleted
+func TestSyncDeletesActivities(t *testing.T) {
+	local, remote := openTestDb(t), openTestDb(t)
+	privacy := newTestPrivacyFilter(t, PrivacyConfig{StoreTitles: true})
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour)
+	for _, name := range []string{"Code", "Slack"} {
+		if _, err := local.insertActivity(local, ActivityRecord{StartTime: start, EndTime: &end, Name: name, UpdatedAt: &end}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := syncDatabases(local, remote, "test", privacy); err != nil {
+		t.Fatal(err)
+	}
+
+	records := mustGetActivities(t, local, start, end)
+	editor, err := beginEdit(local, "edit delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if err := editor.delete(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := editor.commit(); err != nil {
+		t.Fatal(err)
+	}
+	// Slack was changed on the other machine after it was deleted here
+	pulled := mustGetActivities(t, remote, start, end)
+	later := time.Now().Add(time.Hour)
+	pulled[1].Title, pulled[1].UpdatedAt = "general", &later
+	if err := remote.updateActivity(remote, pulled[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := syncDatabases(local, remote, "test", privacy); err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range []*DB{local, remote} {
+		if got := mustGetActivities(t, db, start, end); len(got) != 1 || got[0].Name != "Slack" {
+			t.Errorf("got %+v, want only the activity updated after it was deleted", got)
+		}
+	}
+}
+
+// Shell commands are synced once, with their working directories redacted on the
+// way in and encrypted where the database is
+func TestSyncShellCommands(t *testing.T) {
+	dir := t.TempDir()
+	local, err := openDb(DatabaseConfig{Type: "sqlite", SqlitePath: filepath.Join(dir, "tracker.db"),
+		Encryption: EncryptionConfig{KeyStore: "file", KeyFile: filepath.Join(dir, "database.key")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	remote := openTestDb(t)
+	secret := "[secret]"
+	privacy := newTestPrivacyFilter(t, PrivacyConfig{StoreTitles: true, Rules: []RedactionRule{
+		{App: "iTerm2", Action: "redact", Pattern: `/clients/\w+`, Replacement: &secret},
+	}})
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	for _, cwd := range []string{"/home/me/clients/acme", "/home/me/src"} {
+		if err := remote.storeShellCommand(remote, shellCommand{Name: "make", Cwd: cwd, Shell: "zsh", StartTime: start,
+			EndTime: start.Add(time.Minute), App: "iTerm2", DeviceID: "desktop"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := syncDatabases(local, remote, "test", privacy); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commands, err := getShellCommands(local, start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 2 || commands[0].Cwd != "/home/me[secret]" || commands[1].Cwd != "/home/me/src" {
+		t.Fatalf("got %+v, want both commands, one redacted", commands)
+	}
+	var stored string
+	if err := local.QueryRow(`SELECT cwd FROM commands WHERE id = ?`, commands[1].ID).Scan(&stored); err != nil || !isEncrypted(stored) {
+		t.Errorf("got %q, %v, want the working directory encrypted", stored, err)
+	}
+
+	if deleted, err := compactCommands(local, start.Add(time.Hour), "desktop"); err != nil || deleted != 2 {
+		t.Errorf("got %d, %v, want both commands deleted", deleted, err)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Timesheet row for time that isn't labeled with a project
+const noProject = "(no project)"
+
+var timesheetRoundings = []string{"nearest", "up", "down"}
+
+// Time per project per day, with each day rounded to the increment
+type timesheet struct {
+	Days     []time.Time
+	Projects []string
+	Time     map[string][]time.Duration
+}
+
+// Split a week's activities and rolled-up hours by project and day. Activities with
+// several projects are split evenly between them, and personal activities are left out.
+func buildTimesheet(records []ActivityRecord, rollups []ActivityRollup, weekStart, now time.Time, increment time.Duration, rounding string) timesheet {
+	sheet := timesheet{Time: make(map[string][]time.Duration)}
+	for day := 0; day < 7; day++ {
+		sheet.Days = append(sheet.Days, weekStart.AddDate(0, 0, day))
+	}
+
+	for _, record := range mergeOverlappingActivities(records, now) {
+		for i, day := range sheet.Days {
+			start, end := record.StartTime, record.endOr(now)
+			if start.Before(day) {
+				start = day
+			}
+			if dayEnd := day.AddDate(0, 0, 1); end.After(dayEnd) {
+				end = dayEnd
+			}
+			if end.After(start) {
+				sheet.add(record, i, end.Sub(start))
+			}
+		}
+	}
+	// hours start on the hour, so each is in a single day
+	for _, rollup := range rollups {
+		for i, day := range sheet.Days {
+			if !rollup.Hour.Before(day) && rollup.Hour.Before(day.AddDate(0, 0, 1)) {
+				sheet.add(rollup.record(), i, rollup.Duration)
+			}
+		}
+	}
+
+	for _, project := range sheet.Projects {
+		for i, d := range sheet.Time[project] {
+			sheet.Time[project][i] = roundDuration(d, increment, rounding)
+		}
+	}
+	slices.SortFunc(sheet.Projects, func(a, b string) int {
+		// time without a project goes last
+		if (a == noProject) != (b == noProject) {
+			if a == noProject {
+				return 1
+			}
+			return -1
+		}
+		return strings.Compare(a, b)
+	})
+	return sheet
+}
+
+// Add time on a day to the activity's projects
+func (s *timesheet) add(record ActivityRecord, day int, d time.Duration) {
+	if slices.Contains(record.Tags, personalTag) {
+		return
+	}
+	projects := record.Projects
+	if len(projects) == 0 {
+		projects = []string{noProject}
+	}
+	for _, project := range projects {
+		if s.Time[project] == nil {
+			s.Time[project] = make([]time.Duration, len(s.Days))
+			s.Projects = append(s.Projects, project)
+		}
+		s.Time[project][day] += d / time.Duration(len(projects))
+	}
+}
+
+func roundDuration(d, increment time.Duration, rounding string) time.Duration {
+	if increment <= 0 {
+		return d
+	}
+	increments := float64(d) / float64(increment)
+	switch rounding {
+	case "up":
+		increments = math.Ceil(increments)
+	case "down":
+		increments = math.Floor(increments)
+	default:
+		increments = math.Round(increments)
+	}
+	return time.Duration(increments) * increment
+}
+
+func (s timesheet) total(project string) time.Duration {
+	var total time.Duration
+	for _, d := range s.Time[project] {
+		total += d
+	}
+	return total
+}
+
+func (s timesheet) dayTotal(day int) time.Duration {
+	var total time.Duration
+	for _, project := range s.Projects {
+		total += s.Time[project][day]
+	}
+	return total
+}
+
+func formatHours(d time.Duration) string {
+	return fmt.Sprintf("%.2f", d.Hours())
+}
+
+func formatTimesheet(sheet timesheet) string {
+	var buf strings.Builder
+	width := len("Total")
+	for _, project := range sheet.Projects {
+		width = max(width, len(project))
+	}
+
+	fmt.Fprintf(&buf, "%-*s", width, "Project")
+	for _, day := range sheet.Days {
+		fmt.Fprintf(&buf, "  %9s", day.Format("Mon 01/02"))
+	}
+	fmt.Fprintf(&buf, "  %9s\n", "Total")
+
+	var total time.Duration
+	for _, project := range sheet.Projects {
+		fmt.Fprintf(&buf, "%-*s", width, project)
+		for _, d := range sheet.Time[project] {
+			fmt.Fprintf(&buf, "  %9s", formatHours(d))
+		}
+		fmt.Fprintf(&buf, "  %9s\n", formatHours(sheet.total(project)))
+		total += sheet.total(project)
+	}
+
+	fmt.Fprintf(&buf, "%-*s", width, "Total")
+	for i := range sheet.Days {
+		fmt.Fprintf(&buf, "  %9s", formatHours(sheet.dayTotal(i)))
+	}
+	fmt.Fprintf(&buf, "  %9s\n", formatHours(total))
+	return buf.String()
+}
+
+// Write a timesheet as CSV, with a column of hours per day
+func writeTimesheetCSV(w io.Writer, sheet timesheet) error {
+	cw := csv.NewWriter(w)
+	header := []string{"project"}
+	for _, day := range sheet.Days {
+		header = append(header, day.Format("2006-01-02"))
+	}
+	if err := cw.Write(append(header, "total")); err != nil {
+		return err
+	}
+	for _, project := range sheet.Projects {
+		row := []string{project}
+		for _, d := range sheet.Time[project] {
+			row = append(row, formatHours(d))
+		}
+		if err := cw.Write(append(row, formatHours(sheet.total(project)))); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Get the Monday of the week given as "this", "last" or a date in it
+func parseWeek(value string, now time.Time) (time.Time, error) {
+	day := now
+	switch value {
+	case "", "this":
+	case "last":
+		day = now.AddDate(0, 0, -7)
+	default:
+		var err error
+		if day, err = time.ParseInLocation("2006-01-02", value, time.Local); err != nil {
+			return day, fmt.Errorf("expected this, last or a date: %v", err)
+		}
+	}
+	offset := (int(day.Weekday()) + 6) % 7 // days since Monday
+	return time.Date(day.Year(), day.Month(), day.Day()-offset, 0, 0, 0, 0, time.Local), nil
+}
+
+func timesheetCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "timesheet",
+		Usage: "Show the hours per project per day of a week",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "week",
+				Usage: "Week to show: this, last, or a date in it",
+				Value: "this",
+			},
+			&cli.IntFlag{
+				Name:  "increment",
+				Usage: "Round each day's hours to this many minutes (default: from the config, or 15)",
+			},
+			&cli.StringFlag{
+				Name:  "rounding",
+				Usage: "Round to the nearest increment, or up or down (default: from the config, or nearest)",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Output format: text or csv",
+				Value: "text",
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "File to write to (default: stdout)",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			now := time.Now()
+			weekStart, err := parseWeek(c.String("week"), now)
+			if err != nil {
+				return fmt.Errorf("invalid week: %v", err)
+			}
+			increment := cfg.Timesheet.IncrementMinutes
+			if c.IsSet("increment") {
+				increment = c.Int("increment")
+			}
+			rounding := cfg.Timesheet.Rounding
+			if c.IsSet("rounding") {
+				rounding = c.String("rounding")
+			}
+			if !slices.Contains(timesheetRoundings, rounding) {
+				return fmt.Errorf("unsupported rounding: %s (expected one of %s)", rounding, strings.Join(timesheetRoundings, ", "))
+			}
+
+			db, err := getDb()
+			if err != nil {
+				return fmt.Errorf("error connecting to database: %v", err)
+			}
+			defer db.Close()
+
+			weekEnd := weekStart.AddDate(0, 0, 7)
+			records, err := getStoredActivities(db, weekStart, weekEnd)
+			if err != nil {
+				return err
+			}
+			rollups, err := getRollups(db, weekStart, weekEnd)
+			if err != nil {
+				return err
+			}
+			sheet := buildTimesheet(records, rollups, weekStart, now, time.Duration(increment)*time.Minute, rounding)
+
+			var w io.Writer = os.Stdout
+			if path := c.String("output"); path != "" {
+				f, err := os.Create(path)
+				if err != nil {
+					return fmt.Errorf("unable to create output file: %v", err)
+				}
+				defer f.Close()
+				w = f
+			}
+
+			switch c.String("format") {
+			case "text":
+				_, err = io.WriteString(w, formatTimesheet(sheet))
+				return err
+			case "csv":
+				return writeTimesheetCSV(w, sheet)
+			default:
+				return fmt.Errorf("unsupported format: %s (expected text or csv)", c.String("format"))
+			}
+		},
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoundDuration(t *testing.T) {
+	for _, tt := range []struct {
+		d, increment time.Duration
+		rounding     string
+		want         time.Duration
+	}{
+		{22 * time.Minute, 15 * time.Minute, "nearest", 15 * time.Minute},
+		{23 * time.Minute, 15 * time.Minute, "nearest", 30 * time.Minute},
+		{22*time.Minute + 30*time.Second, 15 * time.Minute, "nearest", 30 * time.Minute},
+		{16 * time.Minute, 15 * time.Minute, "up", 30 * time.Minute},
+		{29 * time.Minute, 15 * time.Minute, "down", 15 * time.Minute},
+		{30 * time.Minute, 15 * time.Minute, "up", 30 * time.Minute},
+		{30 * time.Minute, 15 * time.Minute, "down", 30 * time.Minute},
+		{time.Second, 6 * time.Minute, "up", 6 * time.Minute},
+		{time.Second, 6 * time.Minute, "nearest", 0},
+		{0, 15 * time.Minute, "up", 0},
+		{22 * time.Minute, 0, "up", 22 * time.Minute},
+	} {
+		if got := roundDuration(tt.d, tt.increment, tt.rounding); got != tt.want {
+			t.Errorf("roundDuration(%s, %s, %s) = %s, want %s", tt.d, tt.increment, tt.rounding, got, tt.want)
+		}
+	}
+}
+
+func TestParseWeek(t *testing.T) {
+	now := time.Date(2024, 11, 7, 15, 30, 0, 0, time.Local) // a Thursday
+	monday := time.Date(2024, 11, 4, 0, 0, 0, 0, time.Local)
+	for _, tt := range []struct {
+		value string
+		want  time.Time
+	}{
+		{"", monday},
+		{"this", monday},
+		{"last", monday.AddDate(0, 0, -7)},
+		{"2024-11-04", monday},
+		{"2024-11-10", monday},                  // Sunday
+		{"2024-11-11", monday.AddDate(0, 0, 7)}, // the next Monday
+		{"2024-01-01", time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)},
+		{"2023-01-01", time.Date(2022, 12, 26, 0, 0, 0, 0, time.Local)},
+	} {
+		got, err := parseWeek(tt.value, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseWeek(%q) = %s, %v, want %s", tt.value, got, err, tt.want)
+		}
+	}
+	for _, value := range []string{"next", "2024-13-01", "11/04/2024"} {
+		if _, err := parseWeek(value, now); err == nil {
+			t.Errorf("parseWeek(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+// Rolled-up hours count toward their projects like raw activities
+func TestBuildTimesheetWithRollups(t *testing.T) {
+	db := openTestDb(t)
+	weekStart := time.Date(2024, 11, 4, 0, 0, 0, 0, time.Local)
+	at := func(day, hour int) time.Time {
+		return weekStart.AddDate(0, 0, day).Add(time.Duration(hour) * time.Hour)
+	}
+	for _, record := range []ActivityRecord{
+		{StartTime: at(0, 9), Name: "Code", Projects: []string{"activitymon"}},
+		{StartTime: at(0, 11), Name: "Slack", Projects: []string{"activitymon", "website"}},
+		{StartTime: at(1, 9), Name: "Slack", Tags: []string{personalTag}},
+		{StartTime: at(1, 23), Name: "Mail"},
+	} {
+		end := record.StartTime.Add(2 * time.Hour)
+		record.EndTime = &end
+		if _, err := db.insertActivity(db, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// roll up the first day, and the first hour of the second
+	if _, err := compactActivities(db, at(1, 0), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	weekEnd := weekStart.AddDate(0, 0, 7)
+	records, err := getStoredActivities(db, weekStart, weekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollups, err := getRollups(db, weekStart, weekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rollups) == 0 {
+		t.Fatal("nothing was rolled up")
+	}
+	sheet := buildTimesheet(records, rollups, weekStart, weekEnd, 0, "nearest")
+
+	want := map[string][]time.Duration{
+		"activitymon": {3 * time.Hour, 0, 0, 0, 0, 0, 0},
+		"website":     {time.Hour, 0, 0, 0, 0, 0, 0},
+		noProject:     {0, time.Hour, time.Hour, 0, 0, 0, 0},
+	}
+	if len(sheet.Projects) != len(want) {
+		t.Fatalf("got projects %v, want %d", sheet.Projects, len(want))
+	}
+	for project, days := range want {
+		for i, d := range days {
+			if got := sheet.Time[project][i]; got != d {
+				t.Errorf("%s on day %d: got %s, want %s", project, i, got, d)
+			}
+		}
+	}
+}
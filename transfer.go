@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	exportFormats     = []string{"jsonl", "csv", "aw"}
+	csvHeader         = []string{"id", "start_time", "end_time", "activity_name", "app_name", "window_title", "url", "device_id", "hostname", "origin_id", "updated_at", "source", "projects", "tags", "file_path", "language", "category"}
+	commandsCSVHeader = []string{"id", "command_name", "cwd", "shell", "pid", "start_time", "end_time", "exit_code", "app_name", "device_id", "hostname", "origin_id"}
+)
+
+func exportCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "export",
+		Usage: "Export activities",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Export format: jsonl, csv or aw (ActivityWatch)",
+				Value: "jsonl",
+			},
+			&cli.StringFlag{
+				Name:  "since",
+				Usage: "Export activities after this time (default: all)",
+			},
+			&cli.StringFlag{
+				Name:  "until",
+				Usage: "Export activities before this time (default: now)",
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "File to write to (default: stdout)",
+			},
+			&cli.BoolFlag{
+				Name:  "commands",
+				Usage: "Export the shell commands reported by the shell hook instead, as jsonl or csv",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			now := time.Now()
+			since, until := time.Unix(0, 0), now
+			var err error
+			if value := c.String("since"); value != "" {
+				if since, err = parseTimeParam(value, now); err != nil {
+					return fmt.Errorf("invalid since: %v", err)
+				}
+			}
+			if value := c.String("until"); value != "" {
+				if until, err = parseTimeParam(value, now); err != nil {
+					return fmt.Errorf("invalid until: %v", err)
+				}
+			}
+
+			db, err := getDb()
+			if err != nil {
+				return fmt.Errorf("error connecting to database: %v", err)
+			}
+			defer db.Close()
+
+			var w io.Writer = os.Stdout
+			if path := c.String("output"); path != "" {
+				f, err := os.Create(path)
+				if err != nil {
+					return fmt.Errorf("unable to create output file: %v", err)
+				}
+				defer f.Close()
+				w = f
+			}
+
+			if c.Bool("commands") {
+				commands, err := getShellCommands(db, since, until)
+				if err != nil {
+					return err
+				}
+				switch c.String("format") {
+				case "jsonl":
+					return writeCommandsJSONLines(w, commands)
+				case "csv":
+					return writeCommandsCSV(w, commands)
+				default:
+					return fmt.Errorf("unsupported export format for commands: %s (expected jsonl or csv)", c.String("format"))
+				}
+			}
+
+			records, err := getActivities(db, since, until)
+			if err != nil {
+				return err
+			}
+			switch c.String("format") {
+			case "jsonl":
+				return writeJSONLines(w, records)
+			case "csv":
+				return writeCSV(w, records)
+			case "aw":
+				return writeActivityWatch(w, records, now)
+			default:
+				return fmt.Errorf("unsupported export format: %s (expected one of %s)",
+					c.String("format"), strings.Join(exportFormats, ", "))
+			}
+		},
+	}
+}
+
+func importCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "import",
+		Usage:     "Import activities",
+		ArgsUsage: "<file, or - for stdin>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Import format: jsonl, csv or aw (ActivityWatch)",
+				Value: "jsonl",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("expected a file to import")
+			}
+
+			var r io.Reader = os.Stdin
+			if path := c.Args().First(); path != "-" {
+				f, err := os.Open(path)
+				if err != nil {
+					return fmt.Errorf("unable to open import file: %v", err)
+				}
+				defer f.Close()
+				r = f
+			}
+
+			var records []ActivityRecord
+			var err error
+			switch c.String("format") {
+			case "jsonl":
+				records, err = readJSONLines(r)
+			case "csv":
+				records, err = readCSV(r)
+			case "aw":
+				records, err = readActivityWatch(r)
+			default:
+				return fmt.Errorf("unsupported import format: %s (expected one of %s)",
+					c.String("format"), strings.Join(exportFormats, ", "))
+			}
+			if err != nil {
+				return err
+			}
+
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			privacy, err := newPrivacyFilter(cfg.Privacy)
+			if err != nil {
+				return err
+			}
+			for i := range records {
+				records[i] = privacy.applyToRecord(records[i])
+			}
+
+			db, err := getDb()
+			if err != nil {
+				return fmt.Errorf("error connecting to database: %v", err)
+			}
+			defer db.Close()
+
+			stats, err := importActivities(db, records)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Imported %d activities, %d trimmed to avoid overlaps, skipped %d already recorded\n",
+				stats.Inserted+stats.Trimmed, stats.Trimmed, stats.Skipped)
+			if stats.Open > 0 {
+				fmt.Printf("Skipped %d activities that hadn't ended when they were exported\n", stats.Open)
+			}
+			return nil
+		},
+	}
+}
+
+// Write one JSON activity per line
+func writeJSONLines(w io.Writer, records []ActivityRecord) error {
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func writeCommandsJSONLines(w io.Writer, commands []shellCommand) error {
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	for _, command := range commands {
+		if err := encoder.Encode(command); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func readJSONLines(r io.Reader) ([]ActivityRecord, error) {
+	var records []ActivityRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record ActivityRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("error parsing line %d: %v", line, err)
+		}
+		if err := validateImportRecord(record); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading input: %v", err)
+	}
+	return records, nil
+}
+
+// Write activities as CSV, with RFC 3339 timestamps and an empty end time for
+// ongoing activities
+func writeCSV(w io.Writer, records []ActivityRecord) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, record := range records {
+		endTime, updatedAt := "", ""
+		if record.EndTime != nil {
+			endTime = record.EndTime.Format(time.RFC3339)
+		}
+		if record.UpdatedAt != nil {
+			updatedAt = record.UpdatedAt.Format(time.RFC3339)
+		}
+		if err := cw.Write([]string{
+			strconv.FormatInt(record.ID, 10),
+			record.StartTime.Format(time.RFC3339),
+			endTime,
+			record.Name,
+			record.App,
+			record.Title,
+			record.URL,
+			record.DeviceID,
+			record.Hostname,
+			strconv.FormatInt(record.OriginID, 10),
+			updatedAt,
+			record.Source,
+			joinLabels(record.Projects),
+			joinLabels(record.Tags),
+			record.File,
+			record.Language,
+			record.Category,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeCommandsCSV(w io.Writer, commands []shellCommand) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(commandsCSVHeader); err != nil {
+		return err
+	}
+	for _, command := range commands {
+		if err := cw.Write([]string{
+			strconv.FormatInt(command.ID, 10),
+			command.Name,
+			command.Cwd,
+			command.Shell,
+			strconv.Itoa(command.PID),
+			command.StartTime.Format(time.RFC3339),
+			command.EndTime.Format(time.RFC3339),
+			strconv.Itoa(command.ExitCode),
+			command.App,
+			command.DeviceID,
+			command.Hostname,
+			strconv.FormatInt(command.OriginID, 10),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func readCSV(r io.Reader) ([]ActivityRecord, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"start_time", "activity_name"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV is missing the %s column", name)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return row[i]
+		}
+		return ""
+	}
+
+	var records []ActivityRecord
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV: %v", err)
+		}
+
+		var record ActivityRecord
+		if id := field(row, "id"); id != "" {
+			if record.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+				return nil, fmt.Errorf("line %d: invalid id: %v", line, err)
+			}
+		}
+		if record.StartTime, err = time.Parse(time.RFC3339, field(row, "start_time")); err != nil {
+			return nil, fmt.Errorf("line %d: invalid start_time: %v", line, err)
+		}
+		if endTime := field(row, "end_time"); endTime != "" {
+			t, err := time.Parse(time.RFC3339, endTime)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid end_time: %v", line, err)
+			}
+			record.EndTime = &t
+		}
+		record.Name = field(row, "activity_name")
+		record.App = field(row, "app_name")
+		record.Title = field(row, "window_title")
+		record.URL = field(row, "url")
+		record.File = field(row, "file_path")
+		record.Language = field(row, "language")
+		record.Category = field(row, "category")
+		record.DeviceID = field(row, "device_id")
+		record.Hostname = field(row, "hostname")
+		if originID := field(row, "origin_id"); originID != "" {
+			if record.OriginID, err = strconv.ParseInt(originID, 10, 64); err != nil {
+				return nil, fmt.Errorf("line %d: invalid origin_id: %v", line, err)
+			}
+		}
+		if updatedAt := field(row, "updated_at"); updatedAt != "" {
+			t, err := time.Parse(time.RFC3339, updatedAt)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid updated_at: %v", line, err)
+			}
+			record.UpdatedAt = &t
+		}
+		record.Source = field(row, "source")
+		record.Projects, record.Tags = splitLabels(field(row, "projects")), splitLabels(field(row, "tags"))
+
+		if err := validateImportRecord(record); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func validateImportRecord(record ActivityRecord) error {
+	if record.Name == "" {
+		return fmt.Errorf("activity name is required")
+	}
+	if record.StartTime.IsZero() {
+		return fmt.Errorf("start time is required")
+	}
+	if record.EndTime != nil && record.EndTime.Before(record.StartTime) {
+		return fmt.Errorf("end time is before start time")
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func insertTestActivities(t *testing.T, db *DB) {
+	t.Helper()
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	at := func(minutes int) *time.Time {
+		t := start.Add(time.Duration(minutes) * time.Minute)
+		return &t
+	}
+	for _, record := range []ActivityRecord{
+		{StartTime: *at(0), EndTime: at(30), Name: "Code", App: "Code", Title: "main.go — activitymon",
+			File: "/home/me/src/activitymon/main.go", Language: "go", Projects: []string{"activitymon"}},
+		{StartTime: *at(30), EndTime: at(45), Name: "github.com", App: "Firefox", Title: `Pull "requests", and more`,
+			URL: "https://github.com/pulls?q=is%3Aopen,author", Tags: []string{"personal", "review"}},
+		{StartTime: *at(45), EndTime: at(60), Name: "Design review", Source: "manual", UpdatedAt: at(70)},
+		{StartTime: *at(60), EndTime: at(75), Name: "iTerm2", App: "iTerm2", Title: "line one\nline two",
+			DeviceID: "desktop", Hostname: "desktop.local", OriginID: 1234},
+	} {
+		if _, err := db.insertActivity(db, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Exporting, importing into an empty database and exporting again gives the same export
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, format := range []struct {
+		name  string
+		write func(io.Writer, []ActivityRecord) error
+		read  func(io.Reader) ([]ActivityRecord, error)
+	}{
+		{"jsonl", writeJSONLines, readJSONLines},
+		{"csv", writeCSV, readCSV},
+	} {
+		t.Run(format.name, func(t *testing.T) {
+			export := func(db *DB) []byte {
+				records, err := getActivities(db, time.Unix(0, 0), time.Now())
+				if err != nil {
+					t.Fatal(err)
+				}
+				var buf bytes.Buffer
+				if err := format.write(&buf, records); err != nil {
+					t.Fatal(err)
+				}
+				return buf.Bytes()
+			}
+
+			from := openTestDb(t)
+			insertTestActivities(t, from)
+			exported := export(from)
+
+			records, err := format.read(bytes.NewReader(exported))
+			if err != nil {
+				t.Fatal(err)
+			}
+			to := openTestDb(t)
+			stats, err := importActivities(to, records)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats != (importStats{Inserted: 4}) {
+				t.Errorf("got %+v, want 4 activities inserted", stats)
+			}
+			if reexported := export(to); !bytes.Equal(reexported, exported) {
+				t.Errorf("got\n%s\nwant\n%s", reexported, exported)
+			}
+
+			// importing again skips everything
+			if stats, err := importActivities(to, records); err != nil || stats != (importStats{Skipped: 4}) {
+				t.Errorf("got %+v, %v, want 4 activities skipped", stats, err)
+			}
+		})
+	}
+}
+
+// Text that's easy to get wrong in CSV and JSON
+var awkwardText = []string{"a", "Ü", "日本語", "😀", ",", `"`, "'", "\n", "\t", " ", ";", "\\", "enc:v1:", "—", "<b>"}
+
+func randomText(rng *rand.Rand, maxParts int) string {
+	var b strings.Builder
+	for i := rng.Intn(maxParts + 1); i > 0; i-- {
+		b.WriteString(awkwardText[rng.Intn(len(awkwardText))])
+	}
+	return b.String()
+}
+
+func randomLabels(rng *rand.Rand) []string {
+	var labels []string
+	for i := rng.Intn(3); i > 0; i-- {
+		label := strings.ReplaceAll(randomText(rng, 3), labelSeparator, "")
+		if label != "" {
+			labels = addLabel(labels, label)
+		}
+	}
+	return labels
+}
+
+// Random activities one after another on each of two devices, with the last one of
+// each device possibly still open. Browser activities have a URL.
+func randomActivities(rng *rand.Rand) []ActivityRecord {
+	var records []ActivityRecord
+	for _, device := range []struct{ id, hostname string }{{"laptop", "laptop.local"}, {"desktop", "Desktop, \"Home\""}} {
+		start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local).Add(time.Duration(rng.Intn(3600)) * time.Second)
+		n := rng.Intn(8)
+		for i := 0; i < n; i++ {
+			record := ActivityRecord{
+				StartTime: start,
+				Name:      "x" + randomText(rng, 4),
+				App:       randomText(rng, 3),
+				Title:     randomText(rng, 6),
+				File:      randomText(rng, 2),
+				Language:  randomText(rng, 1),
+				Category:  randomText(rng, 2),
+				DeviceID:  device.id,
+				Hostname:  device.hostname,
+				Projects:  randomLabels(rng),
+				Tags:      randomLabels(rng),
+			}
+			if rng.Intn(3) == 0 {
+				record.App = "Firefox"
+				record.URL = fmt.Sprintf("https://example%d.com/?q=%s", rng.Intn(3), strings.ReplaceAll(randomText(rng, 3), "\n", ""))
+			}
+			if rng.Intn(4) == 0 {
+				record.Source = manualSource
+				updated := start.Add(time.Duration(rng.Intn(1000)) * time.Second)
+				record.UpdatedAt = &updated
+			}
+			end := start.Add(time.Duration(1+rng.Intn(3600)) * time.Second)
+			if i < n-1 || rng.Intn(2) == 0 {
+				record.EndTime = &end
+			}
+			records = append(records, record)
+			start = end.Add(time.Duration(rng.Intn(2)) * time.Minute)
+		}
+	}
+	return records
+}
+
+func sameExportedActivity(a, b ActivityRecord) bool {
+	sameUpdate := (a.UpdatedAt == nil && b.UpdatedAt == nil) ||
+		(a.UpdatedAt != nil && b.UpdatedAt != nil && a.UpdatedAt.Equal(*b.UpdatedAt))
+	return a.ID == b.ID && a.OriginID == b.OriginID && sameUpdate && sameActivity(a, b)
+}
+
+// For random activities, reading an export gives back the exported activities, open
+// ones included, and importing it into an empty database and exporting again gives
+// the same export, apart from the open activities, which aren't imported
+func TestExportImportRandomRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		from := openTestDb(t)
+		for _, record := range randomActivities(rng) {
+			if _, err := from.insertActivity(from, record); err != nil {
+				t.Fatal(err)
+			}
+		}
+		records, err := getActivities(from, time.Unix(0, 0), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		closed := slices.DeleteFunc(slices.Clone(records), func(r ActivityRecord) bool { return r.EndTime == nil })
+
+		for _, format := range []struct {
+			name  string
+			write func(io.Writer, []ActivityRecord) error
+			read  func(io.Reader) ([]ActivityRecord, error)
+		}{
+			{"jsonl", writeJSONLines, readJSONLines},
+			{"csv", writeCSV, readCSV},
+		} {
+			var exported bytes.Buffer
+			if err := format.write(&exported, records); err != nil {
+				t.Fatal(err)
+			}
+			read, err := format.read(bytes.NewReader(exported.Bytes()))
+			if err != nil {
+				t.Fatalf("%s %d: %v\n%s", format.name, i, err, exported.String())
+			}
+			if !slices.EqualFunc(read, records, sameExportedActivity) {
+				t.Fatalf("%s %d: read back\n%+v\nwant\n%+v", format.name, i, read, records)
+			}
+
+			to := openTestDb(t)
+			stats, err := importActivities(to, read)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats != (importStats{Inserted: len(closed), Open: len(records) - len(closed)}) {
+				t.Errorf("%s %d: got %+v, want %d inserted", format.name, i, stats, len(closed))
+			}
+			imported, err := getActivities(to, time.Unix(0, 0), time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want, got bytes.Buffer
+			if err := format.write(&want, closed); err != nil {
+				t.Fatal(err)
+			}
+			if err := format.write(&got, imported); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				t.Fatalf("%s %d: got\n%s\nwant\n%s", format.name, i, got.String(), want.String())
+			}
+		}
+
+		// ActivityWatch keeps the times, app, title, URL and host of ended activities
+		var exported bytes.Buffer
+		if err := writeActivityWatch(&exported, closed, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		read, err := readActivityWatch(&exported)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sameAW := func(a, b ActivityRecord) bool {
+			app := b.App
+			if app == "" {
+				app = b.Name
+			}
+			return a.StartTime.Equal(b.StartTime) && a.EndTime.Equal(*b.EndTime) && a.App == app &&
+				a.Title == b.Title && a.URL == b.URL && a.Hostname == b.Hostname && a.DeviceID == "activitywatch:"+b.Hostname
+		}
+		sortByHostAndStart := func(records []ActivityRecord) {
+			slices.SortStableFunc(records, func(a, b ActivityRecord) int {
+				if c := strings.Compare(a.Hostname, b.Hostname); c != 0 {
+					return c
+				}
+				return a.StartTime.Compare(b.StartTime)
+			})
+		}
+		sortByHostAndStart(read)
+		sortByHostAndStart(closed)
+		if !slices.EqualFunc(read, closed, sameAW) {
+			t.Fatalf("aw %d: read back\n%+v\nwant\n%+v", i, read, closed)
+		}
+	}
+}
+
+// Activities of other devices that overlap an imported one are left alone
+func TestImportOnlyTrimsSameDevice(t *testing.T) {
+	db := openTestDb(t)
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour)
+	if _, err := db.insertActivity(db, ActivityRecord{StartTime: start, EndTime: &end, Name: "Code", DeviceID: "desktop"}); err != nil {
+		t.Fatal(err)
+	}
+	later := start.Add(30 * time.Minute)
+	laterEnd := later.Add(time.Hour)
+	stats, err := importActivities(db, []ActivityRecord{
+		{StartTime: later, EndTime: &laterEnd, Name: "Slack", DeviceID: "laptop"},
+		{StartTime: later, EndTime: &laterEnd, Name: "Mail", DeviceID: "desktop"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (importStats{Inserted: 1, Trimmed: 1}) {
+		t.Errorf("got %+v, want the laptop's activity inserted and the desktop's trimmed", stats)
+	}
+	for _, record := range mustGetActivities(t, db, start, laterEnd) {
+		want := laterEnd.Sub(later)
+		if record.Name == "Mail" {
+			want = laterEnd.Sub(end)
+		} else if record.Name == "Code" {
+			want = time.Hour
+		}
+		if got := record.EndTime.Sub(record.StartTime); got != want {
+			t.Errorf("%s lasts %s, want %s", record.Name, got, want)
+		}
+	}
+}
+
+func TestImportSkipsOpenActivities(t *testing.T) {
+	db := openTestDb(t)
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour)
+	stats, err := importActivities(db, []ActivityRecord{
+		{StartTime: start, EndTime: &end, Name: "Code", DeviceID: "laptop"},
+		{StartTime: end, Name: "Slack", DeviceID: "laptop"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (importStats{Inserted: 1, Open: 1}) {
+		t.Errorf("got %+v, want 1 inserted and 1 open", stats)
+	}
+	if current, err := getCurrentActivity(db); err != nil || current != nil {
+		t.Errorf("got %+v, %v, want no ongoing activity", current, err)
+	}
+}
+
+// A failing import leaves nothing behind
+func TestImportIsAtomic(t *testing.T) {
+	db := openTestDb(t)
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour)
+	if _, err := db.Exec(`DROP TABLE activity_tags`); err != nil {
+		t.Fatal(err)
+	}
+	_, err := importActivities(db, []ActivityRecord{
+		{StartTime: start, EndTime: &end, Name: "Code", DeviceID: "laptop"},
+		{StartTime: end, EndTime: &end, Name: "Slack", Tags: []string{"chat"}, DeviceID: "laptop"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "activity_tags") {
+		t.Fatalf("got %v, want an error labeling the second activity", err)
+	}
+	if records, err := getActivities(db, time.Unix(0, 0), time.Now()); err != nil || len(records) != 0 {
+		t.Errorf("got %d activities, %v, want none", len(records), err)
+	}
+}
+
+// Labels with the separator would be split in two when exported as CSV
+func TestLabelsWithSeparatorAreRejected(t *testing.T) {
+	db := openTestDb(t)
+	start := time.Date(2024, 11, 5, 9, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour)
+	_, err := importActivities(db, []ActivityRecord{
+		{StartTime: start, EndTime: &end, Name: "Code", Projects: []string{"acme;internal"}, DeviceID: "laptop"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "can't contain") {
+		t.Errorf("got %v, want the project rejected", err)
+	}
+	if _, err := newActivityLabeler([]LabelRule{{App: "Zoom", Tags: []string{"meeting;call"}}}); err == nil {
+		t.Error("got no error for a rule tag with the separator")
+	}
+}